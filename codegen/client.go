@@ -0,0 +1,356 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// clientSource is the part of client.go that doesn't depend on the
+// document.
+const clientSource = `// Client calls the operations of the API.
+type Client struct {
+	// BaseURL is the URL the paths of the operations are relative to.
+	BaseURL string
+	// HTTPClient sends the requests, http.DefaultClient when nil.
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client for the API served at baseURL.
+func NewClient(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// ResponseError is returned for responses with a status other than 2xx.
+type ResponseError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.StatusCode, e.Body)
+}
+
+// do sends a request with body as JSON, unless it's nil, and decodes the
+// JSON body of the response into out, unless it's nil.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, body, out interface{}) error {
+	target := c.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return &ResponseError{StatusCode: resp.StatusCode, Body: data}
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+`
+
+var pathParam = regexp.MustCompile(`{([^}]+)}`)
+
+// client returns client.go, declaring the Client with a method for each
+// operation.
+func (g *generator) client() ([]byte, error) {
+	var methods bytes.Buffer
+
+	methodNames := make(map[string]bool)
+	for _, item := range g.doc.Paths {
+		httpMethods, ops := item.operations()
+		for i, op := range ops {
+			name := goName(op.OperationId)
+			if len(op.OperationId) == 0 {
+				name = goName(strings.ToLower(httpMethods[i]) + " " + item.path)
+			}
+			for unique, n := name, 2; ; n++ {
+				if !methodNames[unique] {
+					name = unique
+					break
+				}
+				unique = fmt.Sprintf("%s%d", name, n)
+			}
+			methodNames[name] = true
+			methods.WriteString("\n")
+			if err := g.method(&methods, name, httpMethods[i], item, op); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	paths := []string{"bytes", "context", "encoding/json", "fmt", "io", "net/http", "net/url", "strings"}
+	for _, path := range imports(methods.Bytes()) {
+		if path != "encoding/json" {
+			paths = append(paths, path)
+		}
+	}
+	g.header(&buf, paths)
+	if len(g.doc.Servers) > 0 {
+		buf.WriteString("// DefaultBaseURL is the URL of the first server of the API.\n")
+		fmt.Fprintf(&buf, "const DefaultBaseURL = %q\n\n", g.doc.Servers[0].URL)
+	}
+	buf.WriteString(clientSource)
+	buf.Write(methods.Bytes())
+	return source(&buf)
+}
+
+// method writes the method of the Client calling op.  Path parameters
+// become arguments, query and header parameters the fields of an argument
+// of a type declared for the operation, and a JSON request body the last
+// argument.
+func (g *generator) method(buf *bytes.Buffer, name, method string, item *pathItem, op *operation) error {
+	params, err := g.parameters(item, op)
+	if err != nil {
+		return fmt.Errorf("%s %s: %v", method, item.path, err)
+	}
+	args := []string{"ctx context.Context"}
+	pathArgs := make(map[string]string)
+	var fields []*parameter
+	for _, param := range params {
+		switch param.In {
+		case "path":
+			arg := argName(param.Name)
+			pathArgs[param.Name] = arg
+			args = append(args, arg+" "+g.goType(param.Schema, name+goName(param.Name)))
+		case "query", "header":
+			fields = append(fields, param)
+		}
+	}
+	var paramsType string
+	if len(fields) > 0 {
+		paramsType = g.newName(name + "Params")
+		g.paramsDecl(paramsType, method, item.path, fields)
+		args = append(args, "params "+paramsType)
+	}
+	bodyType := ""
+	if body := g.requestBody(op.RequestBody); body != nil {
+		bodyType = g.goType(body, name+"Request")
+		args = append(args, "body "+bodyType)
+	}
+	resultType := ""
+	if result := g.result(op); result != nil {
+		resultType = g.goType(result, name+"Response")
+	}
+	pointer := len(resultType) > 0 && !strings.HasPrefix(resultType, "[]") && !strings.HasPrefix(resultType, "map[") &&
+		resultType != "interface{}" && resultType != "json.RawMessage"
+
+	text := fmt.Sprintf("%s calls %s %s.", name, method, item.path)
+	for _, more := range []string{op.Summary, op.Description} {
+		if len(more) > 0 {
+			text += "\n\n" + more
+		}
+	}
+	comment(buf, "", text)
+	results := "error"
+	switch {
+	case pointer:
+		results = "(*" + resultType + ", error)"
+	case len(resultType) > 0:
+		results = "(" + resultType + ", error)"
+	}
+	fmt.Fprintf(buf, "func (c *Client) %s(%s) %s {\n", name, strings.Join(args, ", "), results)
+
+	var path []string
+	rest := item.path
+	for _, match := range pathParam.FindAllStringSubmatchIndex(item.path, -1) {
+		offset := len(item.path) - len(rest)
+		if literal := rest[:match[0]-offset]; len(literal) > 0 {
+			path = append(path, fmt.Sprintf("%q", literal))
+		}
+		arg, ok := pathArgs[item.path[match[2]:match[3]]]
+		if !ok {
+			return fmt.Errorf("%s %s: path parameter %s isn't declared", method, item.path, item.path[match[2]:match[3]])
+		}
+		path = append(path, fmt.Sprintf("url.PathEscape(fmt.Sprint(%s))", arg))
+		rest = item.path[match[1]:]
+	}
+	if len(rest) > 0 || len(path) == 0 {
+		path = append(path, fmt.Sprintf("%q", rest))
+	}
+	fmt.Fprintf(buf, "\tpath := %s\n", strings.Join(path, " + "))
+
+	query, header := "nil", "nil"
+	if len(fields) > 0 {
+		query, header = "query", "header"
+		buf.WriteString("\tquery := url.Values{}\n\theader := http.Header{}\n")
+		for _, param := range fields {
+			g.setParam(buf, param)
+		}
+	}
+	body := "nil"
+	if len(bodyType) > 0 {
+		body = "body"
+	}
+	out := "nil"
+	if len(resultType) > 0 {
+		fmt.Fprintf(buf, "\tvar out %s\n", resultType)
+		out = "&out"
+	}
+	call := fmt.Sprintf("c.do(ctx, %q, path, %s, %s, %s, %s)", method, query, header, body, out)
+	switch {
+	case pointer:
+		fmt.Fprintf(buf, "\tif err := %s; err != nil {\n\t\treturn nil, err\n\t}\n\treturn &out, nil\n", call)
+	case len(resultType) > 0:
+		fmt.Fprintf(buf, "\terr := %s\n\treturn out, err\n", call)
+	default:
+		fmt.Fprintf(buf, "\treturn %s\n", call)
+	}
+	buf.WriteString("}\n")
+	return nil
+}
+
+// paramsDecl declares the type holding the query and header parameters of
+// an operation.  Optional parameters are pointers, left out when nil.
+func (g *generator) paramsDecl(name, method, path string, fields []*parameter) {
+	i := g.reserve()
+	var buf bytes.Buffer
+	comment(&buf, "", fmt.Sprintf("%s holds the parameters of %s %s.", name, method, path))
+	fmt.Fprintf(&buf, "type %s struct {\n", name)
+	for _, param := range fields {
+		fieldType := g.goType(param.Schema, name+goName(param.Name))
+		if !param.Required && !strings.HasPrefix(fieldType, "[]") {
+			fieldType = "*" + fieldType
+		}
+		if len(param.Description) > 0 {
+			comment(&buf, "\t", param.Description)
+		}
+		fmt.Fprintf(&buf, "\t%s %s\n", goName(param.Name), fieldType)
+	}
+	buf.WriteString("}\n")
+	g.decls[i] = buf.String()
+}
+
+// setParam writes the statements adding a parameter to the query or header
+// of a request.
+func (g *generator) setParam(buf *bytes.Buffer, param *parameter) {
+	values := "query"
+	if param.In == "header" {
+		values = "header"
+	}
+	field := "params." + goName(param.Name)
+	isArray := param.Schema != nil && param.Schema.Type == "array"
+	// Arrays are repeated in queries, unless explode is false, and separated
+	// by commas otherwise.
+	joined := param.In == "header" || param.Explode != nil && !*param.Explode
+	switch {
+	case isArray && joined:
+		fmt.Fprintf(buf, "\tif len(%s) > 0 {\n", field)
+		fmt.Fprintf(buf, "\t\tvalues := make([]string, len(%s))\n\t\tfor i, v := range %s {\n\t\t\tvalues[i] = fmt.Sprint(v)\n\t\t}\n", field, field)
+		fmt.Fprintf(buf, "\t\t%s.Set(%q, strings.Join(values, \",\"))\n\t}\n", values, param.Name)
+	case isArray:
+		fmt.Fprintf(buf, "\tfor _, v := range %s {\n\t\t%s.Add(%q, fmt.Sprint(v))\n\t}\n", field, values, param.Name)
+	case param.Required:
+		fmt.Fprintf(buf, "\t%s.Set(%q, fmt.Sprint(%s))\n", values, param.Name, field)
+	default:
+		fmt.Fprintf(buf, "\tif %s != nil {\n\t\t%s.Set(%q, fmt.Sprint(*%s))\n\t}\n", field, values, param.Name, field)
+	}
+}
+
+// parameters returns the parameters of op, with those of its path item it
+// doesn't override, and references resolved.
+func (g *generator) parameters(item *pathItem, op *operation) ([]*parameter, error) {
+	var params []*parameter
+	seen := make(map[string]bool)
+	for _, param := range append(append([]*parameter{}, op.Parameters...), item.Parameters...) {
+		if len(param.Ref) > 0 {
+			resolved, ok := g.doc.Components.Parameters[strings.TrimPrefix(param.Ref, "#/components/parameters/")]
+			if !ok {
+				return nil, fmt.Errorf("can't resolve %s", param.Ref)
+			}
+			param = resolved
+		}
+		if key := param.In + " " + param.Name; !seen[key] {
+			seen[key] = true
+			params = append(params, param)
+		}
+	}
+	return params, nil
+}
+
+// requestBody returns the schema of a JSON request body, or nil if there's
+// none.
+func (g *generator) requestBody(body *requestBody) *schema {
+	if body != nil && len(body.Ref) > 0 {
+		body = g.doc.Components.RequestBodies[strings.TrimPrefix(body.Ref, "#/components/requestBodies/")]
+	}
+	if body == nil {
+		return nil
+	}
+	return jsonSchema(body.Content)
+}
+
+// result returns the schema of the JSON body of the first successful
+// response of op, or nil if there's none.
+func (g *generator) result(op *operation) *schema {
+	codes := make([]string, 0, len(op.Responses))
+	for code := range op.Responses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		if !strings.HasPrefix(code, "2") {
+			continue
+		}
+		resp := op.Responses[code]
+		if resp != nil && len(resp.Ref) > 0 {
+			resp = g.doc.Components.Responses[strings.TrimPrefix(resp.Ref, "#/components/responses/")]
+		}
+		if resp == nil {
+			return nil
+		}
+		return jsonSchema(resp.Content)
+	}
+	return nil
+}
+
+// jsonSchema returns the schema of the JSON media type of content.
+func jsonSchema(content map[string]*mediaType) *schema {
+	contentTypes := make([]string, 0, len(content))
+	for contentType := range content {
+		contentTypes = append(contentTypes, contentType)
+	}
+	sort.Strings(contentTypes)
+	for _, contentType := range contentTypes {
+		media := content[contentType]
+		if media != nil && (contentType == "application/json" || strings.HasSuffix(contentType, "+json")) {
+			return media.Schema
+		}
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+// Package codegen generates Go packages from Swagger 2.0 and OpenAPI 3
+// documents: model types for their schemas and a typed client calling their
+// operations with net/http.
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/vsheffer/gofun/openapi"
+	"go/format"
+	"gopkg.in/yaml.v3"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Options controls the generated package.
+type Options struct {
+	// Package is the name of the package, "client" when empty.
+	Package string
+}
+
+// Generate returns the files of a Go package for the document in data, by
+// name: models.go declaring a type for each schema, and client.go declaring
+// a Client with a method for each operation.  References to other files have
+// to be resolved beforehand, with openapi.Bundle.
+func Generate(data []byte, opts Options) (map[string][]byte, error) {
+	if len(opts.Package) == 0 {
+		opts.Package = "client"
+	}
+	if !isIdentifier(opts.Package) {
+		return nil, fmt.Errorf("invalid package name %q", opts.Package)
+	}
+	converted, err := openapi.ConvertToOpenAPI3(data)
+	if err != nil {
+		return nil, err
+	}
+	var doc document
+	if err = yaml.Unmarshal(converted, &doc); err != nil {
+		return nil, err
+	}
+
+	g := newGenerator(&doc, opts.Package)
+	client, err := g.client()
+	if err != nil {
+		return nil, err
+	}
+	models, err := g.models()
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{"client.go": client, "models.go": models}, nil
+}
+
+// document holds the parts of an OpenAPI 3 document code is generated from.
+type document struct {
+	Info struct {
+		Title   string `yaml:"title"`
+		Version string `yaml:"version"`
+	} `yaml:"info"`
+	Servers []struct {
+		URL string `yaml:"url"`
+	} `yaml:"servers"`
+	Paths      pathItems `yaml:"paths"`
+	Components struct {
+		Schemas       namedSchemas            `yaml:"schemas"`
+		Parameters    map[string]*parameter   `yaml:"parameters"`
+		RequestBodies map[string]*requestBody `yaml:"requestBodies"`
+		Responses     map[string]*response    `yaml:"responses"`
+	} `yaml:"components"`
+}
+
+type schema struct {
+	Ref                  string        `yaml:"$ref"`
+	Type                 string        `yaml:"type"`
+	Format               string        `yaml:"format"`
+	Description          string        `yaml:"description"`
+	Items                *schema       `yaml:"items"`
+	Properties           namedSchemas  `yaml:"properties"`
+	Required             []string      `yaml:"required"`
+	AdditionalProperties *additional   `yaml:"additionalProperties"`
+	Enum                 []interface{} `yaml:"enum"`
+	AllOf                []*schema     `yaml:"allOf"`
+	OneOf                []*schema     `yaml:"oneOf"`
+	AnyOf                []*schema     `yaml:"anyOf"`
+}
+
+// additional is the additionalProperties of a schema: true, false or the
+// schema of the values.
+type additional struct {
+	allowed bool
+	schema  *schema
+}
+
+func (a *additional) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		return n.Decode(&a.allowed)
+	}
+	a.allowed = true
+	a.schema = &schema{}
+	return n.Decode(a.schema)
+}
+
+type namedSchema struct {
+	name   string
+	schema *schema
+}
+
+// namedSchemas are the schemas of a mapping, in document order.
+type namedSchemas []namedSchema
+
+func (ns *namedSchemas) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping of schemas", n.Line)
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		var s schema
+		if err := n.Content[i+1].Decode(&s); err != nil {
+			return err
+		}
+		*ns = append(*ns, namedSchema{n.Content[i].Value, &s})
+	}
+	return nil
+}
+
+type parameter struct {
+	Ref         string  `yaml:"$ref"`
+	Name        string  `yaml:"name"`
+	In          string  `yaml:"in"`
+	Description string  `yaml:"description"`
+	Required    bool    `yaml:"required"`
+	Explode     *bool   `yaml:"explode"`
+	Schema      *schema `yaml:"schema"`
+}
+
+type mediaType struct {
+	Schema *schema `yaml:"schema"`
+}
+
+type requestBody struct {
+	Ref      string                `yaml:"$ref"`
+	Required bool                  `yaml:"required"`
+	Content  map[string]*mediaType `yaml:"content"`
+}
+
+type response struct {
+	Ref     string                `yaml:"$ref"`
+	Content map[string]*mediaType `yaml:"content"`
+}
+
+type operation struct {
+	OperationId string               `yaml:"operationId"`
+	Summary     string               `yaml:"summary"`
+	Description string               `yaml:"description"`
+	Parameters  []*parameter         `yaml:"parameters"`
+	RequestBody *requestBody         `yaml:"requestBody"`
+	Responses   map[string]*response `yaml:"responses"`
+}
+
+type pathItem struct {
+	path       string
+	Parameters []*parameter `yaml:"parameters"`
+	Get        *operation   `yaml:"get"`
+	Put        *operation   `yaml:"put"`
+	Post       *operation   `yaml:"post"`
+	Delete     *operation   `yaml:"delete"`
+	Options    *operation   `yaml:"options"`
+	Head       *operation   `yaml:"head"`
+	Patch      *operation   `yaml:"patch"`
+	Trace      *operation   `yaml:"trace"`
+}
+
+// operations returns the operations of the path item by method, in the
+// order the specification lists methods.
+func (item *pathItem) operations() ([]string, []*operation) {
+	var methods []string
+	var ops []*operation
+	for _, op := range []struct {
+		method string
+		op     *operation
+	}{
+		{"GET", item.Get}, {"PUT", item.Put}, {"POST", item.Post}, {"DELETE", item.Delete},
+		{"OPTIONS", item.Options}, {"HEAD", item.Head}, {"PATCH", item.Patch}, {"TRACE", item.Trace},
+	} {
+		if op.op != nil {
+			methods = append(methods, op.method)
+			ops = append(ops, op.op)
+		}
+	}
+	return methods, ops
+}
+
+// pathItems are the path items of the document, in document order.
+type pathItems []*pathItem
+
+func (items *pathItems) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping of paths", n.Line)
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		item := &pathItem{path: n.Content[i].Value}
+		if err := n.Content[i+1].Decode(item); err != nil {
+			return err
+		}
+		*items = append(*items, item)
+	}
+	return nil
+}
+
+// generator generates the package for a document.  The types it needs are
+// declared in models.go in the order they are first needed, after the
+// schemas.
+type generator struct {
+	doc *document
+	pkg string
+	// schemaTypes maps the names of the schemas of the document to the names
+	// of their types.
+	schemaTypes map[string]string
+	// taken holds the names declared in the package.
+	taken map[string]bool
+	decls []string
+}
+
+func newGenerator(doc *document, pkg string) *generator {
+	g := &generator{
+		doc:         doc,
+		pkg:         pkg,
+		schemaTypes: make(map[string]string),
+		taken:       map[string]bool{"Client": true, "NewClient": true, "ResponseError": true, "DefaultBaseURL": true},
+	}
+	for _, s := range doc.Components.Schemas {
+		g.schemaTypes[s.name] = g.newName(goName(s.name))
+	}
+	return g
+}
+
+// newName returns name, or name with a number appended when it's taken, and
+// takes it.
+func (g *generator) newName(name string) string {
+	unique := name
+	for i := 2; g.taken[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	g.taken[unique] = true
+	return unique
+}
+
+// source formats the Go source of a file.
+func source(buf *bytes.Buffer) ([]byte, error) {
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid Go: %v\n%s", err, buf.Bytes())
+	}
+	return formatted, nil
+}
+
+// header writes the start of a generated file, with its imports.
+func (g *generator) header(buf *bytes.Buffer, imports []string) {
+	fmt.Fprintf(buf, "// Code generated from %s %s. DO NOT EDIT.\n\n", g.doc.Info.Title, g.doc.Info.Version)
+	fmt.Fprintf(buf, "package %s\n\n", g.pkg)
+	if len(imports) > 0 {
+		sort.Strings(imports)
+		buf.WriteString("import (\n")
+		for _, path := range imports {
+			fmt.Fprintf(buf, "\t%q\n", path)
+		}
+		buf.WriteString(")\n\n")
+	}
+}
+
+// comment writes text as a Go comment indented by indent.
+func comment(buf *bytes.Buffer, indent, text string) {
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if len(line) == 0 {
+			fmt.Fprintf(buf, "%s//\n", indent)
+		} else {
+			fmt.Fprintf(buf, "%s// %s\n", indent, line)
+		}
+	}
+}
+
+// goName turns a name from a document into an exported Go identifier, such
+// as PetId for pet_id or petId.
+func goName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			upper = true
+		case upper:
+			b.WriteRune(unicode.ToUpper(r))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	s := b.String()
+	if len(s) == 0 {
+		return "X"
+	}
+	if unicode.IsDigit(rune(s[0])) {
+		return "N" + s
+	}
+	return s
+}
+
+// reserved are the names generated methods use for their own arguments and
+// variables, besides Go's keywords.
+var reserved = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true, "default": true,
+	"defer": true, "else": true, "fallthrough": true, "for": true, "func": true, "go": true, "goto": true,
+	"if": true, "import": true, "interface": true, "map": true, "package": true, "range": true,
+	"return": true, "select": true, "struct": true, "switch": true, "type": true, "var": true,
+	"ctx": true, "params": true, "body": true, "path": true, "query": true, "header": true, "out": true,
+	"err": true, "c": true, "url": true, "fmt": true, "http": true,
+}
+
+// argName turns a name from a document into an unexported Go identifier
+// that doesn't clash with a keyword or a generated variable.
+func argName(name string) string {
+	s := []rune(goName(name))
+	s[0] = unicode.ToLower(s[0])
+	arg := string(s)
+	if reserved[arg] {
+		arg += "Param"
+	}
+	return arg
+}
+
+func isIdentifier(s string) bool {
+	for i, r := range s {
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return len(s) > 0 && !reserved[s]
+}
@@ -0,0 +1,96 @@
+package codegen
+
+import (
+	"bytes"
+	"flag"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestGenerate compares the package generated for each spec in testdata with
+// the golden files in the directory named after it, and checks it compiles.
+func TestGenerate(t *testing.T) {
+	specs, _ := filepath.Glob(filepath.Join("testdata", "*.yaml"))
+	if len(specs) == 0 {
+		t.Fatal("No specs in testdata")
+	}
+	for _, spec := range specs {
+		name := strings.TrimSuffix(filepath.Base(spec), ".yaml")
+		data, err := os.ReadFile(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files, err := Generate(data, Options{Package: name})
+		if err != nil {
+			t.Errorf("Generate(%s): %v", spec, err)
+			continue
+		}
+		for fileName, content := range files {
+			golden := filepath.Join("testdata", name, fileName+".golden")
+			if *update {
+				os.MkdirAll(filepath.Dir(golden), 0755)
+				if err = os.WriteFile(golden, content, 0644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Errorf("%v, run with -update to create it", err)
+			} else if !bytes.Equal(content, want) {
+				t.Errorf("%s differs from %s:\n%s", fileName, golden, content)
+			}
+		}
+		typeCheck(t, name, files)
+	}
+}
+
+func typeCheck(t *testing.T, name string, files map[string][]byte) {
+	fset := token.NewFileSet()
+	var parsed []*ast.File
+	for fileName, content := range files {
+		f, err := parser.ParseFile(fset, fileName, content, 0)
+		if err != nil {
+			t.Fatalf("%s/%s: %v", name, fileName, err)
+		}
+		parsed = append(parsed, f)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check(name, fset, parsed, nil); err != nil {
+		t.Errorf("The %s package doesn't compile: %v", name, err)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	if _, err := Generate([]byte("openapi: 3.0.3\npaths: {}\n"), Options{Package: "func"}); err == nil {
+		t.Error("A keyword was taken as the package name")
+	}
+	undeclared := "openapi: 3.0.3\npaths:\n  /pets/{id}:\n    get:\n      responses: {}\n"
+	if _, err := Generate([]byte(undeclared), Options{}); err == nil || !strings.Contains(err.Error(), "isn't declared") {
+		t.Errorf("An undeclared path parameter wasn't reported: %v", err)
+	}
+}
+
+func TestGoName(t *testing.T) {
+	for name, want := range map[string]string{
+		"petId":          "PetId",
+		"pet_id":         "PetId",
+		"X-Request-Id":   "XRequestId",
+		"get /pets/{id}": "GetPetsId",
+		"200":            "N200",
+		"":               "X",
+	} {
+		if got := goName(name); got != want {
+			t.Errorf("goName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// models returns models.go, declaring the types of the schemas and the
+// types declared while generating the client.
+func (g *generator) models() ([]byte, error) {
+	schemaDecls := make([]string, 0, len(g.doc.Components.Schemas))
+	clientDecls := g.decls
+	g.decls = nil
+	for _, s := range g.doc.Components.Schemas {
+		i := g.reserve()
+		g.decls[i] = g.schemaDecl(g.schemaTypes[s.name], s.name, s.schema)
+		schemaDecls = append(schemaDecls, g.decls...)
+		g.decls = nil
+	}
+
+	var decls bytes.Buffer
+	for _, decl := range append(schemaDecls, clientDecls...) {
+		decls.WriteString(decl)
+		decls.WriteString("\n")
+	}
+	var buf bytes.Buffer
+	g.header(&buf, imports(decls.Bytes()))
+	buf.Write(decls.Bytes())
+	return source(&buf)
+}
+
+// reserve makes room for a declaration, so that it comes before the ones
+// made while it is generated.
+func (g *generator) reserve() int {
+	g.decls = append(g.decls, "")
+	return len(g.decls) - 1
+}
+
+// schemaDecl declares the type called name for the schema called
+// schemaName.
+func (g *generator) schemaDecl(name, schemaName string, s *schema) string {
+	var buf bytes.Buffer
+	text := fmt.Sprintf("%s is the %s schema.", name, schemaName)
+	if len(s.Description) > 0 {
+		text += "\n\n" + s.Description
+	}
+	comment(&buf, "", text)
+
+	switch {
+	case isStruct(s):
+		g.structType(&buf, name, s)
+	case s.Type == "string" && len(s.Enum) > 0:
+		fmt.Fprintf(&buf, "type %s string\n\n", name)
+		buf.WriteString("const (\n")
+		for _, value := range s.Enum {
+			str := fmt.Sprint(value)
+			fmt.Fprintf(&buf, "\t%s %s = %s\n", g.newName(name+goName(str)), name, strconv.Quote(str))
+		}
+		buf.WriteString(")\n")
+	default:
+		fmt.Fprintf(&buf, "type %s %s\n", name, g.goType(s, name+"Item"))
+	}
+	return buf.String()
+}
+
+// isStruct tells whether s is an object with properties, which becomes a
+// struct.
+func isStruct(s *schema) bool {
+	return len(s.Ref) == 0 && len(s.OneOf)+len(s.AnyOf) == 0 && len(s.Properties)+len(s.AllOf) > 0
+}
+
+// structType writes a struct declaration for s.  The schemas s is made of
+// with allOf are embedded when they are references, and their properties
+// added otherwise.
+func (g *generator) structType(buf *bytes.Buffer, name string, s *schema) {
+	fmt.Fprintf(buf, "type %s struct {\n", name)
+	parts := append([]*schema{s}, s.AllOf...)
+	for _, part := range parts {
+		if len(part.Ref) > 0 {
+			fmt.Fprintf(buf, "\t%s\n", g.goType(part, ""))
+			continue
+		}
+		required := make(map[string]bool)
+		for _, field := range part.Required {
+			required[field] = true
+		}
+		for _, property := range part.Properties {
+			fieldName := goName(property.name)
+			fieldType := g.goType(property.schema, name+fieldName)
+			tag := property.name
+			if !required[property.name] {
+				tag += ",omitempty"
+				if !strings.HasPrefix(fieldType, "[]") && !strings.HasPrefix(fieldType, "map[") &&
+					fieldType != "interface{}" && fieldType != "json.RawMessage" {
+					fieldType = "*" + fieldType
+				}
+			}
+			if len(property.schema.Description) > 0 {
+				comment(buf, "\t", property.schema.Description)
+			}
+			fmt.Fprintf(buf, "\t%s %s `json:%q`\n", fieldName, fieldType, tag)
+		}
+	}
+	buf.WriteString("}\n")
+}
+
+// goType returns the Go type for s.  Objects that aren't a schema of the
+// document are declared as name.
+func (g *generator) goType(s *schema, name string) string {
+	if s == nil {
+		return "interface{}"
+	}
+	if len(s.Ref) > 0 {
+		if t, ok := g.schemaTypes[strings.TrimPrefix(s.Ref, "#/components/schemas/")]; ok {
+			return t
+		}
+		return "interface{}"
+	}
+	if len(s.OneOf) > 0 || len(s.AnyOf) > 0 {
+		return "json.RawMessage"
+	}
+
+	switch s.Type {
+	case "string":
+		switch s.Format {
+		case "date-time":
+			return "time.Time"
+		case "byte":
+			return "[]byte"
+		}
+		return "string"
+	case "integer":
+		switch s.Format {
+		case "int32":
+			return "int32"
+		case "int64":
+			return "int64"
+		}
+		return "int"
+	case "number":
+		if s.Format == "float" {
+			return "float32"
+		}
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		return "[]" + g.goType(s.Items, name+"Item")
+	}
+
+	if isStruct(s) {
+		name = g.newName(name)
+		i := g.reserve()
+		var buf bytes.Buffer
+		comment(&buf, "", name+" is an inline schema.")
+		g.structType(&buf, name, s)
+		g.decls[i] = buf.String()
+		return name
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties.schema != nil {
+		return "map[string]" + g.goType(s.AdditionalProperties.schema, name+"Value")
+	}
+	if s.Type == "object" || s.AdditionalProperties != nil {
+		return "map[string]interface{}"
+	}
+	return "interface{}"
+}
+
+// imports returns the packages declarations of types use.
+func imports(decls []byte) []string {
+	var paths []string
+	if bytes.Contains(decls, []byte("json.RawMessage")) {
+		paths = append(paths, "encoding/json")
+	}
+	if bytes.Contains(decls, []byte("time.Time")) {
+		paths = append(paths, "time")
+	}
+	return paths
+}
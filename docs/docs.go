@@ -0,0 +1,473 @@
+// Package docs renders Swagger 2.0 and OpenAPI 3 documents as HTML pages
+// listing their operations, parameters, schemas and examples, with the
+// templates and style sheet they need embedded.
+package docs
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vsheffer/gofun/openapi"
+	"gopkg.in/yaml.v3"
+	"html"
+	"html/template"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//go:embed templates/*.html assets/*.css
+var files embed.FS
+
+var templates = template.Must(template.New("").ParseFS(files, "templates/*.html"))
+
+// Options says where a document comes from, for the page to show.
+type Options struct {
+	File   string
+	Commit string
+}
+
+// A Link is an entry of an index page.
+type Link struct {
+	Name  string
+	Title string
+	URL   string
+}
+
+type page struct {
+	Options
+	CSS         template.CSS
+	Title       string
+	Version     string
+	Description string
+	Servers     []string
+	Tags        []*tag
+	Schemas     []*schema
+}
+
+// tag groups the operations of a tag, or those without any.
+type tag struct {
+	Name        string
+	Description string
+	Operations  []*operation
+}
+
+type operation struct {
+	Anchor      string
+	Method      string
+	Path        string
+	OperationId string
+	Summary     string
+	Description string
+	Deprecated  bool
+	Parameters  []*parameter
+	RequestBody *body
+	Responses   []*response
+}
+
+type parameter struct {
+	Name        string
+	In          string
+	Required    bool
+	Description string
+	Type        template.HTML
+}
+
+type body struct {
+	Description string
+	Required    bool
+	Content     []*media
+}
+
+type media struct {
+	Type   string
+	Schema template.HTML
+	// Properties lists the properties of schemas that aren't components.
+	Properties []*parameter
+	Examples   []*example
+}
+
+type example struct {
+	Name  string
+	Value string
+}
+
+type response struct {
+	Code        string
+	Description string
+	Headers     []*parameter
+	Content     []*media
+}
+
+type schema struct {
+	Anchor      string
+	Name        string
+	Description string
+	Type        template.HTML
+	Properties  []*parameter
+	Examples    []*example
+}
+
+// Render writes the page documenting the document in data.  References to
+// other files have to be resolved beforehand, with openapi.Bundle.
+func Render(w io.Writer, data []byte, opts Options) error {
+	converted, err := openapi.ConvertToOpenAPI3(data)
+	if err != nil {
+		return err
+	}
+	root, errs := openapi.Parse(converted)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	if child(root, "openapi") == nil {
+		return errors.New("not a Swagger or OpenAPI document")
+	}
+	css, err := files.ReadFile("assets/style.css")
+	if err != nil {
+		return err
+	}
+
+	p := &page{Options: opts, CSS: template.CSS(css)}
+	d := &document{root: root}
+	info := child(root, "info")
+	p.Title = text(child(info, "title"))
+	p.Version = text(child(info, "version"))
+	p.Description = text(child(info, "description"))
+	if len(p.Title) == 0 {
+		p.Title = opts.File
+	}
+	for _, server := range nodes(child(root, "servers")) {
+		p.Servers = append(p.Servers, text(child(server, "url")))
+	}
+
+	tags := make(map[string]*tag)
+	for _, t := range nodes(child(root, "tags")) {
+		name := text(child(t, "name"))
+		tags[name] = &tag{Name: name, Description: text(child(t, "description"))}
+		p.Tags = append(p.Tags, tags[name])
+	}
+	untagged := &tag{}
+	openapi.Walk(child(root, "paths"), func(key, item *yaml.Node) {
+		item = d.deref(item)
+		openapi.Walk(item, func(method, op *yaml.Node) {
+			if !isMethod(method.Value) {
+				return
+			}
+			o := d.operation(strings.ToUpper(method.Value), key.Value, item, op)
+			opTags := nodes(child(op, "tags"))
+			if len(opTags) == 0 {
+				untagged.Operations = append(untagged.Operations, o)
+			}
+			for _, name := range opTags {
+				t, ok := tags[name.Value]
+				if !ok {
+					t = &tag{Name: name.Value}
+					tags[name.Value] = t
+					p.Tags = append(p.Tags, t)
+				}
+				t.Operations = append(t.Operations, o)
+			}
+		})
+	})
+	if len(untagged.Operations) > 0 {
+		p.Tags = append(p.Tags, untagged)
+	}
+
+	openapi.Walk(child(child(root, "components"), "schemas"), func(key, n *yaml.Node) {
+		s := &schema{
+			Anchor:      schemaAnchor(key.Value),
+			Name:        key.Value,
+			Description: text(child(n, "description")),
+			Type:        d.typeOf(n),
+		}
+		s.Properties = d.properties(n)
+		if ex := child(n, "example"); ex != nil {
+			s.Examples = []*example{{Value: format(ex)}}
+		}
+		p.Schemas = append(p.Schemas, s)
+	})
+
+	var buf bytes.Buffer
+	if err = templates.ExecuteTemplate(&buf, "spec.html", p); err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// RenderIndex writes a page linking to the pages of documents.
+func RenderIndex(w io.Writer, title string, links []Link) error {
+	css, err := files.ReadFile("assets/style.css")
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = templates.ExecuteTemplate(&buf, "index.html", struct {
+		CSS   template.CSS
+		Title string
+		Links []Link
+	}{template.CSS(css), title, links})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// document looks up references in the document being rendered.
+type document struct {
+	root *yaml.Node
+}
+
+// deref follows n while it's a reference.
+func (d *document) deref(n *yaml.Node) *yaml.Node {
+	for i := 0; n != nil && i < 32; i++ {
+		ref := child(n, "$ref")
+		if ref == nil {
+			return n
+		}
+		n = openapi.LookupRef(d.root, ref.Value)
+	}
+	return nil
+}
+
+func (d *document) operation(method, path string, item, op *yaml.Node) *operation {
+	o := &operation{
+		Anchor:      operationAnchor(method, path),
+		Method:      method,
+		Path:        path,
+		OperationId: text(child(op, "operationId")),
+		Summary:     text(child(op, "summary")),
+		Description: text(child(op, "description")),
+		Deprecated:  text(child(op, "deprecated")) == "true",
+	}
+	seen := make(map[string]bool)
+	for _, params := range []*yaml.Node{child(op, "parameters"), child(item, "parameters")} {
+		for _, n := range nodes(params) {
+			n = d.deref(n)
+			key := text(child(n, "in")) + " " + text(child(n, "name"))
+			if n == nil || seen[key] {
+				continue
+			}
+			seen[key] = true
+			o.Parameters = append(o.Parameters, d.parameter(n))
+		}
+	}
+	if n := d.deref(child(op, "requestBody")); n != nil {
+		o.RequestBody = &body{
+			Description: text(child(n, "description")),
+			Required:    text(child(n, "required")) == "true",
+			Content:     d.content(child(n, "content")),
+		}
+	}
+	openapi.Walk(child(op, "responses"), func(code, n *yaml.Node) {
+		n = d.deref(n)
+		r := &response{Code: code.Value, Description: text(child(n, "description")), Content: d.content(child(n, "content"))}
+		openapi.Walk(child(n, "headers"), func(name, header *yaml.Node) {
+			h := d.parameter(d.deref(header))
+			h.Name = name.Value
+			r.Headers = append(r.Headers, h)
+		})
+		o.Responses = append(o.Responses, r)
+	})
+	return o
+}
+
+func (d *document) parameter(n *yaml.Node) *parameter {
+	return &parameter{
+		Name:        text(child(n, "name")),
+		In:          text(child(n, "in")),
+		Required:    text(child(n, "required")) == "true",
+		Description: text(child(n, "description")),
+		Type:        d.typeOf(child(n, "schema")),
+	}
+}
+
+func (d *document) content(n *yaml.Node) []*media {
+	var content []*media
+	openapi.Walk(n, func(contentType, n *yaml.Node) {
+		m := &media{Type: contentType.Value, Schema: d.typeOf(child(n, "schema"))}
+		if schema := child(n, "schema"); child(schema, "$ref") == nil {
+			m.Properties = d.properties(schema)
+		}
+		if ex := child(n, "example"); ex != nil {
+			m.Examples = append(m.Examples, &example{Value: format(ex)})
+		}
+		openapi.Walk(child(n, "examples"), func(name, ex *yaml.Node) {
+			if value := child(d.deref(ex), "value"); value != nil {
+				m.Examples = append(m.Examples, &example{Name: name.Value, Value: format(value)})
+			}
+		})
+		if ex := child(d.deref(child(n, "schema")), "example"); ex != nil && len(m.Examples) == 0 {
+			m.Examples = append(m.Examples, &example{Value: format(ex)})
+		}
+		content = append(content, m)
+	})
+	return content
+}
+
+// properties lists the properties of an object schema, with those of the
+// schemas it's made of with allOf.
+func (d *document) properties(n *yaml.Node) []*parameter {
+	var properties []*parameter
+	required := make(map[string]bool)
+	for _, name := range nodes(child(n, "required")) {
+		required[name.Value] = true
+	}
+	openapi.Walk(child(n, "properties"), func(name, property *yaml.Node) {
+		properties = append(properties, &parameter{
+			Name:        name.Value,
+			Required:    required[name.Value],
+			Description: text(child(d.deref(property), "description")),
+			Type:        d.typeOf(property),
+		})
+	})
+	for _, part := range nodes(child(n, "allOf")) {
+		if child(part, "$ref") == nil {
+			properties = append(properties, d.properties(part)...)
+		}
+	}
+	return properties
+}
+
+// typeOf describes the type of a schema, linking the schemas it refers to.
+func (d *document) typeOf(n *yaml.Node) template.HTML {
+	if n == nil {
+		return ""
+	}
+	if ref := child(n, "$ref"); ref != nil {
+		name := strings.TrimPrefix(ref.Value, "#/components/schemas/")
+		if name == ref.Value {
+			return template.HTML(html.EscapeString(ref.Value))
+		}
+		return template.HTML(fmt.Sprintf(`<a href="#%s">%s</a>`, schemaAnchor(name), html.EscapeString(name)))
+	}
+	for _, keyword := range []string{"allOf", "oneOf", "anyOf"} {
+		if parts := nodes(child(n, keyword)); len(parts) > 0 {
+			types := make([]string, 0, len(parts))
+			for _, part := range parts {
+				types = append(types, string(d.typeOf(part)))
+			}
+			words := map[string]string{"allOf": "all of", "oneOf": "one of", "anyOf": "any of"}[keyword]
+			return template.HTML(words + " " + strings.Join(types, ", "))
+		}
+	}
+
+	t := text(child(n, "type"))
+	switch {
+	case t == "array" || len(t) == 0 && child(n, "items") != nil:
+		if items := child(n, "items"); items != nil {
+			return "array of " + d.typeOf(items)
+		}
+		return "array"
+	case len(t) == 0 && child(n, "properties") != nil:
+		t = "object"
+	case len(t) == 0:
+		t = "any"
+	}
+	desc := html.EscapeString(t)
+	if f := text(child(n, "format")); len(f) > 0 {
+		desc += " (" + html.EscapeString(f) + ")"
+	}
+	if additional := child(n, "additionalProperties"); additional != nil && additional.Kind == yaml.MappingNode {
+		desc = "map of " + string(d.typeOf(additional))
+	}
+	if values := nodes(child(n, "enum")); len(values) > 0 {
+		quoted := make([]string, 0, len(values))
+		for _, value := range values {
+			quoted = append(quoted, "<code>"+html.EscapeString(value.Value)+"</code>")
+		}
+		desc += ", one of " + strings.Join(quoted, ", ")
+	}
+	if text(child(n, "nullable")) == "true" {
+		desc += ", nullable"
+	}
+	return template.HTML(desc)
+}
+
+// child returns the value of key in mapping n, or nil.
+func child(n *yaml.Node, key string) *yaml.Node {
+	var value *yaml.Node
+	openapi.Walk(n, func(k, v *yaml.Node) {
+		if value == nil && k.Value == key {
+			value = v
+		}
+	})
+	return value
+}
+
+// nodes returns the items of sequence n.
+func nodes(n *yaml.Node) []*yaml.Node {
+	if n == nil || n.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return n.Content
+}
+
+func text(n *yaml.Node) string {
+	if n == nil || n.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return n.Value
+}
+
+func isMethod(key string) bool {
+	switch key {
+	case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+		return true
+	}
+	return false
+}
+
+var nonWord = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+func operationAnchor(method, path string) string {
+	return "op-" + strings.ToLower(method) + "-" + strings.Trim(nonWord.ReplaceAllString(path, "-"), "-")
+}
+
+func schemaAnchor(name string) string {
+	return "schema-" + nonWord.ReplaceAllString(name, "-")
+}
+
+// format returns an example as indented JSON.
+func format(n *yaml.Node) string {
+	data, err := json.MarshalIndent(plain(n), "", "  ")
+	if err != nil {
+		return n.Value
+	}
+	return string(data)
+}
+
+// plain turns a YAML node into the values encoding/json encodes.
+func plain(n *yaml.Node) interface{} {
+	switch n.Kind {
+	case yaml.AliasNode:
+		return plain(n.Alias)
+	case yaml.MappingNode:
+		m := make(map[string]interface{}, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			m[n.Content[i].Value] = plain(n.Content[i+1])
+		}
+		return m
+	case yaml.SequenceNode:
+		list := make([]interface{}, 0, len(n.Content))
+		for _, c := range n.Content {
+			list = append(list, plain(c))
+		}
+		return list
+	}
+	switch n.Tag {
+	case "!!null":
+		return nil
+	case "!!bool":
+		return n.Value == "true"
+	case "!!int", "!!float":
+		if _, err := strconv.ParseFloat(n.Value, 64); err == nil {
+			return json.Number(n.Value)
+		}
+	}
+	return n.Value
+}
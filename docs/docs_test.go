@@ -0,0 +1,116 @@
+package docs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const petstore = `swagger: "2.0"
+info:
+  title: Petstore
+  version: "1.0"
+  description: Pets <script>alert(1)</script> for sale.
+host: pets.example.com
+basePath: /v1
+tags:
+  - name: pets
+    description: Everything about pets.
+paths:
+  /pets/{petId}:
+    parameters:
+      - name: petId
+        in: path
+        required: true
+        type: integer
+        format: int64
+    get:
+      tags: [pets]
+      summary: Finds a pet.
+      responses:
+        200:
+          description: The pet.
+          schema:
+            $ref: '#/definitions/Pet'
+          examples:
+            application/json:
+              id: 1
+              name: Rex
+  /health:
+    get:
+      deprecated: true
+      responses:
+        204:
+          description: Healthy.
+definitions:
+  Pet:
+    type: object
+    required: [name]
+    properties:
+      id:
+        type: integer
+        format: int64
+      name:
+        type: string
+      tags:
+        type: array
+        items:
+          $ref: '#/definitions/Tag'
+      status:
+        type: string
+        enum: [available, sold]
+  Tag:
+    type: string
+    example: friendly
+`
+
+func TestRender(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Render(&buf, []byte(petstore), Options{File: "pets.yaml", Commit: "0123456789abcdef"}); err != nil {
+		t.Fatal(err)
+	}
+	page := buf.String()
+	for _, want := range []string{
+		`<title>Petstore 1.0</title>`,
+		`pets.yaml at <code>0123456789ab</code>`,
+		`Pets &lt;script&gt;alert(1)&lt;/script&gt; for sale.`,
+		`<code>//pets.example.com/v1</code>`,
+		`<li><a href="#op-get-pets-petId"><span class="method GET">GET</span> /pets/{petId}</a></li>`,
+		`<article class="operation" id="op-get-pets-petId">`,
+		`<article class="operation deprecated" id="op-get-health">`,
+		`<h2>Other operations</h2>`,
+		`<td><code>petId</code> <small>path</small> <span class="required">required</span></td>`,
+		`<td>integer (int64)</td>`,
+		`<code>application/json</code>: <a href="#schema-Pet">Pet</a>`,
+		"&#34;name&#34;: &#34;Rex&#34;",
+		`<article class="schema" id="schema-Pet">`,
+		`<td>array of <a href="#schema-Tag">Tag</a></td>`,
+		`<td>string, one of <code>available</code>, <code>sold</code></td>`,
+		"<pre>&#34;friendly&#34;</pre>",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("The page doesn't contain %s:\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, "<script>") {
+		t.Error("The description isn't escaped")
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Render(&buf, []byte("type: string\n"), Options{}); err == nil {
+		t.Error("A schema was rendered as a document")
+	}
+}
+
+func TestRenderIndex(t *testing.T) {
+	var buf bytes.Buffer
+	links := []Link{{Name: "pets.yaml", Title: "Petstore", URL: "docs/pets.yaml?ref=abc&x=1"}}
+	if err := RenderIndex(&buf, "Specs", links); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `<a href="docs/pets.yaml?ref=abc&amp;x=1">pets.yaml</a> <small>Petstore</small>`) {
+		t.Errorf("Unexpected index:\n%s", buf.String())
+	}
+}
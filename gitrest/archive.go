@@ -0,0 +1,339 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/vsheffer/gofun/openapi"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxImportSize caps the size of an uploaded archive.
+const maxImportSize = 32 << 20
+
+// maxImportedSize caps the size of the files in an archive once they are
+// decompressed, which a small archive of repetitive data can make huge.
+const maxImportedSize = 64 << 20
+
+var errArchiveTooLarge = fmt.Errorf("the files in the archive take more than %d MB", maxImportedSize>>20)
+
+type ImportResponse struct {
+	Status   string                               `json:"status"`
+	Message  string                               `json:"message"`
+	Commit   string                               `json:"commit,omitempty"`
+	DryRun   bool                                 `json:"dryRun"`
+	Added    []string                             `json:"added"`
+	Modified []string                             `json:"modified"`
+	Deleted  []string                             `json:"deleted"`
+	Errors   map[string][]openapi.ValidationError `json:"errors,omitempty"`
+}
+
+// committedFiles returns the content of every file committed at ref.  A
+// branch without commits has none.
+func (s *server) committedFiles(ref string) (map[string][]byte, error) {
+	changes, err := s.store.Diff("", ref)
+	if err != nil {
+		if strings.HasPrefix(ref, "refs/heads/") && errorStatus(err) == http.StatusNotFound {
+			return map[string][]byte{}, nil
+		}
+		return nil, err
+	}
+	files := make(map[string][]byte, len(changes))
+	for _, change := range changes {
+		files[change.Path] = change.Content
+	}
+	return files, nil
+}
+
+// archiveHandler streams the spec files at a ref the user may read as a
+// tar.gz, or as a zip with ?format=zip.
+func (s *server) archiveHandler(w http.ResponseWriter, r *http.Request) error {
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = "tar.gz"
+	}
+	if format != "tar.gz" && format != "zip" {
+		return withStatus(http.StatusBadRequest, fmt.Errorf("unknown format %q, use tar.gz or zip", format))
+	}
+	ref, err := s.requestRef(r)
+	if err != nil {
+		return err
+	}
+	commit, err := s.store.LookupCommit(ref)
+	if err != nil {
+		return withStatus(http.StatusNotFound, err)
+	}
+	files, err := s.committedFiles(commit.Id)
+	if err != nil {
+		return err
+	}
+	fileNames := make([]string, 0, len(files))
+	for fileName := range files {
+		if checkFileName(fileName) == nil && s.userRole(r, fileName) >= RoleReader {
+			fileNames = append(fileNames, fileName)
+		}
+	}
+	sort.Strings(fileNames)
+
+	name := s.name
+	if len(name) == 0 {
+		name = "specs"
+	}
+	name = fmt.Sprintf("%s-%.12s.%s", name, commit.Id, format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		return writeZip(w, fileNames, files, commit.Time)
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	return writeTarGz(w, fileNames, files, commit.Time)
+}
+
+func writeTarGz(w io.Writer, fileNames []string, files map[string][]byte, modTime time.Time) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, fileName := range fileNames {
+		content := files[fileName]
+		header := &tar.Header{Name: fileName, Mode: 0644, Size: int64(len(content)), ModTime: modTime, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeZip(w io.Writer, fileNames []string, files map[string][]byte, modTime time.Time) error {
+	zw := zip.NewWriter(w)
+	for _, fileName := range fileNames {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: fileName, Method: zip.Deflate, Modified: modTime})
+		if err != nil {
+			return err
+		}
+		if _, err = f.Write(files[fileName]); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// archivePath cleans up the path of an archive entry.  It returns an empty
+// path for hidden files, which are skipped, and an error for paths leaving
+// the archive.
+func archivePath(name string) (string, error) {
+	var elems []string
+	for _, elem := range strings.Split(strings.ReplaceAll(name, "\\", "/"), "/") {
+		switch {
+		case len(elem) == 0 || elem == ".":
+			continue
+		case elem == "..":
+			return "", fmt.Errorf("%s leaves the archive", name)
+		case strings.HasPrefix(elem, "."):
+			return "", nil
+		}
+		elems = append(elems, elem)
+	}
+	return strings.Join(elems, "/"), nil
+}
+
+// readArchive returns the regular files in a tar.gz or zip archive, telling
+// them apart by their first bytes.  It fails with errArchiveTooLarge as soon
+// as the files add up to more than maxImportedSize.
+func readArchive(data []byte) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	remaining := int64(maxImportedSize)
+	add := func(name string, r io.Reader) error {
+		fileName, err := archivePath(name)
+		if err != nil || len(fileName) == 0 {
+			return err
+		}
+		content, err := ioutil.ReadAll(io.LimitReader(r, remaining+1))
+		if err != nil {
+			return err
+		}
+		if remaining -= int64(len(content)); remaining < 0 {
+			return errArchiveTooLarge
+		}
+		files[fileName] = content
+		return nil
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			if f.UncompressedSize64 > uint64(remaining) {
+				return nil, errArchiveTooLarge
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			err = add(f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		tr := tar.NewReader(gz)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if header.Typeflag != tar.TypeReg {
+				continue
+			}
+			if err = add(header.Name, tr); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, errors.New("not a tar.gz or zip archive")
+	}
+	return files, nil
+}
+
+// importHandler commits the files in an uploaded archive to the branch as
+// one commit.  Files the archive lacks are kept, unless ?prune=true deletes
+// them so that the branch ends up holding exactly the files in the archive.
+// With ?dryRun=true nothing is committed, the files that would be added,
+// modified and deleted are only reported.
+func (s *server) importHandler(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	dryRun, prune := query.Get("dryRun") == "true", query.Get("prune") == "true"
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		return withStatus(http.StatusBadRequest, fmt.Errorf("can't read archive: %v", err))
+	}
+	imported, err := readArchive(data)
+	if err == errArchiveTooLarge {
+		return withStatus(http.StatusRequestEntityTooLarge, err)
+	}
+	if err != nil {
+		return withStatus(http.StatusBadRequest, err)
+	}
+	branch, err := s.requestBranch(r)
+	if err != nil {
+		return err
+	}
+	current, err := s.committedFiles("refs/heads/" + branch)
+	if err != nil {
+		return err
+	}
+
+	resp := ImportResponse{Status: Success, DryRun: dryRun, Added: []string{}, Modified: []string{}, Deleted: []string{}}
+	changes := make(map[string][]byte)
+	for fileName, content := range imported {
+		// Files are compared and committed the way they are stored.
+		content, err := s.storedSpec(fileName, content)
+		if err != nil {
+			return err
+		}
+		old, ok := current[fileName]
+		switch {
+		case !ok:
+			resp.Added = append(resp.Added, fileName)
+		case !bytes.Equal(old, content):
+			resp.Modified = append(resp.Modified, fileName)
+		default:
+			continue
+		}
+		changes[fileName] = content
+	}
+	if prune {
+		for fileName := range current {
+			if _, ok := imported[fileName]; !ok && checkFileName(fileName) == nil {
+				resp.Deleted = append(resp.Deleted, fileName)
+				changes[fileName] = nil
+			}
+		}
+	}
+	sort.Strings(resp.Added)
+	sort.Strings(resp.Modified)
+	sort.Strings(resp.Deleted)
+
+	for fileName, content := range changes {
+		if role := s.userRole(r, fileName); role < RoleReader {
+			// Pruning would otherwise give away the names of files the
+			// user isn't allowed to see.
+			return withStatus(http.StatusForbidden, fmt.Errorf("%s isn't allowed to change some of the files the import touches", authenticatedUser(r)))
+		} else if role < RoleEditor {
+			return withStatus(http.StatusForbidden, fmt.Errorf("%s needs the %s role for %s", authenticatedUser(r), RoleEditor, fileName))
+		}
+		if content == nil {
+			continue
+		}
+		if errs := openapi.ValidateFile(content); len(errs) > 0 {
+			if resp.Errors == nil {
+				resp.Errors = make(map[string][]openapi.ValidationError)
+			}
+			resp.Errors[fileName] = errs
+		}
+	}
+	if len(resp.Errors) > 0 {
+		resp.Status = Error
+		resp.Message = fmt.Sprintf("%d files are not valid Swagger or OpenAPI specs.", len(resp.Errors))
+		writeJSON(w, http.StatusUnprocessableEntity, resp)
+		return nil
+	}
+
+	summary := fmt.Sprintf("%d added, %d modified, %d deleted", len(resp.Added), len(resp.Modified), len(resp.Deleted))
+	if dryRun || len(changes) == 0 {
+		resp.Message = "Import would leave " + branch + " unchanged."
+		if len(changes) > 0 {
+			resp.Message = "Import would commit " + summary + "."
+		}
+		writeJSON(w, http.StatusOK, resp)
+		return nil
+	}
+
+	updated := make(map[string][]byte)
+	for fileName, content := range changes {
+		if content != nil {
+			updated[fileName] = content
+		}
+	}
+	if !s.checkCompat(w, branch, updated) {
+		return nil
+	}
+	message := r.Header.Get("Commit-Message")
+	if len(message) == 0 {
+		message = "Import " + summary
+	}
+	if resp.Commit, err = s.store.Commit(branch, requestIdentity(r), message, changes); err != nil {
+		return withStatus(http.StatusInternalServerError, err)
+	}
+	requestLogger(r).Info("Imported archive", "branch", branch, "commit", resp.Commit, "added", len(resp.Added), "modified", len(resp.Modified), "deleted", len(resp.Deleted))
+	s.publishCommit(branch, resp.Commit)
+	resp.Message = "Imported " + summary + "."
+	writeJSON(w, http.StatusOK, resp)
+	return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchive(t *testing.T) {
+	store := newMemStore("master")
+	srv := newServer(store)
+	jdoe := Identity{Name: "jdoe", Email: "jdoe@example.com"}
+	store.Commit("master", jdoe, "Add specs", map[string][]byte{
+		"accounts.yaml":  []byte(accountsSpec),
+		"v2/pets.yaml":   []byte("pets"),
+		"notes/todo.txt": []byte("todo"),
+	})
+
+	for _, format := range []string{"tar.gz", "zip"} {
+		w := serve("GET", "/archive", "/archive?format="+format, nil, srv.archiveHandler)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d %s", format, w.Code, w.Body.String())
+		}
+		if disposition := w.Header().Get("Content-Disposition"); !strings.HasSuffix(disposition, "."+format+`"`) {
+			t.Errorf("%s: Content-Disposition %s", format, disposition)
+		}
+		files, err := readArchive(w.Body.Bytes())
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if len(files) != 3 || string(files["accounts.yaml"]) != accountsSpec || string(files["v2/pets.yaml"]) != "pets" {
+			t.Errorf("%s: got %v", format, files)
+		}
+	}
+
+	expectError(t, "unknown format", serve("GET", "/archive", "/archive?format=rar", nil, srv.archiveHandler), http.StatusBadRequest)
+	expectError(t, "unknown ref", serve("GET", "/archive", "/archive?ref=nope", nil, srv.archiveHandler), http.StatusNotFound)
+}
+
+func TestArchivePath(t *testing.T) {
+	for name, want := range map[string]string{
+		"accounts.yaml":     "accounts.yaml",
+		"./v2//pets.yaml":   "v2/pets.yaml",
+		"/specs/pets.yaml":  "specs/pets.yaml",
+		`v2\pets.yaml`:      "v2/pets.yaml",
+		".git/config":       "",
+		"v2/.pets.yaml.swp": "",
+	} {
+		if got, err := archivePath(name); err != nil || got != want {
+			t.Errorf("archivePath(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := archivePath("../etc/passwd"); err == nil {
+		t.Error("../etc/passwd was accepted")
+	}
+}
+
+func TestImport(t *testing.T) {
+	store := newMemStore("master")
+	srv := newServer(store)
+	jdoe := Identity{Name: "jdoe", Email: "jdoe@example.com"}
+	store.Commit("master", jdoe, "Add specs", map[string][]byte{
+		"accounts.yaml": []byte(accountsSpec),
+		"owners.yaml":   []byte(accountsSpec),
+	})
+	modified := strings.Replace(accountsSpec, `version: "1.0"`, `version: "1.1"`, 1)
+	archive := func(files map[string][]byte) *bytes.Reader {
+		var names []string
+		for name := range files {
+			names = append(names, name)
+		}
+		var buf bytes.Buffer
+		writeZip(&buf, names, files, time.Now())
+		return bytes.NewReader(buf.Bytes())
+	}
+	upload := archive(map[string][]byte{
+		"accounts.yaml": []byte(modified),
+		"pets.yaml":     []byte(accountsSpec),
+	})
+	importFiles := func(target string) (*ImportResponse, int) {
+		upload.Seek(0, 0)
+		w := serve("POST", "/import", target, upload, srv.importHandler)
+		var resp ImportResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return &resp, w.Code
+	}
+
+	resp, status := importFiles("/import?dryRun=true&prune=true")
+	if status != http.StatusOK || !resp.DryRun || len(resp.Commit) > 0 ||
+		strings.Join(resp.Added, ",") != "pets.yaml" ||
+		strings.Join(resp.Modified, ",") != "accounts.yaml" ||
+		strings.Join(resp.Deleted, ",") != "owners.yaml" {
+		t.Fatalf("Dry run got %d %+v", status, resp)
+	}
+	if _, err := store.ReadAt("master", "pets.yaml"); err == nil {
+		t.Error("The dry run committed pets.yaml")
+	}
+
+	resp, status = importFiles("/import")
+	if status != http.StatusOK || len(resp.Commit) == 0 || len(resp.Deleted) != 0 {
+		t.Fatalf("Import got %d %+v", status, resp)
+	}
+	commit, _ := store.LookupCommit("master")
+	if commit.Id != resp.Commit || strings.Join(commit.Files, ",") != "accounts.yaml,pets.yaml" {
+		t.Errorf("Committed %+v", commit)
+	}
+	if content, _ := store.ReadAt("master", "accounts.yaml"); string(content) != modified {
+		t.Errorf("accounts.yaml is %q", content)
+	}
+
+	resp, status = importFiles("/import?prune=true")
+	if status != http.StatusOK || strings.Join(resp.Deleted, ",") != "owners.yaml" || len(resp.Added)+len(resp.Modified) != 0 {
+		t.Errorf("Pruning import got %d %+v", status, resp)
+	}
+	if _, err := store.ReadAt("master", "owners.yaml"); err == nil {
+		t.Error("owners.yaml wasn't deleted")
+	}
+
+	w := serve("POST", "/import", "/import", archive(map[string][]byte{"pets.yaml": []byte("nope: [")}), srv.importHandler)
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "pets.yaml") {
+		t.Errorf("Invalid spec got %d %s", w.Code, w.Body.String())
+	}
+	expectError(t, "not an archive", serve("POST", "/import", "/import", strings.NewReader("accounts.yaml"), srv.importHandler), http.StatusBadRequest)
+}
+
+func TestImportCanonicalFormat(t *testing.T) {
+	store := newMemStore("master")
+	srv := newServer(store)
+	srv.canonicalFormat = formatJSON
+	stored, _ := canonicalSpec([]byte(accountsSpec), formatJSON)
+	store.Commit("master", Identity{Name: "jdoe", Email: "jdoe@example.com"}, "Add accounts", map[string][]byte{"accounts.yaml": stored})
+	files := map[string][]byte{"accounts.yaml": []byte(accountsSpec), "pets.yaml": []byte(accountsSpec)}
+	var buf bytes.Buffer
+	writeZip(&buf, []string{"accounts.yaml", "pets.yaml"}, files, time.Now())
+
+	w := serve("POST", "/import", "/import", &buf, srv.importHandler)
+	var resp ImportResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || strings.Join(resp.Added, ",") != "pets.yaml" || len(resp.Modified) != 0 {
+		t.Fatalf("Import got %d %s", w.Code, w.Body.String())
+	}
+	if content, _ := store.ReadAt("master", "pets.yaml"); !bytes.Equal(content, stored) {
+		t.Errorf("pets.yaml is stored as %s", content)
+	}
+}
+
+func TestImportTooLarge(t *testing.T) {
+	srv := newServer(newMemStore("master"))
+	// Two files that are nothing but spaces add up to just over the limit
+	// and compress to next to nothing.
+	spaces := bytes.Repeat([]byte(" "), maxImportedSize/2+1)
+	files := map[string][]byte{"accounts.yaml": spaces, "pets.yaml": spaces}
+	fileNames := []string{"accounts.yaml", "pets.yaml"}
+
+	var zipped, tarred bytes.Buffer
+	writeZip(&zipped, fileNames, files, time.Now())
+	writeTarGz(&tarred, fileNames, files, time.Now())
+	for format, archive := range map[string][]byte{"zip": zipped.Bytes(), "tar.gz": tarred.Bytes()} {
+		if len(archive) > maxImportSize {
+			t.Fatalf("%s: the archive takes %d bytes", format, len(archive))
+		}
+		w := serve("POST", "/import", "/import", bytes.NewReader(archive), srv.importHandler)
+		expectError(t, format, w, http.StatusRequestEntityTooLarge)
+	}
+}
+
+// importPolicy lets jdoe edit specs/, read shared/ and nothing else.
+const importPolicy = `
+default: none
+grants:
+  - role: editor
+    users: [jdoe]
+    prefix: specs/
+  - role: reader
+    users: [jdoe]
+    prefix: shared/
+`
+
+func TestImportForbidden(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "authz.yaml")
+	os.WriteFile(policyFile, []byte(importPolicy), 0644)
+	store := newMemStore("master")
+	srv := newServer(store)
+	var err error
+	if srv.authz, err = newAuthorizer(policyFile); err != nil {
+		t.Fatal(err)
+	}
+	jdoe := Identity{Name: "jdoe", Email: "jdoe@example.com"}
+	store.Commit("master", jdoe, "Add specs", map[string][]byte{
+		"specs/accounts.yaml": []byte(accountsSpec),
+		"shared/common.yaml":  []byte("type: object\n"),
+	})
+	var buf bytes.Buffer
+	writeZip(&buf, []string{"specs/accounts.yaml"}, map[string][]byte{"specs/accounts.yaml": []byte(accountsSpec + "\n")}, time.Now())
+	importFiles := func(target string) *httptest.ResponseRecorder {
+		return serve("POST", "/import", target, bytes.NewReader(buf.Bytes()), srv.importHandler)
+	}
+
+	if w := importFiles("/import"); w.Code != http.StatusOK {
+		t.Errorf("Import got %d %s", w.Code, w.Body.String())
+	}
+	w := importFiles("/import?prune=true")
+	expectError(t, "pruning a readable file", w, http.StatusForbidden)
+	if !strings.Contains(w.Body.String(), "shared/common.yaml") {
+		t.Errorf("Pruning a readable file got %s", w.Body.String())
+	}
+
+	store.Commit("master", jdoe, "Replace common with a secret", map[string][]byte{
+		"shared/common.yaml":  nil,
+		"private/secret.yaml": []byte("type: object\n"),
+	})
+	w = importFiles("/import?prune=true")
+	expectError(t, "pruning an unreadable file", w, http.StatusForbidden)
+	if strings.Contains(w.Body.String(), "private") {
+		t.Errorf("Pruning an unreadable file gave it away: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	auth "github.com/vsheffer/go-http-auth"
+	"net/http"
+	"strings"
+)
+
+// The headers the authenticator sets on every request it lets through.
+// Whatever a client sent in them is replaced.
+const (
+	usernameHeader = "X-Basic-Auth-Username"
+	scopeHeader    = "X-Gitrest-Token-Scope"
+)
+
+// authenticator accepts htpasswd basic auth, gitrest API tokens and, when a
+// public key is configured, JWTs.  Tokens and JWTs are sent as
+// "Authorization: Bearer <token>".
+type authenticator struct {
+	basic *auth.BasicAuth
+}
+
+func newAuthenticator(passwordFile string) *authenticator {
+	return &authenticator{basic: auth.NewBasicAuthenticator("gitrest", auth.HtpasswdFileProvider(passwordFile))}
+}
+
+// bearerUser returns the user and scope of a bearer token.
+func bearerUser(token string) (string, string, error) {
+	if isJWT(token) {
+		if jwtKey == nil {
+			return "", "", errors.New("JWTs aren't accepted")
+		}
+		claims, err := jwtKey.Verify(token)
+		if err != nil {
+			return "", "", err
+		}
+		return claims.Sub, jwtScope(claims), nil
+	}
+	if tokens == nil || !strings.HasPrefix(token, tokenPrefix) {
+		return "", "", errors.New("unknown token")
+	}
+	t, err := tokens.Check(token)
+	if err != nil {
+		return "", "", err
+	}
+	return t.User, t.Scope, nil
+}
+
+// Wrap only lets authenticated requests through to h.
+func (a *authenticator) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var user, scope string
+		authorization := r.Header.Get("Authorization")
+		if strings.HasPrefix(authorization, "Bearer ") {
+			var err error
+			user, scope, err = bearerUser(strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer ")))
+			if err != nil {
+				requestLogger(r).Warn("Rejected bearer token", "error", err)
+				metrics.countAuthFailure("bearer")
+				w.Header().Set("WWW-Authenticate", `Bearer realm="gitrest", error="invalid_token"`)
+				writeError(w, http.StatusUnauthorized, err)
+				return
+			}
+		} else if user = a.basic.CheckAuth(r); len(user) == 0 {
+			if len(authorization) > 0 {
+				requestLogger(r).Warn("Rejected basic auth credentials")
+				metrics.countAuthFailure("basic")
+			} else {
+				metrics.countAuthFailure("none")
+			}
+			a.basic.RequireAuth(w, r)
+			return
+		}
+
+		setRequestUser(r, user)
+		w.Header().Add(usernameHeader, user)
+		r.Header.Set(usernameHeader, user)
+		r.Header.Del(scopeHeader)
+		if len(scope) > 0 {
+			r.Header.Set(scopeHeader, scope)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// tokenScope returns the scope of the token r was authenticated with, or an
+// empty string for basic auth.
+func tokenScope(r *http.Request) string {
+	return r.Header.Get(scopeHeader)
+}
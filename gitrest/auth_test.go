@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	store, err := newTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, info, err := store.Issue("ci", ScopeWrite, "pipeline", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	expired, _, err := store.Issue("ci", ScopeRead, "", &past)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bytes, _ := ioutil.ReadFile(path)
+	if !json.Valid(bytes) {
+		t.Fatalf("Bad tokens file %q", bytes)
+	}
+	if strings.Contains(string(bytes), token) {
+		t.Error("Tokens file holds the token itself")
+	}
+
+	// A fresh store sees the tokens saved by the first.
+	store, err = newTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.Check(token); err != nil || got.User != "ci" || got.Scope != ScopeWrite {
+		t.Errorf("Check = %+v, %v", got, err)
+	}
+	if _, err := store.Check(expired); err == nil {
+		t.Error("Expired token accepted")
+	}
+	if _, err := store.Check(tokenPrefix + "0000"); err == nil {
+		t.Error("Unknown token accepted")
+	}
+	for _, listed := range store.List() {
+		if len(listed.Hash) > 0 {
+			t.Error("List returned a hash")
+		}
+	}
+
+	if found, err := store.Revoke(info.Id); !found || err != nil {
+		t.Fatalf("Revoke = %v, %v", found, err)
+	}
+	if _, err := store.Check(token); err == nil {
+		t.Error("Revoked token accepted")
+	}
+}
+
+func signJWT(t *testing.T, key *ecdsa.PrivateKey, claims JWTClaims) string {
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTVerify(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+	verifier, err := loadJWTVerifier(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	claims, err := verifier.Verify(signJWT(t, key, JWTClaims{Sub: "jdoe", Scope: "openid gitrest:write", Exp: exp}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Sub != "jdoe" || jwtScope(claims) != ScopeWrite {
+		t.Errorf("Got %+v", claims)
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	bad := map[string]string{
+		"expired":   signJWT(t, key, JWTClaims{Sub: "jdoe", Exp: time.Now().Add(-time.Hour).Unix()}),
+		"wrong key": signJWT(t, other, JWTClaims{Sub: "jdoe", Exp: exp}),
+		"no sub":    signJWT(t, key, JWTClaims{Exp: exp}),
+		"alg none": base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+			base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"jdoe","exp":9999999999}`)) + ".",
+	}
+	for name, token := range bad {
+		if _, err := verifier.Verify(token); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+}
+
+func TestJWTIssuerAndAudience(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	verifier := &jwtVerifier{key: &key.PublicKey, issuer: "https://idp.example.com", audience: "gitrest"}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	token := signJWT(t, key, JWTClaims{Sub: "jdoe", Exp: exp, Iss: "https://idp.example.com", Aud: Audience{"portal", "gitrest"}})
+	if _, err := verifier.Verify(token); err != nil {
+		t.Error(err)
+	}
+	bad := map[string]string{
+		"other issuer":   signJWT(t, key, JWTClaims{Sub: "jdoe", Exp: exp, Iss: "https://other.example.com", Aud: Audience{"gitrest"}}),
+		"no issuer":      signJWT(t, key, JWTClaims{Sub: "jdoe", Exp: exp, Aud: Audience{"gitrest"}}),
+		"other audience": signJWT(t, key, JWTClaims{Sub: "jdoe", Exp: exp, Iss: "https://idp.example.com", Aud: Audience{"portal"}}),
+		"no audience":    signJWT(t, key, JWTClaims{Sub: "jdoe", Exp: exp, Iss: "https://idp.example.com"}),
+	}
+	for name, token := range bad {
+		if _, err := verifier.Verify(token); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+
+	var claims JWTClaims
+	if err := json.Unmarshal([]byte(`{"aud": "gitrest"}`), &claims); err != nil || !claims.Aud.contains("gitrest") {
+		t.Errorf("Single audience got %+v, %v", claims, err)
+	}
+}
+
+func TestAuthenticatorScope(t *testing.T) {
+	var err error
+	tokens, err = newTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { tokens = nil }()
+	readToken, _, _ := tokens.Issue("ci", ScopeRead, "", nil)
+	writeToken, _, _ := tokens.Issue("ci", ScopeWrite, "", nil)
+
+	a := newAuthenticator(filepath.Join(t.TempDir(), "htpasswd"))
+	handler := a.Wrap(newServer(newMemStore("master")).requireRole(RoleEditor, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(authenticatedUser(r)))
+	}))
+
+	tests := []struct {
+		authorization string
+		scope         string
+		status        int
+	}{
+		{"Bearer " + writeToken, "", http.StatusOK},
+		{"Bearer " + readToken, "", http.StatusForbidden},
+		{"Bearer " + readToken, ScopeWrite, http.StatusForbidden},
+		{"Bearer " + tokenPrefix + "nope", "", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/merge", nil)
+		req.Header.Set("Authorization", test.authorization)
+		if len(test.scope) > 0 {
+			req.Header.Set(scopeHeader, test.scope)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("%.20s: status %d, want %d", test.authorization, w.Code, test.status)
+		}
+	}
+}
+
+func TestCreateToken(t *testing.T) {
+	var err error
+	tokens, err = newTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { tokens = nil }()
+
+	rs := newRepoSet("", "default")
+	create := func(user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/tokens", strings.NewReader(body))
+		req.Header.Set(usernameHeader, user)
+		w := httptest.NewRecorder()
+		rs.requireRole(RoleAdmin, rs.createTokenHandler)(w, req)
+		return w
+	}
+	issuedTo := func(w *httptest.ResponseRecorder) string {
+		var resp CreateTokenResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusCreated {
+			t.Fatalf("Create got %d %s", w.Code, w.Body.String())
+		}
+		return resp.Info.User
+	}
+
+	// Without a policy every user is an admin, but only for their own tokens.
+	if user := issuedTo(create("jdoe", `{}`)); user != "jdoe" {
+		t.Errorf("Token issued to %s", user)
+	}
+	if user := issuedTo(create("jdoe", `{"user": "jdoe"}`)); user != "jdoe" {
+		t.Errorf("Token issued to %s", user)
+	}
+	expectError(t, "token for another user without a policy", create("jdoe", `{"user": "ci"}`), http.StatusForbidden)
+
+	policyFile := filepath.Join(t.TempDir(), "authz.yaml")
+	ioutil.WriteFile(policyFile, []byte(reposPolicy), 0644)
+	if rs.authz, err = newAuthorizer(policyFile); err != nil {
+		t.Fatal(err)
+	}
+	if user := issuedTo(create("vsheffer", `{"user": "ci"}`)); user != "ci" {
+		t.Errorf("Token issued to %s", user)
+	}
+	expectError(t, "token issued by a reader", create("jdoe", `{"user": "ci"}`), http.StatusForbidden)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ghodss/yaml"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Role is what a user may do with a spec file or the repository.  Each
+// role includes the ones before it.
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleReader
+	RoleEditor
+	RoleAdmin
+)
+
+var roleNames = []string{"none", "reader", "editor", "admin"}
+
+func (role Role) String() string {
+	return roleNames[role]
+}
+
+func (role *Role) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		return err
+	}
+	for i, roleName := range roleNames {
+		if name == roleName {
+			*role = Role(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown role %q", name)
+}
+
+// A Grant gives a role to some users and groups.  With a prefix it only
+// covers the spec files whose path starts with it, such as a directory.
+type Grant struct {
+	Role   Role     `json:"role"`
+	Users  []string `json:"users"`
+	Groups []string `json:"groups"`
+	Prefix string   `json:"prefix"`
+}
+
+// A Policy is read from the -authz-file, for example:
+//
+//	default: reader
+//	groups:
+//	  payments-team: [jdoe, asmith]
+//	grants:
+//	  - role: admin
+//	    users: [vsheffer]
+//	  - role: editor
+//	    groups: [payments-team]
+//	    prefix: payments/
+//	repos:
+//	  billing:
+//	    default: none
+//	    grants:
+//	      - role: editor
+//	        groups: [payments-team]
+//
+// Repositories listed under repos get their own policy, which uses the
+// groups of the top-level one unless it defines its own.  Every other
+// repository, and everything that isn't about one repository such as
+// creating repositories, falls under the top-level policy.
+type Policy struct {
+	Default Role                `json:"default"`
+	Groups  map[string][]string `json:"groups"`
+	Grants  []Grant             `json:"grants"`
+	Repos   map[string]*Policy  `json:"repos"`
+}
+
+// ForRepo returns the policy for the repository called name.
+func (p *Policy) ForRepo(name string) *Policy {
+	repoPolicy, ok := p.Repos[name]
+	if !ok || repoPolicy == nil {
+		return p
+	}
+	if repoPolicy.Groups == nil {
+		withGroups := *repoPolicy
+		withGroups.Groups = p.Groups
+		return &withGroups
+	}
+	return repoPolicy
+}
+
+func (p *Policy) inGroup(user, group string) bool {
+	for _, member := range p.Groups[group] {
+		if member == user {
+			return true
+		}
+	}
+	return false
+}
+
+// Role returns the highest role user has for path.  An empty path stands for
+// the repository as a whole, which only grants without a prefix cover.
+func (p *Policy) Role(user, path string) Role {
+	role := p.Default
+	for _, grant := range p.Grants {
+		if grant.Role <= role {
+			continue
+		}
+		if len(grant.Prefix) > 0 && (len(path) == 0 || !strings.HasPrefix(path, grant.Prefix)) {
+			continue
+		}
+		applies := false
+		for _, u := range grant.Users {
+			applies = applies || u == user
+		}
+		for _, group := range grant.Groups {
+			applies = applies || p.inGroup(user, group)
+		}
+		if applies {
+			role = grant.Role
+		}
+	}
+	return role
+}
+
+// authorizer holds the policy loaded from a file and reloads it whenever the
+// file changes.
+type authorizer struct {
+	path    string
+	mutex   sync.RWMutex
+	policy  *Policy
+	modTime time.Time
+}
+
+func newAuthorizer(path string) (*authorizer, error) {
+	a := &authorizer{path: path}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// reload reads the policy file again if it changed since it was last read.
+func (a *authorizer) reload() error {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+	a.mutex.RLock()
+	unchanged := a.policy != nil && info.ModTime().Equal(a.modTime)
+	a.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	bytes, err := ioutil.ReadFile(a.path)
+	if err != nil {
+		return err
+	}
+	policy := &Policy{}
+	if err = yaml.Unmarshal(bytes, policy); err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	a.policy = policy
+	a.modTime = info.ModTime()
+	a.mutex.Unlock()
+	slog.Info("Loaded authorization policy", "path", a.path)
+	return nil
+}
+
+// watch checks the policy file for changes every interval.  A policy that
+// doesn't load is logged and the previous one stays in effect.
+func (a *authorizer) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := a.reload(); err != nil {
+			slog.Error("Can't reload authorization policy", "path", a.path, "error", err)
+		}
+	}
+}
+
+// Role returns the role user has for path in the repository called repo.
+// An empty repo stands for gitrest as a whole.
+func (a *authorizer) Role(repo, user, path string) Role {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	policy := a.policy
+	if len(repo) > 0 {
+		policy = policy.ForRepo(repo)
+	}
+	return policy.Role(user, path)
+}
+
+// userRole returns the role the user of r has for path in the repository of
+// s.  Requests made with a read token never get more than the reader role.
+func (s *server) userRole(r *http.Request, path string) Role {
+	role := RoleAdmin
+	if s.authz != nil {
+		role = s.authz.Role(s.name, authenticatedUser(r), path)
+	}
+	if tokenScope(r) == ScopeRead && role > RoleReader {
+		role = RoleReader
+	}
+	return role
+}
+
+// errForbidden tells the user of r that they need role for what.
+func errForbidden(r *http.Request, role Role, what string) error {
+	return withStatus(http.StatusForbidden, fmt.Errorf("%s needs the %s role for %s", authenticatedUser(r), role, what))
+}
+
+func forbidden(w http.ResponseWriter, r *http.Request, role Role, what string) {
+	writeError(w, http.StatusForbidden, errForbidden(r, role, what))
+}
+
+// requireRole only lets users with at least role for the whole repository
+// through to h.
+func (s *server) requireRole(role Role, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.userRole(r, "") < role {
+			forbidden(w, r, role, "this repository")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// requireFileRole only lets users with at least role for the spec file named
+// in the route through to h.
+func (s *server) requireFileRole(role Role, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fileName := mux.Vars(r)["filename"]
+		if err := checkFileName(fileName); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if s.userRole(r, fileName) < role {
+			forbidden(w, r, role, fileName)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// checkFileName rejects spec file paths that would leave the repository or
+// reach into hidden files such as .git.
+func checkFileName(fileName string) error {
+	for _, elem := range strings.Split(fileName, "/") {
+		if len(elem) == 0 || strings.HasPrefix(elem, ".") {
+			return errors.New("invalid file name " + fileName)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"github.com/ghodss/yaml"
+	"testing"
+)
+
+const testPolicy = `
+default: reader
+groups:
+  payments-team: [jdoe, asmith]
+grants:
+  - role: admin
+    users: [vsheffer]
+  - role: editor
+    groups: [payments-team]
+    prefix: payments/
+  - role: none
+    users: [guest]
+`
+
+func TestPolicyRole(t *testing.T) {
+	var policy Policy
+	if err := yaml.Unmarshal([]byte(testPolicy), &policy); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user string
+		path string
+		role Role
+	}{
+		{"vsheffer", "", RoleAdmin},
+		{"vsheffer", "payments/charges.yaml", RoleAdmin},
+		{"jdoe", "payments/charges.yaml", RoleEditor},
+		{"jdoe", "accounts.yaml", RoleReader},
+		{"jdoe", "", RoleReader},
+		{"asmith", "payments/refunds.yaml", RoleEditor},
+		{"guest", "accounts.yaml", RoleReader},
+		{"nobody", "payments/charges.yaml", RoleReader},
+	}
+	for _, test := range tests {
+		if role := policy.Role(test.user, test.path); role != test.role {
+			t.Errorf("Role(%q, %q) = %s, want %s", test.user, test.path, role, test.role)
+		}
+	}
+}
+
+func TestPolicyForRepo(t *testing.T) {
+	var policy Policy
+	if err := yaml.Unmarshal([]byte(testPolicy+reposPolicyExtra), &policy); err != nil {
+		t.Fatal(err)
+	}
+	billing := policy.ForRepo("billing")
+	if role := billing.Role("asmith", "invoices.yaml"); role != RoleEditor {
+		t.Errorf("asmith is %s in billing, want editor with the top-level groups", role)
+	}
+	if role := billing.Role("vsheffer", ""); role != RoleNone {
+		t.Errorf("vsheffer is %s in billing, want none", role)
+	}
+	if policy.ForRepo("accounts") != &policy {
+		t.Error("accounts doesn't use the top-level policy")
+	}
+}
+
+const reposPolicyExtra = `repos:
+  billing:
+    default: none
+    grants:
+      - role: editor
+        groups: [payments-team]
+`
+
+func TestCheckFileName(t *testing.T) {
+	for _, name := range []string{"pets.yaml", "payments/charges.yaml"} {
+		if err := checkFileName(name); err != nil {
+			t.Errorf("checkFileName(%q) = %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "../pets.yaml", ".git/config", "payments//charges.yaml", "/etc/passwd"} {
+		if err := checkFileName(name); err == nil {
+			t.Errorf("checkFileName(%q) succeeded", name)
+		}
+	}
+}
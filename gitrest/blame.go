@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/libgit2/git2go"
+	"github.com/vsheffer/gofun/openapi"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// BlameLine is the commit that last changed one line of a file.
+type BlameLine struct {
+	Line    int       `json:"line"`
+	Commit  string    `json:"commit"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Time    time.Time `json:"time"`
+	Summary string    `json:"summary"`
+	Text    string    `json:"text"`
+}
+
+// BlamePath is the last change to a part of a spec, such as an operation,
+// and everyone who wrote any of its lines.
+type BlamePath struct {
+	Path      string    `json:"path"`
+	StartLine int       `json:"startLine"`
+	EndLine   int       `json:"endLine"`
+	Commit    string    `json:"commit"`
+	Author    string    `json:"author"`
+	Email     string    `json:"email"`
+	Time      time.Time `json:"time"`
+	Authors   []string  `json:"authors"`
+}
+
+type BlameResponse struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message"`
+	File    string      `json:"file"`
+	Commit  string      `json:"commit"`
+	Lines   []BlameLine `json:"lines,omitempty"`
+	Paths   []BlamePath `json:"paths,omitempty"`
+}
+
+// blameFile returns who last changed each line of fileName as of commit.
+func (s *gitStore) blameFile(commit *git.Commit, fileName string) ([]BlameLine, error) {
+	content, err := s.readFileAtCommit(commit, fileName)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSuffix(string(content), "\n")
+	if len(text) == 0 {
+		return []BlameLine{}, nil
+	}
+
+	opts, err := git.DefaultBlameOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts.NewestCommit = commit.Id()
+	blame, err := s.repo.BlameFile(fileName, &opts)
+	if err != nil {
+		return nil, err
+	}
+	defer blame.Free()
+
+	summaries := make(map[string]string)
+	lines := strings.Split(text, "\n")
+	blameLines := make([]BlameLine, len(lines))
+	for i, line := range lines {
+		hunk, err := blame.HunkByLine(i + 1)
+		if err != nil {
+			return nil, fmt.Errorf("can't blame line %d: %v", i+1, err)
+		}
+		id := hunk.FinalCommitId.String()
+		summary, ok := summaries[id]
+		if !ok {
+			if c, err := s.repo.LookupCommit(hunk.FinalCommitId); err == nil {
+				summary = c.Summary()
+			}
+			summaries[id] = summary
+		}
+		blameLines[i] = BlameLine{
+			Line:    i + 1,
+			Commit:  id,
+			Summary: summary,
+			Text:    line,
+		}
+		if s := hunk.FinalSignature; s != nil {
+			blameLines[i].Author, blameLines[i].Email, blameLines[i].Time = s.Name, s.Email, s.When
+		}
+	}
+	return blameLines, nil
+}
+
+// blamePaths maps blamed lines onto the sections of a spec.  The commit of a
+// section is the most recent one among its lines.
+func blamePaths(content []byte, lines []BlameLine) ([]BlamePath, error) {
+	sections, err := openapi.Sections(content)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]BlamePath, 0, len(sections))
+	for _, section := range sections {
+		path := BlamePath{Path: section.Path, StartLine: section.StartLine, EndLine: section.EndLine, Authors: []string{}}
+		seen := make(map[string]bool)
+		for n := section.StartLine; n <= section.EndLine && n <= len(lines); n++ {
+			line := lines[n-1]
+			if len(path.Commit) == 0 || line.Time.After(path.Time) {
+				path.Commit, path.Author, path.Email, path.Time = line.Commit, line.Author, line.Email, line.Time
+			}
+			if !seen[line.Author] {
+				seen[line.Author] = true
+				path.Authors = append(path.Authors, line.Author)
+			}
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// blameHandler returns the commit, author and time of the last change to
+// every line of a spec at a ref, by default the tip of the branch.  With
+// ?mode=paths it returns them for every path, operation and definition
+// instead.
+func (s *server) blameHandler(w http.ResponseWriter, r *http.Request) {
+	fileName := mux.Vars(r)["filename"]
+	ref := r.URL.Query().Get("ref")
+	if len(ref) == 0 {
+		branch, err := s.requestBranch(r)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		ref = "refs/heads/" + branch
+	}
+	commit, err := s.git.resolveCommit(ref)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	content, err := s.git.readFileAtCommit(commit, fileName)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	lines, err := s.git.blameFile(commit, fileName)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := BlameResponse{Status: Success, File: fileName, Commit: commit.Id().String()}
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "lines":
+		resp.Lines = lines
+		resp.Message = fmt.Sprintf("%d lines.", len(lines))
+	case "paths":
+		if resp.Paths, err = blamePaths(content, lines); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		resp.Message = fmt.Sprintf("%d paths.", len(resp.Paths))
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown mode %q", mode))
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlamePaths(t *testing.T) {
+	old := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	lines := make([]BlameLine, strings.Count(accountsSpec, "\n"))
+	for i := range lines {
+		lines[i] = BlameLine{Line: i + 1, Commit: "c1", Author: "jdoe", Time: old}
+	}
+	// The second commit changed the description of getAccount.
+	lines[10] = BlameLine{Line: 11, Commit: "c2", Author: "asmith", Time: old.Add(time.Hour)}
+
+	paths, err := blamePaths([]byte(accountsSpec), lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPath := make(map[string]BlamePath)
+	for _, path := range paths {
+		byPath[path.Path] = path
+	}
+
+	get := byPath["paths./accounts/{accountId}.get"]
+	if get.Commit != "c2" || get.Author != "asmith" || len(get.Authors) != 2 {
+		t.Errorf("Got %+v", get)
+	}
+	if account := byPath["definitions.Account"]; account.Commit != "c1" || account.StartLine != 18 {
+		t.Errorf("Got %+v", account)
+	}
+	if info := byPath["info"]; info.Commit != "c1" || info.EndLine != 4 {
+		t.Errorf("Got %+v", info)
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/libgit2/git2go"
+	"net/http"
+	"os"
+)
+
+type BranchInfo struct {
+	Name   string `json:"name"`
+	Commit string `json:"commit"`
+	Head   bool   `json:"head"`
+}
+
+type BranchListResponse struct {
+	Branches []BranchInfo `json:"branches"`
+}
+
+type CreateBranchRequest struct {
+	Name string `json:"name"`
+	From string `json:"from"`
+}
+
+type TagInfo struct {
+	Name   string `json:"name"`
+	Commit string `json:"commit"`
+}
+
+type TagListResponse struct {
+	Tags []TagInfo `json:"tags"`
+}
+
+type CreateTagRequest struct {
+	Name    string `json:"name"`
+	From    string `json:"from"`
+	Message string `json:"message"`
+}
+
+type MergeRequest struct {
+	Source  string `json:"source"`
+	Target  string `json:"target"`
+	Message string `json:"message"`
+}
+
+type CommitResponse struct {
+	Status    string   `json:"status"`
+	Message   string   `json:"message"`
+	Commit    string   `json:"commit,omitempty"`
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, Response{Status: Error, Message: err.Error()})
+}
+
+func (s *server) getBranchesHandler(w http.ResponseWriter, r *http.Request) error {
+	head, err := s.git.HeadBranch()
+	if err != nil {
+		return err
+	}
+
+	it, err := s.git.repo.NewBranchIterator(git.BranchLocal)
+	if err != nil {
+		return err
+	}
+	defer it.Free()
+
+	branches := BranchListResponse{Branches: []BranchInfo{}}
+	err = it.ForEach(func(b *git.Branch, _ git.BranchType) error {
+		name, err := b.Name()
+		if err != nil {
+			return err
+		}
+		branches.Branches = append(branches.Branches, BranchInfo{
+			Name:   name,
+			Commit: b.Target().String(),
+			Head:   name == head,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, branches)
+	return nil
+}
+
+func (s *server) createBranchHandler(w http.ResponseWriter, r *http.Request) error {
+	var req CreateBranchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Name) == 0 {
+		return withStatus(http.StatusBadRequest, errors.New("a branch name is required"))
+	}
+	if len(req.From) == 0 {
+		req.From = "HEAD"
+	}
+
+	from, err := s.git.resolveCommit(req.From)
+	if err != nil {
+		return withStatus(http.StatusNotFound, err)
+	}
+
+	s.git.mutex.Lock()
+	b, err := s.git.repo.CreateBranch(req.Name, from, false)
+	s.git.mutex.Unlock()
+	if err != nil {
+		return withStatus(http.StatusConflict, err)
+	}
+	writeJSON(w, http.StatusCreated, BranchInfo{Name: req.Name, Commit: b.Target().String()})
+	return nil
+}
+
+func (s *server) deleteBranchHandler(w http.ResponseWriter, r *http.Request) error {
+	name := mux.Vars(r)["name"]
+	head, err := s.git.HeadBranch()
+	if err != nil {
+		return err
+	}
+	if name == head {
+		return withStatus(http.StatusConflict, errors.New("can't delete the checked out branch"))
+	}
+
+	s.git.mutex.Lock()
+	defer s.git.mutex.Unlock()
+	b, err := s.git.repo.LookupBranch(name, git.BranchLocal)
+	if err != nil {
+		return errBranchNotFound
+	}
+	if err = b.Delete(); err != nil {
+		return err
+	}
+	if dir, err := s.git.branchWorkDir(name); err == nil {
+		os.RemoveAll(dir)
+	}
+	writeJSON(w, http.StatusOK, Response{Status: Success, Message: "Branch " + name + " deleted."})
+	return nil
+}
+
+func (s *server) getTagsHandler(w http.ResponseWriter, r *http.Request) error {
+	names, err := s.git.repo.Tags.List()
+	if err != nil {
+		return err
+	}
+
+	tags := TagListResponse{Tags: make([]TagInfo, 0, len(names))}
+	for _, name := range names {
+		commit, err := s.git.resolveCommit("refs/tags/" + name)
+		if err != nil {
+			requestLogger(r).Warn("Can't resolve tag", "tag", name, "error", err)
+			continue
+		}
+		tags.Tags = append(tags.Tags, TagInfo{Name: name, Commit: commit.Id().String()})
+	}
+	writeJSON(w, http.StatusOK, tags)
+	return nil
+}
+
+func (s *server) createTagHandler(w http.ResponseWriter, r *http.Request) error {
+	var req CreateTagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Name) == 0 {
+		return withStatus(http.StatusBadRequest, errors.New("a tag name is required"))
+	}
+	if len(req.From) == 0 {
+		req.From = "HEAD"
+	}
+	if len(req.Message) == 0 {
+		req.Message = "Release " + req.Name
+	}
+
+	from, err := s.git.resolveCommit(req.From)
+	if err != nil {
+		return withStatus(http.StatusNotFound, err)
+	}
+
+	s.git.mutex.Lock()
+	_, err = s.git.repo.Tags.Create(req.Name, from, commitSignature(r), req.Message)
+	s.git.mutex.Unlock()
+	if err != nil {
+		return withStatus(http.StatusConflict, err)
+	}
+	writeJSON(w, http.StatusCreated, TagInfo{Name: req.Name, Commit: from.Id().String()})
+	return nil
+}
+
+func (s *server) deleteTagHandler(w http.ResponseWriter, r *http.Request) error {
+	name := mux.Vars(r)["name"]
+
+	s.git.mutex.Lock()
+	err := s.git.repo.Tags.Remove(name)
+	s.git.mutex.Unlock()
+	if err != nil {
+		return withStatus(http.StatusNotFound, err)
+	}
+	writeJSON(w, http.StatusOK, Response{Status: Success, Message: "Tag " + name + " deleted."})
+	return nil
+}
+
+// mergeConflicts lists the paths with conflicts in index.
+func mergeConflicts(index *git.Index) ([]string, error) {
+	it, err := index.ConflictIterator()
+	if err != nil {
+		return nil, err
+	}
+	defer it.Free()
+
+	var paths []string
+	for {
+		conflict, err := it.Next()
+		if git.IsErrorCode(err, git.ErrIterOver) {
+			return paths, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case conflict.Our != nil:
+			paths = append(paths, conflict.Our.Path)
+		case conflict.Their != nil:
+			paths = append(paths, conflict.Their.Path)
+		case conflict.Ancestor != nil:
+			paths = append(paths, conflict.Ancestor.Path)
+		}
+	}
+}
+
+func (s *server) mergeHandler(w http.ResponseWriter, r *http.Request) error {
+	var req MergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Source) == 0 {
+		return withStatus(http.StatusBadRequest, errors.New("a source branch is required"))
+	}
+	if len(req.Target) == 0 {
+		head, err := s.git.HeadBranch()
+		if err != nil {
+			return err
+		}
+		req.Target = head
+	}
+	if len(req.Message) == 0 {
+		req.Message = "Merge branch '" + req.Source + "' into " + req.Target
+	}
+
+	s.git.mutex.Lock()
+	defer s.git.mutex.Unlock()
+
+	theirs, err := s.git.branchTip(req.Source)
+	if err == nil && theirs == nil {
+		err = errBranchNotFound
+	}
+	if err != nil {
+		return withStatus(http.StatusNotFound, err)
+	}
+	ours, err := s.git.branchTip(req.Target)
+	if err == nil && ours == nil {
+		err = errBranchNotFound
+	}
+	if err != nil {
+		return withStatus(http.StatusNotFound, err)
+	}
+
+	base, err := s.git.repo.MergeBase(ours.Id(), theirs.Id())
+	if err != nil {
+		return withStatus(http.StatusConflict, err)
+	}
+	if base.Equal(theirs.Id()) {
+		writeJSON(w, http.StatusOK, CommitResponse{Status: Success, Message: "Already up to date.", Commit: ours.Id().String()})
+		return nil
+	}
+
+	oursTree, err := ours.Tree()
+	if err != nil {
+		return err
+	}
+
+	// Nothing happened on the target since the branches diverged if their
+	// merge base is the target itself, so it can simply be moved forward.
+	fastForward := base.Equal(ours.Id())
+	var mergedTree *git.Tree
+	if fastForward {
+		mergedTree, err = theirs.Tree()
+	} else {
+		var index *git.Index
+		if index, err = s.git.repo.MergeCommits(ours, theirs, nil); err != nil {
+			return err
+		}
+		defer index.Free()
+
+		if index.HasConflicts() {
+			conflicts, err := mergeConflicts(index)
+			if err != nil {
+				return err
+			}
+			writeJSON(w, http.StatusConflict, CommitResponse{
+				Status:    Error,
+				Message:   "Merge of " + req.Source + " into " + req.Target + " has conflicts.",
+				Conflicts: conflicts,
+			})
+			return nil
+		}
+
+		var treeId *git.Oid
+		if treeId, err = index.WriteTreeTo(s.git.repo); err == nil {
+			mergedTree, err = s.git.repo.LookupTree(treeId)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	files, err := s.git.changedFiles(oursTree, mergedTree)
+	if err != nil {
+		return err
+	}
+	if !s.checkCompat(w, req.Target, files) {
+		return nil
+	}
+
+	var mergedId *git.Oid
+	if fastForward {
+		_, err = s.git.repo.References.Create("refs/heads/"+req.Target, theirs.Id(), true, "merge "+req.Source+": Fast-forward")
+		mergedId = theirs.Id()
+	} else {
+		sig := commitSignature(r)
+		mergedId, err = s.git.repo.CreateCommit("refs/heads/"+req.Target, sig, sig, req.Message, mergedTree, ours, theirs)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.publishCommit(req.Target, mergedId.String())
+
+	if err = s.git.checkoutIfHead(req.Target); err != nil {
+		requestLogger(r).Error("Can't update working tree after merge", "branch", req.Target, "error", err)
+	}
+
+	writeJSON(w, http.StatusOK, CommitResponse{
+		Status:  Success,
+		Message: "Merged " + req.Source + " into " + req.Target + ".",
+		Commit:  mergedId.String(),
+	})
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// requestFunc sends a request as user, with header added when it isn't nil.
+type requestFunc func(method, target, user string, header http.Header, body io.Reader) *httptest.ResponseRecorder
+
+// routed returns a requestFunc sending requests through a router with routes
+// set up the way main does.
+func routed(routes func(r *mux.Router)) requestFunc {
+	r := mux.NewRouter()
+	routes(r)
+	return func(method, target, user string, header http.Header, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, body)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set(usernameHeader, user)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+}
+
+func TestBranches(t *testing.T) {
+	store := newTestRepo(t)
+	head := commitTo(t, store.repo, "accounts.yaml", accountsSpec).String()
+	headBranch, _ := store.HeadBranch()
+	request := routed(newServer(store).routes)
+
+	w := request("POST", "/branches", "jdoe", nil, strings.NewReader(`{"name": "feature"}`))
+	var branch BranchInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &branch); err != nil || w.Code != http.StatusCreated || branch.Commit != head {
+		t.Fatalf("Create got %d %s", w.Code, w.Body.String())
+	}
+	expectError(t, "existing branch", request("POST", "/branches", "jdoe", nil, strings.NewReader(`{"name": "feature"}`)), http.StatusConflict)
+	expectError(t, "no name", request("POST", "/branches", "jdoe", nil, strings.NewReader(`{}`)), http.StatusBadRequest)
+	expectError(t, "unknown start", request("POST", "/branches", "jdoe", nil, strings.NewReader(`{"name": "other", "from": "nope"}`)), http.StatusNotFound)
+
+	var list BranchListResponse
+	json.Unmarshal(request("GET", "/branches", "jdoe", nil, nil).Body.Bytes(), &list)
+	var names []string
+	for _, b := range list.Branches {
+		if b.Head != (b.Name == headBranch) || b.Commit != head {
+			t.Errorf("Listed %+v", b)
+		}
+		names = append(names, b.Name)
+	}
+	if strings.Join(names, ",") != "feature,"+headBranch {
+		t.Errorf("Listed %v", names)
+	}
+
+	if w = request("DELETE", "/branches/feature", "jdoe", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("Delete got %d %s", w.Code, w.Body.String())
+	}
+	expectError(t, "deleted branch", request("DELETE", "/branches/feature", "jdoe", nil, nil), http.StatusNotFound)
+	expectError(t, "checked out branch", request("DELETE", "/branches/"+headBranch, "jdoe", nil, nil), http.StatusConflict)
+	expectError(t, "spec on the deleted branch", request("GET", "/specfiles/accounts.yaml?branch=feature", "jdoe", nil, nil), http.StatusNotFound)
+}
+
+func TestTags(t *testing.T) {
+	store := newTestRepo(t)
+	first := commitTo(t, store.repo, "accounts.yaml", accountsSpec).String()
+	head := commitTo(t, store.repo, "pets.yaml", accountsSpec).String()
+	request := routed(newServer(store).routes)
+
+	var tag TagInfo
+	w := request("POST", "/tags", "jdoe", nil, strings.NewReader(`{"name": "v1", "from": "`+first+`"}`))
+	if err := json.Unmarshal(w.Body.Bytes(), &tag); err != nil || w.Code != http.StatusCreated || tag.Commit != first {
+		t.Fatalf("Create got %d %s", w.Code, w.Body.String())
+	}
+	if w = request("POST", "/tags", "jdoe", nil, strings.NewReader(`{"name": "v2"}`)); w.Code != http.StatusCreated {
+		t.Fatalf("Create at HEAD got %d %s", w.Code, w.Body.String())
+	}
+	expectError(t, "existing tag", request("POST", "/tags", "jdoe", nil, strings.NewReader(`{"name": "v1"}`)), http.StatusConflict)
+	expectError(t, "no name", request("POST", "/tags", "jdoe", nil, strings.NewReader(`{}`)), http.StatusBadRequest)
+	expectError(t, "unknown commit", request("POST", "/tags", "jdoe", nil, strings.NewReader(`{"name": "v3", "from": "nope"}`)), http.StatusNotFound)
+
+	var list TagListResponse
+	json.Unmarshal(request("GET", "/tags", "jdoe", nil, nil).Body.Bytes(), &list)
+	if len(list.Tags) != 2 || list.Tags[0] != (TagInfo{"v1", first}) || list.Tags[1] != (TagInfo{"v2", head}) {
+		t.Errorf("Listed %+v", list.Tags)
+	}
+	if _, err := store.ReadAt("v1", "pets.yaml"); errorStatus(err) != http.StatusNotFound {
+		t.Errorf("pets.yaml at v1: %v", err)
+	}
+
+	if w = request("DELETE", "/tags/v1", "jdoe", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("Delete got %d %s", w.Code, w.Body.String())
+	}
+	expectError(t, "deleted tag", request("DELETE", "/tags/v1", "jdoe", nil, nil), http.StatusNotFound)
+}
+
+func TestMerge(t *testing.T) {
+	store := newTestRepo(t)
+	commitTo(t, store.repo, "accounts.yaml", accountsSpec)
+	headBranch, _ := store.HeadBranch()
+	request := routed(newServer(store).routes)
+	jdoe := Identity{Name: "jdoe", Email: "jdoe@example.com"}
+	merge := func(body string) (*CommitResponse, int) {
+		var resp CommitResponse
+		w := request("POST", "/merge", "jdoe", nil, strings.NewReader(body))
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return &resp, w.Code
+	}
+
+	// Nothing happened on the head branch since feature was created, so
+	// merging feature only moves it forward.
+	request("POST", "/branches", "jdoe", nil, strings.NewReader(`{"name": "feature"}`))
+	featureTip, err := store.Commit("feature", jdoe, "Add pets", map[string][]byte{"pets.yaml": []byte(accountsSpec)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, status := merge(`{"source": "feature"}`)
+	if status != http.StatusOK || resp.Commit != featureTip {
+		t.Fatalf("Fast-forward got %d %+v", status, resp)
+	}
+	if content, err := store.ReadAt(headBranch, "pets.yaml"); err != nil || string(content) != accountsSpec {
+		t.Errorf("pets.yaml after the merge: %q, %v", content, err)
+	}
+	if resp, status = merge(`{"source": "feature"}`); status != http.StatusOK || resp.Message != "Already up to date." {
+		t.Errorf("Second merge got %d %+v", status, resp)
+	}
+
+	// Both branches change accounts.yaml.
+	request("POST", "/branches", "jdoe", nil, strings.NewReader(`{"name": "other"}`))
+	store.Commit(headBranch, jdoe, "Retitle accounts", map[string][]byte{
+		"accounts.yaml": []byte(strings.Replace(accountsSpec, "title: Accounts", "title: Accounts API", 1)),
+	})
+	store.Commit("other", jdoe, "Rename accounts", map[string][]byte{
+		"accounts.yaml": []byte(strings.Replace(accountsSpec, "title: Accounts", "title: Customer accounts", 1)),
+	})
+	tip, _ := store.LookupCommit(headBranch)
+	resp, status = merge(`{"source": "other"}`)
+	if status != http.StatusConflict || strings.Join(resp.Conflicts, ",") != "accounts.yaml" {
+		t.Errorf("Conflicting merge got %d %+v", status, resp)
+	}
+	if after, _ := store.LookupCommit(headBranch); after.Id != tip.Id {
+		t.Errorf("The conflicting merge moved %s to %s", headBranch, after.Id)
+	}
+
+	if _, status = merge(`{"source": "nope"}`); status != http.StatusNotFound {
+		t.Errorf("Unknown source got %d", status)
+	}
+	if _, status = merge(`{"source": "feature", "target": "nope"}`); status != http.StatusNotFound {
+		t.Errorf("Unknown target got %d", status)
+	}
+	if _, status = merge(`{}`); status != http.StatusBadRequest {
+		t.Errorf("No source got %d", status)
+	}
+}
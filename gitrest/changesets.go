@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/vsheffer/gofun/util"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+type ChangeSetFile struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+}
+
+type ChangeSetInfo struct {
+	Id      string          `json:"id"`
+	Branch  string          `json:"branch"`
+	Owner   string          `json:"owner"`
+	Base    string          `json:"base"`
+	Created time.Time       `json:"created"`
+	Files   []ChangeSetFile `json:"files"`
+}
+
+type ChangeSetListResponse struct {
+	ChangeSets []ChangeSetInfo `json:"changeSets"`
+}
+
+// changeSet holds changes to several files on a branch until they are
+// committed together.  A nil content stages the deletion of the file.
+type changeSet struct {
+	id      string
+	branch  string
+	owner   string
+	base    string
+	created time.Time
+	used    time.Time
+	files   map[string][]byte
+}
+
+// changeSetLifetime is how long a change set is kept after it was last used.
+const changeSetLifetime = 7 * 24 * time.Hour
+
+// changeSets holds the open change sets of a repository.  They live in
+// memory only and are lost when gitrest restarts.  Change sets that go
+// unused for longer than lifetime are dropped.
+type changeSets struct {
+	mutex    sync.Mutex
+	sets     map[string]*changeSet
+	lifetime time.Duration
+}
+
+func newChangeSets() *changeSets {
+	return &changeSets{sets: make(map[string]*changeSet), lifetime: changeSetLifetime}
+}
+
+// expire drops the change sets that went unused for too long.  The caller
+// holds the lock.
+func (cs *changeSets) expire() {
+	for id, set := range cs.sets {
+		if time.Since(set.used) > cs.lifetime {
+			delete(cs.sets, id)
+		}
+	}
+}
+
+// errChangeSetNotFound is returned for change sets that don't exist or that
+// belong to another user, who is told no more about them.
+var errChangeSetNotFound = notFound("no such change set")
+
+// lookup runs f on the change set id owned by user while holding the lock.
+func (cs *changeSets) lookup(id, user string, f func(set *changeSet) error) error {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	cs.expire()
+	set, ok := cs.sets[id]
+	if !ok || set.owner != user {
+		return errChangeSetNotFound
+	}
+	set.used = time.Now()
+	return f(set)
+}
+
+// take removes the change set id owned by user, so no one else can change
+// or commit it.
+func (cs *changeSets) take(id, user string) (*changeSet, error) {
+	var taken *changeSet
+	err := cs.lookup(id, user, func(set *changeSet) error {
+		taken = set
+		delete(cs.sets, id)
+		return nil
+	})
+	return taken, err
+}
+
+// put adds set, or puts back one that was taken but couldn't be committed.
+func (cs *changeSets) put(set *changeSet) {
+	cs.mutex.Lock()
+	cs.expire()
+	set.used = time.Now()
+	cs.sets[set.id] = set
+	cs.mutex.Unlock()
+}
+
+// changeSetInfo describes set, telling added files from modified ones by whether
+// they exist in the commit the change set was opened on.
+func (s *server) changeSetInfo(set *changeSet) ChangeSetInfo {
+	info := ChangeSetInfo{Id: set.id, Branch: set.branch, Owner: set.owner, Base: set.base, Created: set.created, Files: []ChangeSetFile{}}
+	for fileName, content := range set.files {
+		status := FileDeleted
+		if content != nil {
+			status = FileAdded
+			if _, err := s.readBase(set, fileName); err == nil {
+				status = FileModified
+			}
+		}
+		info.Files = append(info.Files, ChangeSetFile{Path: fileName, Status: status})
+	}
+	sort.Slice(info.Files, func(i, j int) bool { return info.Files[i].Path < info.Files[j].Path })
+	return info
+}
+
+// readBase reads fileName as it was when set was opened.
+func (s *server) readBase(set *changeSet, fileName string) ([]byte, error) {
+	if len(set.base) == 0 {
+		return nil, notFound("%s doesn't exist on %s", fileName, set.branch)
+	}
+	return s.store.ReadAt(set.base, fileName)
+}
+
+// conflicts returns the tip of the branch of set and the files of set that
+// were committed to the branch since it was opened.
+func (s *server) conflicts(set *changeSet) (string, []string, error) {
+	tip := ""
+	if commit, err := s.store.LookupCommit("refs/heads/" + set.branch); err == nil {
+		tip = commit.Id
+	} else if errorStatus(err) != http.StatusNotFound {
+		return "", nil, err
+	}
+	if tip == set.base {
+		return tip, nil, nil
+	}
+
+	var conflicts []string
+	for fileName := range set.files {
+		old, err := s.readBase(set, fileName)
+		if err != nil && errorStatus(err) != http.StatusNotFound {
+			return "", nil, err
+		}
+		current, err := s.store.ReadAt(tip, fileName)
+		if err != nil && errorStatus(err) != http.StatusNotFound {
+			return "", nil, err
+		}
+		if (old == nil) != (current == nil) || !bytes.Equal(old, current) {
+			conflicts = append(conflicts, fileName)
+		}
+	}
+	sort.Strings(conflicts)
+	return tip, conflicts, nil
+}
+
+// openChangeSetHandler opens a change set on the branch for the user.
+func (s *server) openChangeSetHandler(w http.ResponseWriter, r *http.Request) error {
+	branch, err := s.requestBranch(r)
+	if err != nil {
+		return err
+	}
+	base := ""
+	if commit, err := s.store.LookupCommit("refs/heads/" + branch); err == nil {
+		base = commit.Id
+	} else if errorStatus(err) != http.StatusNotFound {
+		return err
+	}
+	guid, err := util.NewGuid()
+	if err != nil {
+		return err
+	}
+	set := &changeSet{
+		id:      guid.String(),
+		branch:  branch,
+		owner:   authenticatedUser(r),
+		base:    base,
+		created: time.Now().UTC(),
+		files:   make(map[string][]byte),
+	}
+	s.changeSets.put(set)
+	requestLogger(r).Info("Opened change set", "changeSet", set.id, "branch", branch)
+	writeJSON(w, http.StatusCreated, s.changeSetInfo(set))
+	return nil
+}
+
+// listChangeSetsHandler lists the user's open change sets.
+func (s *server) listChangeSetsHandler(w http.ResponseWriter, r *http.Request) error {
+	user := authenticatedUser(r)
+	s.changeSets.mutex.Lock()
+	s.changeSets.expire()
+	list := []ChangeSetInfo{}
+	for _, set := range s.changeSets.sets {
+		if set.owner == user {
+			list = append(list, s.changeSetInfo(set))
+		}
+	}
+	s.changeSets.mutex.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	writeJSON(w, http.StatusOK, ChangeSetListResponse{ChangeSets: list})
+	return nil
+}
+
+func (s *server) getChangeSetHandler(w http.ResponseWriter, r *http.Request) error {
+	var info ChangeSetInfo
+	err := s.changeSets.lookup(mux.Vars(r)["id"], authenticatedUser(r), func(set *changeSet) error {
+		info = s.changeSetInfo(set)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, info)
+	return nil
+}
+
+// discardChangeSetHandler drops a change set without committing it.
+func (s *server) discardChangeSetHandler(w http.ResponseWriter, r *http.Request) error {
+	set, err := s.changeSets.take(mux.Vars(r)["id"], authenticatedUser(r))
+	if err != nil {
+		return err
+	}
+	requestLogger(r).Info("Discarded change set", "changeSet", set.id, "files", len(set.files))
+	writeJSON(w, http.StatusOK, Response{Status: Success, Message: "Change set " + set.id + " discarded."})
+	return nil
+}
+
+// stageFileHandler adds a spec file to a change set.
+func (s *server) stageFileHandler(w http.ResponseWriter, r *http.Request) error {
+	fileName := mux.Vars(r)["filename"]
+	fileBytes, err := readSpecBody(r, fileName)
+	if err != nil {
+		return err
+	}
+	if !validateSpec(w, r, fileName, fileBytes) {
+		return nil
+	}
+	if fileBytes, err = s.storedSpec(fileName, fileBytes); err != nil {
+		return err
+	}
+	err = s.changeSets.lookup(mux.Vars(r)["id"], authenticatedUser(r), func(set *changeSet) error {
+		set.files[fileName] = fileBytes
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, Response{Status: Success, Message: "File " + fileName + " staged."})
+	return nil
+}
+
+// unstageFileHandler stages the deletion of a spec file, or drops it from the
+// change set when it was only added there.
+func (s *server) unstageFileHandler(w http.ResponseWriter, r *http.Request) error {
+	fileName := mux.Vars(r)["filename"]
+	message := ""
+	err := s.changeSets.lookup(mux.Vars(r)["id"], authenticatedUser(r), func(set *changeSet) error {
+		_, err := s.readBase(set, fileName)
+		switch {
+		case err == nil:
+			set.files[fileName] = nil
+			message = "Deletion of " + fileName + " staged."
+		case errorStatus(err) != http.StatusNotFound:
+			return err
+		case set.files[fileName] != nil:
+			delete(set.files, fileName)
+			message = "File " + fileName + " unstaged."
+		default:
+			return notFound("%s doesn't exist on %s", fileName, set.branch)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, Response{Status: Success, Message: message})
+	return nil
+}
+
+// commitChangeSetHandler commits every file in a change set as one commit
+// with the message in the Commit-Message header.  It refuses with a 409 when
+// any of them was committed to the branch since the change set was opened.
+func (s *server) commitChangeSetHandler(w http.ResponseWriter, r *http.Request) error {
+	set, err := s.changeSets.take(mux.Vars(r)["id"], authenticatedUser(r))
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			s.changeSets.put(set)
+		}
+	}()
+
+	if len(set.files) == 0 {
+		return withStatus(http.StatusBadRequest, errors.New("the change set is empty"))
+	}
+	// The files were checked when they were staged, but the user's roles may
+	// have changed since.
+	for fileName := range set.files {
+		if s.userRole(r, fileName) < RoleEditor {
+			return errForbidden(r, RoleEditor, fileName)
+		}
+	}
+	message := r.Header.Get("Commit-Message")
+	if len(message) == 0 {
+		return withStatus(http.StatusBadRequest, errors.New("a Commit-Message header is required"))
+	}
+	updated := make(map[string][]byte)
+	for fileName, content := range set.files {
+		if content != nil {
+			updated[fileName] = content
+		}
+	}
+
+	// The commit only goes through on the tip the conflicts were looked for
+	// at.  When another commit sneaks in between, they are looked for again.
+	var commitId string
+	for {
+		tip, conflicts, err := s.conflicts(set)
+		if err != nil {
+			return err
+		}
+		if len(conflicts) > 0 {
+			writeJSON(w, http.StatusConflict, CommitResponse{
+				Status:    Error,
+				Message:   fmt.Sprintf("%d files were changed on %s since the change set was opened.", len(conflicts), set.branch),
+				Conflicts: conflicts,
+			})
+			return nil
+		}
+		if !s.checkCompat(w, set.branch, updated) {
+			return nil
+		}
+		commitId, err = s.store.CommitOn(set.branch, tip, requestIdentity(r), message, set.files)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errBranchMoved) {
+			return err
+		}
+	}
+	committed = true
+	requestLogger(r).Info("Committed change set", "changeSet", set.id, "branch", set.branch, "commit", commitId, "files", len(set.files))
+	s.publishCommit(set.branch, commitId)
+	writeJSON(w, http.StatusOK, CommitResponse{Status: Success, Message: fmt.Sprintf("Committed %d files to %s.", len(set.files), set.branch), Commit: commitId})
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sharedDefinitions is a fragment of schemas the specs of a repository refer
+// to.
+const sharedDefinitions = `Account:
+  type: object
+  properties:
+    id:
+      type: string
+`
+
+// openChangeSet opens a change set as user and returns its path.
+func openChangeSet(t *testing.T, request requestFunc, user string) string {
+	var info ChangeSetInfo
+	w := request("POST", "/changesets", user, nil, nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("Open got %d %s", w.Code, w.Body.String())
+	}
+	return "/changesets/" + info.Id
+}
+
+func TestChangeSets(t *testing.T) {
+	store := newMemStore("master")
+	srv := newServer(store)
+	jdoe := Identity{Name: "jdoe", Email: "jdoe@example.com"}
+	store.Commit("master", jdoe, "Add specs", map[string][]byte{
+		"definitions.yaml": []byte(sharedDefinitions),
+		"owners.yaml":      []byte(accountsSpec),
+	})
+	modified := sharedDefinitions + "Owner:\n  type: string\n"
+	request := routed(srv.routes)
+
+	jdoeSet, asmithSet := openChangeSet(t, request, "jdoe"), openChangeSet(t, request, "asmith")
+	request("PUT", jdoeSet+"/files/definitions.yaml", "jdoe", nil, strings.NewReader(modified))
+	request("PUT", jdoeSet+"/files/accounts.yaml", "jdoe", nil, strings.NewReader(accountsSpec))
+	request("PUT", jdoeSet+"/files/pets.yaml", "jdoe", nil, strings.NewReader(accountsSpec))
+	request("DELETE", jdoeSet+"/files/owners.yaml", "jdoe", nil, nil)
+	request("DELETE", jdoeSet+"/files/pets.yaml", "jdoe", nil, nil)
+	request("PUT", asmithSet+"/files/definitions.yaml", "asmith", nil, strings.NewReader(sharedDefinitions+"\n"))
+
+	var info ChangeSetInfo
+	json.Unmarshal(request("GET", jdoeSet, "jdoe", nil, nil).Body.Bytes(), &info)
+	var files []string
+	for _, file := range info.Files {
+		files = append(files, file.Path+":"+file.Status)
+	}
+	if strings.Join(files, ",") != "accounts.yaml:added,definitions.yaml:modified,owners.yaml:deleted" {
+		t.Errorf("Staged %v", files)
+	}
+
+	expectError(t, "another user's change set", request("GET", jdoeSet, "asmith", nil, nil), http.StatusNotFound)
+	expectError(t, "staging into another user's change set", request("PUT", jdoeSet+"/files/x.yaml", "asmith", nil, strings.NewReader(accountsSpec)), http.StatusNotFound)
+	expectError(t, "deleting a missing file", request("DELETE", jdoeSet+"/files/nope.yaml", "jdoe", nil, nil), http.StatusNotFound)
+	expectError(t, "no message", request("POST", jdoeSet+"/commit", "jdoe", nil, nil), http.StatusBadRequest)
+	if w := request("PUT", jdoeSet+"/files/bad.yaml", "jdoe", nil, strings.NewReader("nope: [")); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Staged an invalid spec: %d", w.Code)
+	}
+
+	var resp CommitResponse
+	w := request("POST", jdoeSet+"/commit", "jdoe", http.Header{"Commit-Message": {"Share definitions"}}, nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Commit got %d %s", w.Code, w.Body.String())
+	}
+	commit, _ := store.LookupCommit("master")
+	if commit.Id != resp.Commit || commit.Message != "Share definitions" ||
+		strings.Join(commit.Files, ",") != "accounts.yaml,definitions.yaml,owners.yaml" {
+		t.Errorf("Committed %+v", commit)
+	}
+	if _, err := store.ReadAt("master", "owners.yaml"); err == nil {
+		t.Error("owners.yaml wasn't deleted")
+	}
+	expectError(t, "committed change set", request("GET", jdoeSet, "jdoe", nil, nil), http.StatusNotFound)
+
+	// asmith's change set touches definitions.yaml, which jdoe committed since.
+	w = request("POST", asmithSet+"/commit", "asmith", http.Header{"Commit-Message": {"Reformat definitions"}}, nil)
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusConflict || strings.Join(resp.Conflicts, ",") != "definitions.yaml" {
+		t.Errorf("Conflicting commit got %d %s", w.Code, w.Body.String())
+	}
+	if w = request("DELETE", asmithSet, "asmith", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("Discard got %d %s", w.Code, w.Body.String())
+	}
+	var list ChangeSetListResponse
+	json.Unmarshal(request("GET", "/changesets", "asmith", nil, nil).Body.Bytes(), &list)
+	if len(list.ChangeSets) != 0 {
+		t.Errorf("asmith still has %+v", list.ChangeSets)
+	}
+}
+
+func TestCommitConflictingChangeSets(t *testing.T) {
+	store := newMemStore("master")
+	srv := newServer(store)
+	store.Commit("master", Identity{Name: "jdoe", Email: "jdoe@example.com"}, "Add definitions", map[string][]byte{
+		"definitions.yaml": []byte(sharedDefinitions),
+	})
+	request := routed(srv.routes)
+
+	users := []string{"jdoe", "asmith"}
+	sets := make([]string, len(users))
+	for i, user := range users {
+		sets[i] = openChangeSet(t, request, user)
+		content := sharedDefinitions + user + ":\n  type: string\n"
+		if w := request("PUT", sets[i]+"/files/definitions.yaml", user, nil, strings.NewReader(content)); w.Code != http.StatusOK {
+			t.Fatalf("Staging got %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	codes := make([]int, len(users))
+	var wg sync.WaitGroup
+	for i, user := range users {
+		wg.Add(1)
+		go func(i int, user string) {
+			defer wg.Done()
+			codes[i] = request("POST", sets[i]+"/commit", user, http.Header{"Commit-Message": {"Add " + user}}, nil).Code
+		}(i, user)
+	}
+	wg.Wait()
+
+	sort.Ints(codes)
+	if codes[0] != http.StatusOK || codes[1] != http.StatusConflict {
+		t.Fatalf("Concurrent commits got %v", codes)
+	}
+	commit, _ := store.LookupCommit("master")
+	content, _ := store.ReadAt("master", "definitions.yaml")
+	winner := strings.TrimPrefix(commit.Message, "Add ")
+	if !strings.Contains(string(content), winner+":") || commit.Message == "Add definitions" {
+		t.Errorf("master has %s after %q", content, commit.Message)
+	}
+}
+
+func TestChangeSetFileRoles(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "authz.yaml")
+	os.WriteFile(policyFile, []byte(importPolicy), 0644)
+	store := newMemStore("master")
+	srv := newServer(store)
+	var err error
+	if srv.authz, err = newAuthorizer(policyFile); err != nil {
+		t.Fatal(err)
+	}
+	request := routed(srv.routes)
+
+	// jdoe has no role for the whole repository but may edit specs/.
+	set := openChangeSet(t, request, "jdoe")
+	expectError(t, "staging a readable file", request("PUT", set+"/files/shared/common.yaml", "jdoe", nil, strings.NewReader(accountsSpec)), http.StatusForbidden)
+	if w := request("PUT", set+"/files/specs/accounts.yaml", "jdoe", nil, strings.NewReader(accountsSpec)); w.Code != http.StatusOK {
+		t.Fatalf("Staging got %d %s", w.Code, w.Body.String())
+	}
+	if w := request("POST", set+"/commit", "jdoe", http.Header{"Commit-Message": {"Add accounts"}}, nil); w.Code != http.StatusOK {
+		t.Fatalf("Commit got %d %s", w.Code, w.Body.String())
+	}
+
+	// jdoe is only a reader of specs/ by the time the next one is committed.
+	set = openChangeSet(t, request, "jdoe")
+	request("PUT", set+"/files/specs/accounts.yaml", "jdoe", nil, strings.NewReader(accountsSpec+"\n"))
+	os.WriteFile(policyFile, []byte("default: none\ngrants:\n  - role: reader\n    users: [jdoe]\n    prefix: specs/\n"), 0644)
+	if srv.authz, err = newAuthorizer(policyFile); err != nil {
+		t.Fatal(err)
+	}
+	w := request("POST", set+"/commit", "jdoe", http.Header{"Commit-Message": {"Reformat accounts"}}, nil)
+	expectError(t, "committing a file no longer editable", w, http.StatusForbidden)
+	if !strings.Contains(w.Body.String(), "specs/accounts.yaml") {
+		t.Errorf("Committing a file no longer editable got %s", w.Body.String())
+	}
+	if commit, _ := store.LookupCommit("master"); commit.Message != "Add accounts" {
+		t.Errorf("Committed %q", commit.Message)
+	}
+}
+
+func TestChangeSetExpiry(t *testing.T) {
+	srv := newServer(newMemStore("master"))
+	request := routed(srv.routes)
+	srv.changeSets.lifetime = time.Hour
+
+	old, recent := openChangeSet(t, request, "jdoe"), openChangeSet(t, request, "jdoe")
+	srv.changeSets.sets[strings.TrimPrefix(old, "/changesets/")].used = time.Now().Add(-2 * time.Hour)
+	expectError(t, "expired change set", request("GET", old, "jdoe", nil, nil), http.StatusNotFound)
+	if w := request("GET", recent, "jdoe", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("Recent change set got %d %s", w.Code, w.Body.String())
+	}
+	if len(srv.changeSets.sets) != 1 {
+		t.Errorf("%d change sets are kept", len(srv.changeSets.sets))
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/vsheffer/gofun/codegen"
+	"go/token"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// codegenHandler generates a Go package from a spec file at ?ref=, bundled
+// with the files it refers to, and returns it as a zip archive.  The
+// package is named by ?package=, after the file by default.
+func (s *server) codegenHandler(w http.ResponseWriter, r *http.Request) error {
+	fileName := mux.Vars(r)["filename"]
+	pkg := r.URL.Query().Get("package")
+	if len(pkg) == 0 {
+		pkg = strings.ToLower(strings.TrimSuffix(path.Base(fileName), path.Ext(fileName)))
+		pkg = strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, pkg)
+		if len(pkg) == 0 || pkg[0] >= '0' && pkg[0] <= '9' {
+			pkg = "client"
+		}
+	}
+	if !token.IsIdentifier(pkg) {
+		return withStatus(http.StatusBadRequest, fmt.Errorf("invalid package name %q", pkg))
+	}
+	ref, err := s.requestRef(r)
+	if err != nil {
+		return err
+	}
+	commit, bundle, err := s.bundleAt(r, ref, fileName)
+	if err != nil {
+		return err
+	}
+	files, err := codegen.Generate(bundle, codegen.Options{Package: pkg})
+	if err != nil {
+		return withStatus(http.StatusUnprocessableEntity, fmt.Errorf("can't generate code for %s: %v", fileName, err))
+	}
+
+	fileNames := make([]string, 0, len(files))
+	prefixed := make(map[string][]byte, len(files))
+	for name, content := range files {
+		fileNames = append(fileNames, pkg+"/"+name)
+		prefixed[pkg+"/"+name] = content
+	}
+	sort.Strings(fileNames)
+	var buf bytes.Buffer
+	if err = writeZip(&buf, fileNames, prefixed, commit.Time); err != nil {
+		return err
+	}
+	requestLogger(r).Debug("Generated code", "file", fileName, "commit", commit.Id, "package", pkg)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-%.12s.zip", pkg, commit.Id)))
+	_, err = w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCodegen(t *testing.T) {
+	store := newMemStore("master")
+	srv := newServer(store)
+	jdoe := Identity{Name: "jdoe", Email: "jdoe@example.com"}
+	store.Commit("master", jdoe, "Add pets", map[string][]byte{
+		"pets.yaml":         []byte(petsSpec),
+		"common/pet.yaml":   []byte(petSpec),
+		"common/owner.yaml": []byte("type: string\n"),
+	})
+
+	w := serve("GET", "/codegen/{filename:.+}", "/codegen/pets.yaml", nil, srv.codegenHandler)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("Got %d %s", w.Code, w.Body.String())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(content)
+	}
+	if len(files) != 2 || !strings.Contains(files["pets/client.go"], "func (c *Client) GetPets(ctx context.Context) (*GetPetsResponse, error)") ||
+		!strings.Contains(files["pets/models.go"], "Owner *string `json:\"owner,omitempty\"`") {
+		t.Errorf("Unexpected package: %v", files)
+	}
+
+	w = serve("GET", "/codegen/{filename:.+}", "/codegen/pets.yaml?package=api", nil, srv.codegenHandler)
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Disposition"), "api-") {
+		t.Errorf("Got %d %v", w.Code, w.Header())
+	}
+	expectError(t, "invalid package", serve("GET", "/codegen/{filename:.+}", "/codegen/pets.yaml?package=func", nil, srv.codegenHandler), http.StatusBadRequest)
+	expectError(t, "missing file", serve("GET", "/codegen/{filename:.+}", "/codegen/nope.yaml", nil, srv.codegenHandler), http.StatusNotFound)
+}
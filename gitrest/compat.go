@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/vsheffer/gofun/openapi"
+	"net/http"
+	"sort"
+)
+
+type CompatResponse struct {
+	Status   string           `json:"status"`
+	Message  string           `json:"message"`
+	Breaking bool             `json:"breaking"`
+	Changes  []openapi.Change `json:"changes"`
+}
+
+// breakingChanges compares files, the new content of some spec files, with
+// the versions committed at the tip of branch.  Files that aren't committed
+// yet can't break anything.  Deleted files, with a nil content, are left out
+// on purpose: dropping a spec is a decision, not the side effect of an edit.
+// Files that can't be compared fail with a 422 rather than slip through.
+func (s *server) breakingChanges(branch string, files map[string][]byte) ([]openapi.Change, error) {
+	fileNames := make([]string, 0, len(files))
+	for fileName := range files {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+
+	var changes []openapi.Change
+	for _, fileName := range fileNames {
+		if files[fileName] == nil {
+			continue
+		}
+		old, err := s.store.ReadAt("refs/heads/"+branch, fileName)
+		if errorStatus(err) == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, withStatus(http.StatusUnprocessableEntity, fmt.Errorf("can't read %s on %s: %v", fileName, branch, err))
+		}
+		fileChanges, err := openapi.Compare(old, files[fileName])
+		if err != nil {
+			return nil, withStatus(http.StatusUnprocessableEntity, fmt.Errorf("can't compare %s: %v", fileName, err))
+		}
+		for _, change := range fileChanges {
+			change.File = fileName
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// checkCompat enforces the compatibility gate for commits of files to
+// branch.  It answers the request itself with a 409 and returns false when
+// the commit would break clients, and with an error when it can't tell.
+func (s *server) checkCompat(w http.ResponseWriter, branch string, files map[string][]byte) bool {
+	gated := false
+	for _, b := range s.compatGate {
+		gated = gated || b == branch
+	}
+	if !gated {
+		return true
+	}
+
+	changes, err := s.breakingChanges(branch, files)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return false
+	}
+	if len(changes) > 0 {
+		writeJSON(w, http.StatusConflict, CompatResponse{
+			Status:   Error,
+			Message:  fmt.Sprintf("Commit to %s has %d breaking changes.", branch, len(changes)),
+			Breaking: true,
+			Changes:  changes,
+		})
+		return false
+	}
+	return true
+}
+
+// compatHandler compares two revisions of a spec file.  They default to the
+// tip of the branch and the saved copy on it.
+func (s *server) compatHandler(w http.ResponseWriter, r *http.Request) {
+	fileName := mux.Vars(r)["filename"]
+	branch, err := s.requestBranch(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if len(from) == 0 {
+		from = "refs/heads/" + branch
+	}
+	old, err := s.store.ReadAt(from, fileName)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	var current []byte
+	if len(to) == 0 {
+		current, err = s.store.Read(branch, fileName)
+	} else {
+		current, err = s.store.ReadAt(to, fileName)
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	changes, err := openapi.Compare(old, current)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	for i := range changes {
+		changes[i].File = fileName
+	}
+	writeJSON(w, http.StatusOK, CompatResponse{
+		Status:   Success,
+		Message:  fmt.Sprintf("%d breaking changes.", len(changes)),
+		Breaking: len(changes) > 0,
+		Changes:  changes,
+	})
+}
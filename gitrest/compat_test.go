@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBreakingChangesErrors(t *testing.T) {
+	store := newMemStore("master")
+	srv := newServer(store)
+	jdoe := Identity{Name: "jdoe", Email: "jdoe@example.com"}
+	store.Commit("master", jdoe, "Add specs", map[string][]byte{
+		"accounts.yaml": []byte(accountsSpec),
+		"broken.yaml":   []byte("paths: [\n"),
+	})
+
+	if changes, err := srv.breakingChanges("master", map[string][]byte{"pets.yaml": []byte(accountsSpec)}); err != nil || len(changes) > 0 {
+		t.Errorf("New file got %+v, %v", changes, err)
+	}
+
+	tests := []struct {
+		fileName, content string
+	}{
+		{"accounts.yaml", "paths: [\n"},
+		{"broken.yaml", accountsSpec},
+	}
+	for _, test := range tests {
+		_, err := srv.breakingChanges("master", map[string][]byte{test.fileName: []byte(test.content)})
+		if errorStatus(err) != http.StatusUnprocessableEntity || !strings.Contains(err.Error(), test.fileName) {
+			t.Errorf("%s got %v", test.fileName, err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ghodss/yaml"
+	"github.com/vsheffer/gofun/openapi"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// The formats spec files are written and served in.
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+)
+
+// bodyFormat returns the format of a spec file sent as content, from the
+// Content-Type of r or, without a specific one, from the content itself.
+func bodyFormat(r *http.Request, content []byte) (string, error) {
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	switch {
+	case len(contentType) == 0 || mediaType == "text/plain" || mediaType == "application/octet-stream":
+		if bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+			return formatJSON, nil
+		}
+		return formatYAML, nil
+	case err != nil:
+		return "", withStatus(http.StatusUnsupportedMediaType, fmt.Errorf("bad Content-Type %q", contentType))
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return formatJSON, nil
+	case strings.HasSuffix(mediaType, "yaml") || strings.HasSuffix(mediaType, "yml"):
+		return formatYAML, nil
+	}
+	return "", withStatus(http.StatusUnsupportedMediaType, fmt.Errorf("spec files must be sent as JSON or YAML, not %s", mediaType))
+}
+
+// readSpecBody reads a spec file sent as JSON or YAML.  Content claimed to be
+// JSON has to be JSON.
+func readSpecBody(r *http.Request, fileName string) ([]byte, error) {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, withStatus(http.StatusBadRequest, fmt.Errorf("can't read %s: %v", fileName, err))
+	}
+	format, err := bodyFormat(r, content)
+	if err != nil {
+		return nil, err
+	}
+	if format == formatJSON && !json.Valid(content) {
+		return nil, withStatus(http.StatusBadRequest, fmt.Errorf("%s isn't valid JSON", fileName))
+	}
+	return content, nil
+}
+
+// canonicalSpec rewrites content, a valid spec, in format with its keys
+// sorted and two space indentation, so that equal specs are stored the same
+// way however they were written.
+func canonicalSpec(content []byte, format string) ([]byte, error) {
+	jsonBytes, err := yaml.YAMLToJSON(content)
+	if err != nil {
+		return nil, err
+	}
+	if format == formatYAML {
+		return yaml.JSONToYAML(jsonBytes)
+	}
+	var buf bytes.Buffer
+	if err = json.Indent(&buf, jsonBytes, "", "  "); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// storedSpec returns content the way it's stored: in the canonical format of
+// the server when there is one, and as written otherwise.
+func (s *server) storedSpec(fileName string, content []byte) ([]byte, error) {
+	if len(s.canonicalFormat) == 0 {
+		return content, nil
+	}
+	stored, err := canonicalSpec(content, s.canonicalFormat)
+	if err != nil {
+		return nil, withStatus(http.StatusUnprocessableEntity, fmt.Errorf("can't convert %s to %s: %v", fileName, s.canonicalFormat, err))
+	}
+	return stored, nil
+}
+
+// writeSpec answers r with a spec file.  It is converted to OpenAPI 3 with
+// ?convert=openapi3 and served as YAML when the Accept header asks for it,
+// and as JSON otherwise.
+func writeSpec(w http.ResponseWriter, r *http.Request, fileName string, content []byte) error {
+	switch convert := r.URL.Query().Get("convert"); convert {
+	case "":
+	case "openapi3":
+		converted, err := openapi.ConvertToOpenAPI3(content)
+		if err != nil {
+			return withStatus(http.StatusUnprocessableEntity, fmt.Errorf("can't convert %s to OpenAPI 3: %v", fileName, err))
+		}
+		content = converted
+	default:
+		return withStatus(http.StatusBadRequest, fmt.Errorf("unknown conversion %q, use openapi3", convert))
+	}
+
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", "application/yaml")
+	if strings.Index(r.Header.Get("Accept"), "yaml") < 0 {
+		var err error
+		if content, err = yaml.YAMLToJSON(content); err != nil {
+			return withStatus(http.StatusUnprocessableEntity, fmt.Errorf("%s isn't valid YAML: %v", fileName, err))
+		}
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Write(content)
+	return nil
+}
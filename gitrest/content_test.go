@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyFormat(t *testing.T) {
+	tests := []struct {
+		contentType, body, format string
+		status                    int
+	}{
+		{"", accountsSpec, formatYAML, 0},
+		{"", ` {"swagger": "2.0"}`, formatJSON, 0},
+		{"text/plain; charset=utf-8", `{}`, formatJSON, 0},
+		{"application/json", accountsSpec, formatJSON, 0},
+		{"application/vnd.oai.openapi+json", `{}`, formatJSON, 0},
+		{"application/yaml", `{}`, formatYAML, 0},
+		{"text/x-yaml", accountsSpec, formatYAML, 0},
+		{"text/html", accountsSpec, "", http.StatusUnsupportedMediaType},
+		{"application/", accountsSpec, "", http.StatusUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("PUT", "/specfiles/accounts.yaml", nil)
+		r.Header.Set("Content-Type", test.contentType)
+		format, err := bodyFormat(r, []byte(test.body))
+		if format != test.format || err != nil && errorStatus(err) != test.status || err == nil && test.status != 0 {
+			t.Errorf("bodyFormat(%q) = %q, %v", test.contentType, format, err)
+		}
+	}
+}
+
+func TestCanonicalFormat(t *testing.T) {
+	store := newMemStore("master")
+	srv := newServer(store)
+	srv.canonicalFormat = formatJSON
+	request := routed(srv.routes)
+
+	if w := request("PUT", "/specfiles/accounts.yaml", "jdoe", http.Header{"Content-Type": {"application/yaml"}}, strings.NewReader(accountsSpec)); w.Code != http.StatusOK {
+		t.Fatalf("Save got %d %s", w.Code, w.Body.String())
+	}
+	stored, _ := store.ReadSaved("master", "accounts.yaml")
+	if !strings.HasPrefix(string(stored), "{\n  \"definitions\": {") || !strings.HasSuffix(string(stored), "}\n") {
+		t.Errorf("Stored %s", stored)
+	}
+
+	// The same spec written as JSON in another order is stored the same way.
+	request("PUT", "/specfiles/accounts.yaml", "jdoe", nil, strings.NewReader(`{"paths": {}, "info": {"version": "1.0", "title": "Accounts"}, "swagger": "2.0"}`))
+	request("PUT", "/specfiles/other.yaml", "jdoe", nil, strings.NewReader(`{"swagger": "2.0", "info": {"title": "Accounts", "version": "1.0"}, "paths": {}}`))
+	first, _ := store.ReadSaved("master", "accounts.yaml")
+	second, _ := store.ReadSaved("master", "other.yaml")
+	if string(first) != string(second) {
+		t.Errorf("Stored differently:\n%s\n%s", first, second)
+	}
+
+	expectError(t, "YAML sent as JSON", request("PUT", "/specfiles/accounts.yaml", "jdoe", http.Header{"Content-Type": {"application/json"}}, strings.NewReader(accountsSpec)), http.StatusBadRequest)
+	expectError(t, "HTML", request("PUT", "/specfiles/accounts.yaml", "jdoe", http.Header{"Content-Type": {"text/html"}}, strings.NewReader(accountsSpec)), http.StatusUnsupportedMediaType)
+}
+
+func TestConvertOnRead(t *testing.T) {
+	store := newMemStore("master")
+	srv := newServer(store)
+	store.Write("master", "accounts.yaml", []byte(accountsSpec))
+	route := "/specfiles/{filename:.+}"
+
+	w := serve("GET", route, "/specfiles/accounts.yaml?convert=openapi3", nil, srv.getSpecFileHandler)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" ||
+		!strings.Contains(w.Body.String(), `"openapi":"3.0.3"`) || !strings.Contains(w.Body.String(), `"schemas":{"Account"`) {
+		t.Errorf("Got %d %s", w.Code, w.Body.String())
+	}
+
+	w = routed(srv.routes)("GET", "/specfiles/accounts.yaml?convert=openapi3", "jdoe", http.Header{"Accept": {"application/yaml"}}, nil)
+	if w.Header().Get("Content-Type") != "application/yaml" || !strings.HasPrefix(w.Body.String(), "openapi: 3.0.3\n") {
+		t.Errorf("Got %s %s", w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	expectError(t, "unknown conversion", serve("GET", route, "/specfiles/accounts.yaml?convert=raml", nil, srv.getSpecFileHandler), http.StatusBadRequest)
+	store.Write("master", "notes.yaml", []byte("title: Notes"))
+	expectError(t, "not a spec", serve("GET", route, "/specfiles/notes.yaml?convert=openapi3", nil, srv.getSpecFileHandler), http.StatusUnprocessableEntity)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// corsMethods and corsHeaders are what the browser editor is allowed to send.
+var (
+	corsMethods       = []string{"GET", "HEAD", "PUT", "POST", "DELETE", "OPTIONS"}
+	corsHeaders       = []string{"Accept", "Authorization", "Commit-Message", "Content-Type", "X-Requested-With"}
+	corsExposeHeaders = []string{usernameHeader, requestIdHeader}
+)
+
+// corsPolicy adds CORS headers to the responses for allowed origins.  It sits
+// in front of the authenticator so that preflight requests, which browsers
+// send without credentials, are answered without asking for any.
+type corsPolicy struct {
+	origins  map[string]bool
+	allowAll bool
+	maxAge   int
+}
+
+// newCORSPolicy allows the origins given, each of which may also be a comma
+// separated list.  "*" or no origins at all allows every origin, but then
+// browsers won't send credentials along.
+func newCORSPolicy(origins []string) *corsPolicy {
+	c := &corsPolicy{origins: make(map[string]bool), maxAge: 600}
+	for _, list := range origins {
+		for _, origin := range strings.Split(list, ",") {
+			origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+			if origin == "*" {
+				c.allowAll = true
+			} else if len(origin) > 0 {
+				c.origins[strings.ToLower(origin)] = true
+			}
+		}
+	}
+	if len(c.origins) == 0 {
+		c.allowAll = true
+	}
+	return c
+}
+
+func (c *corsPolicy) allowed(origin string) bool {
+	return c.allowAll || c.origins[strings.ToLower(origin)]
+}
+
+// setHeaders sets the headers every response to an allowed origin gets.
+func (c *corsPolicy) setHeaders(w http.ResponseWriter, origin string) {
+	h := w.Header()
+	if c.origins[strings.ToLower(origin)] {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+	} else {
+		h.Set("Access-Control-Allow-Origin", "*")
+	}
+	h.Set("Access-Control-Expose-Headers", strings.Join(corsExposeHeaders, ", "))
+}
+
+// Wrap handles preflight requests itself and passes everything else on to h.
+func (c *corsPolicy) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if len(origin) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+
+		preflight := r.Method == "OPTIONS" && len(r.Header.Get("Access-Control-Request-Method")) > 0
+		if !c.allowed(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		c.setHeaders(w, origin)
+		if !preflight {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(corsMethods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsHeaders, ", "))
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(c.maxAge))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
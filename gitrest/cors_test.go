@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	reached := false
+	c := newCORSPolicy([]string{"https://editor.example.com, https://docs.example.com", "http://localhost:3000"})
+	handler := c.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	req := httptest.NewRequest("OPTIONS", "/specfiles/pets.yaml", nil)
+	req.Header.Set("Origin", "https://docs.example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if reached || w.Code != http.StatusNoContent {
+		t.Fatalf("Preflight reached the handler or got %d", w.Code)
+	}
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://docs.example.com" || w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("Unexpected headers %v", w.Header())
+	}
+	if w.Header().Get("Access-Control-Allow-Methods") == "" || w.Header().Get("Access-Control-Allow-Headers") == "" {
+		t.Errorf("No methods or headers allowed: %v", w.Header())
+	}
+
+	req.Header.Set("Origin", "https://evil.example.com")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if reached || w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Preflight from an unknown origin got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestCORSRequest(t *testing.T) {
+	handler := newCORSPolicy([]string{"http://localhost:3000"}).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	req := httptest.NewRequest("PUT", "/specfiles/pets.yaml", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("Access-Control-Allow-Origin") != "http://localhost:3000" {
+		t.Errorf("Got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestCORSAllowAll(t *testing.T) {
+	handler := newCORSPolicy(nil).Wrap(http.NotFoundHandler())
+	req := httptest.NewRequest("OPTIONS", "/commitfile/pets.yaml", nil)
+	req.Header.Set("Origin", "https://anywhere.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("Got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestCORSVary(t *testing.T) {
+	store := newMemStore("master")
+	store.Commit("master", Identity{Name: "jdoe", Email: "jdoe@example.com"}, "Add accounts", map[string][]byte{"accounts.yaml": []byte(accountsSpec)})
+	r := mux.NewRouter()
+	newServer(store).routes(r)
+	handler := newCORSPolicy([]string{"http://localhost:3000"}).Wrap(r)
+
+	req := httptest.NewRequest("GET", "/specfiles/accounts.yaml", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set(usernameHeader, "jdoe")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if vary := strings.Join(w.Header().Values("Vary"), ","); w.Code != http.StatusOK || vary != "Origin,Accept" {
+		t.Errorf("Got %d with Vary %q", w.Code, vary)
+	}
+}
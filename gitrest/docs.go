@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ghodss/yaml"
+	"github.com/gorilla/mux"
+	"github.com/vsheffer/gofun/docs"
+	"github.com/vsheffer/gofun/openapi"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// docsIndexHandler returns an HTML page linking to the documentation of the
+// specs at ?ref= the user can read.
+func (s *server) docsIndexHandler(w http.ResponseWriter, r *http.Request) error {
+	ref, err := s.requestRef(r)
+	if err != nil {
+		return err
+	}
+	commit, err := s.store.LookupCommit(ref)
+	if err != nil {
+		return withStatus(http.StatusNotFound, err)
+	}
+	files, err := s.committedFiles(commit.Id)
+	if err != nil {
+		return err
+	}
+
+	var links []docs.Link
+	for name, content := range files {
+		if checkFileName(name) != nil || s.userRole(r, name) < RoleReader {
+			continue
+		}
+		if _, err := openapi.Summarize(content); err != nil {
+			continue
+		}
+		link := docs.Link{Name: name, URL: "docs/" + (&url.URL{Path: name}).EscapedPath()}
+		if query := r.URL.RawQuery; len(query) > 0 {
+			link.URL += "?" + query
+		}
+		var spec struct {
+			Info struct {
+				Title string `json:"title"`
+			} `json:"info"`
+		}
+		if yaml.Unmarshal(content, &spec) == nil {
+			link.Title = spec.Info.Title
+		}
+		links = append(links, link)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Name < links[j].Name })
+
+	var buf bytes.Buffer
+	if err = docs.RenderIndex(&buf, fmt.Sprintf("Specs at %.12s", commit.Id), links); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// docsHandler returns an HTML page documenting a spec file at ?ref=, bundled
+// with the files it refers to.
+func (s *server) docsHandler(w http.ResponseWriter, r *http.Request) error {
+	fileName := mux.Vars(r)["filename"]
+	ref, err := s.requestRef(r)
+	if err != nil {
+		return err
+	}
+	commit, bundle, err := s.bundleAt(r, ref, fileName)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err = docs.Render(&buf, bundle, docs.Options{File: fileName, Commit: commit.Id}); err != nil {
+		return withStatus(http.StatusUnprocessableEntity, fmt.Errorf("can't document %s: %v", fileName, err))
+	}
+	requestLogger(r).Debug("Rendered docs", "file", fileName, "commit", commit.Id)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err = w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDocs(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "authz.yaml")
+	os.WriteFile(policyFile, []byte(referencesPolicy), 0644)
+	store := newMemStore("master")
+	srv := newServer(store)
+	var err error
+	if srv.authz, err = newAuthorizer(policyFile); err != nil {
+		t.Fatal(err)
+	}
+	jdoe := Identity{Name: "jdoe", Email: "jdoe@example.com"}
+	store.Commit("master", jdoe, "Add pets", map[string][]byte{
+		"pets.yaml":         []byte(petsSpec),
+		"common/pet.yaml":   []byte(petSpec),
+		"common/owner.yaml": []byte("type: string\n"),
+		"private/uses.yaml": []byte("swagger: \"2.0\"\ninfo:\n  title: Private\n  version: \"1.0\"\npaths: {}\n"),
+	})
+
+	request := routed(srv.routes)
+
+	w := request("GET", "/docs/pets.yaml", "asmith", nil, nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") ||
+		!strings.Contains(w.Body.String(), `<title>Pets 1.0</title>`) || !strings.Contains(w.Body.String(), `<td><code>owner</code></td>`) {
+		t.Errorf("Got %d %s", w.Code, w.Body.String())
+	}
+	expectError(t, "unreadable", request("GET", "/docs/private/uses.yaml", "asmith", nil, nil), http.StatusForbidden)
+	expectError(t, "not a spec", request("GET", "/docs/common/pet.yaml", "asmith", nil, nil), http.StatusUnprocessableEntity)
+
+	w = request("GET", "/docs?branch=master", "asmith", nil, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<a href="docs/pets.yaml?branch=master">pets.yaml</a> <small>Pets</small>`) ||
+		strings.Contains(w.Body.String(), "private/uses.yaml") || strings.Contains(w.Body.String(), "common/pet.yaml") {
+		t.Errorf("Got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"github.com/libgit2/git2go"
+	"net/http"
+	"os"
+)
+
+// statusError is an error that knows the status it should be answered with.
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+// withStatus makes err answer with status instead of the one errorStatus
+// would pick.
+func withStatus(status int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &statusError{status, err}
+}
+
+// errorStatus maps an error returned by a handler to a status code.  Missing
+// branches, refs and files are 404, everything else unexpected is 500.
+func errorStatus(err error) int {
+	var se *statusError
+	switch {
+	case errors.As(err, &se):
+		return se.status
+	case errors.Is(err, errBranchNotFound), errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	}
+	var gitErr *git.GitError
+	if errors.As(err, &gitErr) && gitErr.Code == git.ErrNotFound {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// errorHandler is a handler that returns its errors instead of writing them.
+type errorHandler func(w http.ResponseWriter, r *http.Request) error
+
+// handle turns h into an http.HandlerFunc that answers the errors h returns
+// with a Response whose status is Error.  Server errors are logged.
+func handle(h errorHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := h(w, r)
+		if err == nil {
+			return
+		}
+		status := errorStatus(err)
+		if status >= 500 {
+			requestLogger(r).Error("Request failed", "status", status, "error", err)
+		} else {
+			requestLogger(r).Debug("Request rejected", "status", status, "error", err)
+		}
+		writeError(w, status, err)
+	}
+}
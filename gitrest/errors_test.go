@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/libgit2/git2go"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{withStatus(http.StatusConflict, errors.New("conflict")), http.StatusConflict},
+		{fmt.Errorf("wrapped: %w", withStatus(http.StatusBadRequest, errors.New("bad"))), http.StatusBadRequest},
+		{errBranchNotFound, http.StatusNotFound},
+		{&os.PathError{Op: "open", Path: "pets.yaml", Err: os.ErrNotExist}, http.StatusNotFound},
+		{&git.GitError{Message: "not found", Code: git.ErrNotFound}, http.StatusNotFound},
+		{errors.New("disk full"), http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		if status := errorStatus(test.err); status != test.status {
+			t.Errorf("errorStatus(%v) = %d, want %d", test.err, status, test.status)
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	h := handle(func(w http.ResponseWriter, r *http.Request) error {
+		return withStatus(http.StatusUnprocessableEntity, errors.New("not a spec"))
+	})
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/", nil))
+	expectError(t, "handle", w, http.StatusUnprocessableEntity)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/vsheffer/gofun/util"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CommitEvent describes one commit made through gitrest.
+type CommitEvent struct {
+	Id      string    `json:"id"`
+	Type    string    `json:"type"`
+	Repo    string    `json:"repo"`
+	Branch  string    `json:"branch"`
+	Files   []string  `json:"files"`
+	Commit  string    `json:"commit"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+type notifier struct {
+	mutex       sync.Mutex
+	subscribers map[chan CommitEvent]string
+	webhooks    map[string]chan CommitEvent
+	secret      []byte
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// newNotifier returns a notifier posting events to webhookURLs, signed with
+// secret when it isn't empty.
+func newNotifier(webhookURLs []string, secret string) *notifier {
+	n := &notifier{
+		subscribers: make(map[chan CommitEvent]string),
+		webhooks:    make(map[string]chan CommitEvent),
+		secret:      []byte(secret),
+		client:      &http.Client{Timeout: 10 * time.Second},
+		maxAttempts: 6,
+		backoff:     time.Second,
+	}
+	for _, url := range webhookURLs {
+		queue := make(chan CommitEvent, 100)
+		n.webhooks[url] = queue
+		go n.deliverAll(url, queue)
+	}
+	return n
+}
+
+// Subscribe returns a channel receiving every event for the repository
+// called repo published from now on.  Events are dropped for subscribers that
+// fall too far behind.
+func (n *notifier) Subscribe(repo string) chan CommitEvent {
+	c := make(chan CommitEvent, 16)
+	n.mutex.Lock()
+	n.subscribers[c] = repo
+	n.mutex.Unlock()
+	return c
+}
+
+func (n *notifier) Unsubscribe(c chan CommitEvent) {
+	n.mutex.Lock()
+	delete(n.subscribers, c)
+	n.mutex.Unlock()
+}
+
+// Publish hands event to every webhook and subscriber without waiting for
+// any of them.
+func (n *notifier) Publish(event CommitEvent) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for url, queue := range n.webhooks {
+		select {
+		case queue <- event:
+		default:
+			slog.Warn("Webhook queue is full, dropping event", "url", url, "event", event.Id)
+		}
+	}
+	for c, repo := range n.subscribers {
+		if repo != event.Repo {
+			continue
+		}
+		select {
+		case c <- event:
+		default:
+		}
+	}
+}
+
+// sign returns the value of the X-Gitrest-Signature header for body.
+func (n *notifier) sign(body []byte) string {
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverAll posts the events on queue to url one at a time, so that a
+// receiver sees them in commit order.
+func (n *notifier) deliverAll(url string, queue chan CommitEvent) {
+	for event := range queue {
+		if err := n.deliver(url, event); err != nil {
+			slog.Error("Giving up on delivering event", "url", url, "event", event.Id, "error", err)
+		}
+	}
+}
+
+// deliver posts event to url, retrying with exponential backoff until the
+// receiver answers with a 2xx status.
+func (n *notifier) deliver(url string, event CommitEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	backoff := n.backoff
+	for attempt := 1; ; attempt++ {
+		err = n.post(url, event, body)
+		if err == nil || attempt == n.maxAttempts {
+			return err
+		}
+		slog.Warn("Webhook delivery failed, retrying", "url", url, "event", event.Id, "attempt", attempt, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (n *notifier) post(url string, event CommitEvent, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gitrest-Event", event.Type)
+	req.Header.Set("X-Gitrest-Delivery", event.Id)
+	if len(n.secret) > 0 {
+		req.Header.Set("X-Gitrest-Signature", n.sign(body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// publishCommit announces a commit just made to branch.
+func (s *server) publishCommit(branch, commitId string) {
+	commit, err := s.store.LookupCommit(commitId)
+	if err != nil {
+		slog.Error("Can't publish commit", "commit", commitId, "error", err)
+		return
+	}
+	guid, err := util.NewGuid()
+	if err != nil {
+		slog.Error("Can't publish commit", "commit", commitId, "error", err)
+		return
+	}
+
+	metrics.countCommit()
+	s.notifications.Publish(CommitEvent{
+		Id:      guid.String(),
+		Type:    "commit",
+		Repo:    s.name,
+		Branch:  branch,
+		Files:   commit.Files,
+		Commit:  commit.Id,
+		Author:  commit.Author,
+		Email:   commit.Email,
+		Message: commit.Message,
+		Time:    commit.Time,
+	})
+}
+
+// eventsHandler streams commit events as server-sent events.
+func (s *server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	// The stream outlives the server's write timeout.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	events := s.notifications.Subscribe(s.name)
+	defer s.notifications.Unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event := <-events:
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
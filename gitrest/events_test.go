@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	received := make(chan CommitEvent, 1)
+	attempts := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts += 1
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		n := &notifier{secret: []byte("secret")}
+		if r.Header.Get("X-Gitrest-Signature") != n.sign(body) {
+			t.Errorf("Bad signature %s", r.Header.Get("X-Gitrest-Signature"))
+		}
+		var event CommitEvent
+		json.Unmarshal(body, &event)
+		received <- event
+	}))
+	defer receiver.Close()
+
+	n := newNotifier(nil, "secret")
+	n.backoff = time.Millisecond
+	if err := n.deliver(receiver.URL, CommitEvent{Id: "1", Type: "commit", Commit: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	event := <-received
+	if event.Commit != "abc" || attempts != 3 {
+		t.Errorf("Got %+v after %d attempts", event, attempts)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	n := newNotifier(nil, "")
+	n.backoff = time.Millisecond
+	n.maxAttempts = 2
+	if err := n.deliver(receiver.URL, CommitEvent{Id: "1"}); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+func TestEventsStream(t *testing.T) {
+	srv := newServer(newMemStore("master"))
+	server := httptest.NewServer(http.HandlerFunc(srv.eventsHandler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Content-Type = %s", resp.Header.Get("Content-Type"))
+	}
+
+	srv.notifications.Publish(CommitEvent{Id: "42", Type: "commit", Files: []string{"pets.yaml"}})
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	if lines[0] != "id: 42" || lines[1] != "event: commit" || !strings.Contains(lines[2], `"files":["pets.yaml"]`) {
+		t.Errorf("Unexpected event %q", lines)
+	}
+}
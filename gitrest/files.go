@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+type MoveRequest struct {
+	To string `json:"to"`
+}
+
+// deleteSpecFileHandler deletes a spec file from the branch with a commit.  A
+// saved copy of it is dropped as well.  Deletions are exempt from the
+// compatibility gate, as breakingChanges explains.
+func (s *server) deleteSpecFileHandler(w http.ResponseWriter, r *http.Request) error {
+	fileName := mux.Vars(r)["filename"]
+	branch, err := s.requestBranch(r)
+	if err != nil {
+		return err
+	}
+	if _, err = s.store.ReadAt("refs/heads/"+branch, fileName); err != nil {
+		return withStatus(http.StatusNotFound, fmt.Errorf("%s isn't committed on %s", fileName, branch))
+	}
+
+	message := r.Header.Get("Commit-Message")
+	if len(message) == 0 {
+		message = "Delete " + fileName
+	}
+	commitId, err := s.store.Commit(branch, requestIdentity(r), message, map[string][]byte{fileName: nil})
+	if err != nil {
+		return withStatus(http.StatusInternalServerError, err)
+	}
+	requestLogger(r).Info("Deleted", "file", fileName, "branch", branch, "commit", commitId)
+	s.publishCommit(branch, commitId)
+	writeJSON(w, http.StatusOK, CommitResponse{Status: Success, Message: "Deleted " + fileName + " from " + branch + ".", Commit: commitId})
+	return nil
+}
+
+// moveSpecFileHandler renames a spec file on the branch with a commit, so
+// that its history follows it.  A saved copy of it moves along, still
+// uncommitted.  The content doesn't change, so there's nothing for the
+// compatibility gate to check.
+func (s *server) moveSpecFileHandler(w http.ResponseWriter, r *http.Request) error {
+	fileName := mux.Vars(r)["filename"]
+	var req MoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.To) == 0 {
+		return withStatus(http.StatusBadRequest, errors.New("the new name of the file is required"))
+	}
+	if err := checkFileName(req.To); err != nil {
+		return withStatus(http.StatusBadRequest, err)
+	}
+	if req.To == fileName {
+		return withStatus(http.StatusBadRequest, fmt.Errorf("%s is already called that", fileName))
+	}
+	if s.userRole(r, req.To) < RoleEditor {
+		return withStatus(http.StatusForbidden, fmt.Errorf("%s needs the %s role for %s", authenticatedUser(r), RoleEditor, req.To))
+	}
+
+	branch, err := s.requestBranch(r)
+	if err != nil {
+		return err
+	}
+	content, err := s.store.ReadAt("refs/heads/"+branch, fileName)
+	if err != nil {
+		return withStatus(http.StatusNotFound, fmt.Errorf("%s isn't committed on %s", fileName, branch))
+	}
+	if _, err = s.store.Read(branch, req.To); err == nil {
+		return withStatus(http.StatusConflict, fmt.Errorf("%s already exists on %s", req.To, branch))
+	}
+	saved, err := s.store.ReadSaved(branch, fileName)
+	if err != nil {
+		saved = nil
+	}
+
+	message := r.Header.Get("Commit-Message")
+	if len(message) == 0 {
+		message = fmt.Sprintf("Rename %s to %s", fileName, req.To)
+	}
+	commitId, err := s.store.Commit(branch, requestIdentity(r), message, map[string][]byte{fileName: nil, req.To: content})
+	if err != nil {
+		return withStatus(http.StatusInternalServerError, err)
+	}
+	logger := requestLogger(r)
+	logger.Info("Renamed", "file", fileName, "to", req.To, "branch", branch, "commit", commitId)
+	if saved != nil {
+		if err = s.store.Write(branch, req.To, saved); err != nil {
+			logger.Error("Can't move saved copy", "file", fileName, "to", req.To, "branch", branch, "error", err)
+		}
+	}
+	s.publishCommit(branch, commitId)
+	writeJSON(w, http.StatusOK, CommitResponse{Status: Success, Message: "Renamed " + fileName + " to " + req.To + " on " + branch + ".", Commit: commitId})
+	return nil
+}
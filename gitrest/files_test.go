@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDeleteAndMove(t *testing.T) {
+	store := newMemStore("master")
+	srv := newServer(store)
+	jdoe := Identity{Name: "jdoe", Email: "jdoe@example.com"}
+	store.Commit("master", jdoe, "Add accounts.yaml", map[string][]byte{"accounts.yaml": []byte(accountsSpec)})
+	store.Commit("master", jdoe, "Add owners.yaml", map[string][]byte{"owners.yaml": []byte(accountsSpec + "\n")})
+	store.Write("master", "accounts.yaml", []byte(accountsSpec+"# draft\n"))
+
+	request := routed(srv.routes)
+
+	expectError(t, "no new name", request("POST", "/move/accounts.yaml", "jdoe", nil, strings.NewReader(`{}`)), http.StatusBadRequest)
+	expectError(t, "hidden new name", request("POST", "/move/accounts.yaml", "jdoe", nil, strings.NewReader(`{"to": ".git/config"}`)), http.StatusBadRequest)
+	expectError(t, "existing new name", request("POST", "/move/accounts.yaml", "jdoe", nil, strings.NewReader(`{"to": "owners.yaml"}`)), http.StatusConflict)
+	expectError(t, "moving a missing file", request("POST", "/move/pets.yaml", "jdoe", nil, strings.NewReader(`{"to": "v2/pets.yaml"}`)), http.StatusNotFound)
+
+	if w := request("POST", "/move/accounts.yaml", "jdoe", nil, strings.NewReader(`{"to": "v2/accounts.yaml"}`)); w.Code != http.StatusOK {
+		t.Fatalf("Move got %d %s", w.Code, w.Body.String())
+	}
+	if _, err := store.Read("master", "accounts.yaml"); err == nil {
+		t.Error("accounts.yaml is still there")
+	}
+	if content, err := store.ReadAt("master", "v2/accounts.yaml"); err != nil || string(content) != accountsSpec {
+		t.Errorf("Committed %q, %v", content, err)
+	}
+	if content, err := store.ReadSaved("master", "v2/accounts.yaml"); err != nil || !strings.HasSuffix(string(content), "# draft\n") {
+		t.Errorf("The saved copy didn't move along: %q, %v", content, err)
+	}
+
+	var entries []LogEntry
+	json.Unmarshal(request("GET", "/history/v2/accounts.yaml", "jdoe", nil, nil).Body.Bytes(), &entries)
+	if len(entries) != 2 || entries[0].Message != "Rename accounts.yaml to v2/accounts.yaml" ||
+		entries[0].FileName != "v2/accounts.yaml" || entries[1].FileName != "accounts.yaml" {
+		t.Errorf("History %+v", entries)
+	}
+
+	if w := request("DELETE", "/specfiles/owners.yaml", "jdoe", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("Delete got %d %s", w.Code, w.Body.String())
+	}
+	if files, _ := store.List("master"); len(files) != 1 || files[0] != "v2/accounts.yaml" {
+		t.Errorf("Left %v", files)
+	}
+	expectError(t, "deleting a deleted file", request("DELETE", "/specfiles/owners.yaml", "jdoe", nil, nil), http.StatusNotFound)
+}
@@ -4,23 +4,17 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"github.com/ghodss/yaml"
 	"github.com/gorilla/mux"
-	"github.com/libgit2/git2go"
-	auth "github.com/vsheffer/go-http-auth"
-	"io/ioutil"
-	"log"
+	"github.com/vsheffer/gofun/util"
+	"log/slog"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
 
-var repo *git.Repository
-var sig *git.Signature
-var repoDir string
-var staticDir string
-var corsAllowedHost string
-
 const (
 	Success string = "success"
 	Error   string = "error"
@@ -34,6 +28,7 @@ type LogEntry struct {
 	CommitterUsername string    `json:"committer"`
 	CommittedTime     time.Time `json:"commitedTime"`
 	Message           string    `json:"message"`
+	FileName          string    `json:"fileName"`
 }
 
 type FileListResponse struct {
@@ -45,163 +40,190 @@ type Response struct {
 	Message string `json:"message"`
 }
 
-func saveSpecFileHandler(w http.ResponseWriter, r *http.Request) {
-	fileBytes, err := ioutil.ReadAll(r.Body)
+func (s *server) saveSpecFileHandler(w http.ResponseWriter, r *http.Request) error {
+	logger := requestLogger(r)
+	fileName := mux.Vars(r)["filename"]
+	fileBytes, err := readSpecBody(r, fileName)
 	if err != nil {
-		log.Printf("Can't read bytes: %+v", err)
+		return err
+	}
+	if logBodies {
+		logger.Debug("Saving spec file", "file", fileName, "size", len(fileBytes), "body", string(fileBytes))
+	} else {
+		logger.Debug("Saving spec file", "file", fileName, "size", len(fileBytes))
 	}
 
-	log.Println("fileBytes = %s", string(fileBytes))
-
-	fileName := mux.Vars(r)["filename"]
-	ioutil.WriteFile(repoDir+fileName, fileBytes, 0644)
+	if !validateSpec(w, r, fileName, fileBytes) {
+		return nil
+	}
+	if fileBytes, err = s.storedSpec(fileName, fileBytes); err != nil {
+		return err
+	}
+	branch, err := s.requestBranch(r)
+	if err != nil {
+		return err
+	}
+	if err = s.store.Write(branch, fileName, fileBytes); err != nil {
+		return withStatus(http.StatusInternalServerError, err)
+	}
 	json.NewEncoder(w).Encode(Response{Status: Success, Message: "File " + fileName + " saved."})
+	return nil
 }
 
-func getRepoDirListingHandler(w http.ResponseWriter, r *http.Request) {
-	// Return list of files.
-
-	fileList, _ := ioutil.ReadDir(repoDir)
-
-	// Get the number of files that will be returned...
-
-	numFilesToReturn := 0
-	for _, fileInfo := range fileList {
-		if strings.Index(fileInfo.Name(), ".") == 0 || fileInfo.IsDir() {
-			continue
-		}
-
-		numFilesToReturn += 1
+func (s *server) getRepoDirListingHandler(w http.ResponseWriter, r *http.Request) error {
+	branch, err := s.requestBranch(r)
+	if err != nil {
+		return err
 	}
-
-	fileListResponse := FileListResponse{FileList: make([]string, numFilesToReturn)}
-	listIndex := 0
-	for _, fileInfo := range fileList {
-		if strings.Index(fileInfo.Name(), ".") == 0 || fileInfo.IsDir() {
-			continue
+	fileNames, err := s.store.List(branch)
+	if err != nil {
+		return withStatus(http.StatusInternalServerError, err)
+	}
+	fileList := make([]string, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		if s.userRole(r, fileName) >= RoleReader {
+			fileList = append(fileList, fileName)
 		}
-		fileListResponse.FileList[listIndex] = fileInfo.Name()
-		listIndex += 1
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(fileListResponse)
+	json.NewEncoder(w).Encode(FileListResponse{FileList: fileList})
+	return nil
 }
 
-func getSpecFileHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) getSpecFileHandler(w http.ResponseWriter, r *http.Request) error {
+	logger := requestLogger(r)
 	fileName := mux.Vars(r)["filename"]
-	log.Printf("fileName = %s", fileName)
-	bytes, err := ioutil.ReadFile(repoDir + fileName)
+	branch, err := s.requestBranch(r)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-	} else {
-		acceptHeader := r.Header.Get("Accept")
-		log.Printf("acceptsHeader = %s, %d", acceptHeader, strings.Index(acceptHeader, "application/json"))
-		w.Header().Set("Content-Type", "application/yaml")
-		if strings.Index(acceptHeader, "yaml") < 0 {
-			bytes, _ = yaml.YAMLToJSON(bytes)
-			w.Header().Set("Content-Type", "application/json")
-		}
-		w.Header().Set("Access-Control-Allow-Origin", corsAllowedHost)
-		w.Header().Set("Access-Control-Allow-Methods", "GET")
-		w.Write(bytes)
+		return err
 	}
-}
-
-func commitFileHandler(w http.ResponseWriter, r *http.Request) {
-	commitMessage := r.Header.Get("Commit-Message")
-	committer := r.Header.Get("Committer")
-
-	index, err := repo.Index()
+	bytes, err := s.store.Read(branch, fileName)
 	if err != nil {
-		log.Printf("Can't open index %+v", err)
-		return
+		return withStatus(http.StatusNotFound, err)
 	}
 
-	log.Printf("index = %+v", index)
+	logger.Debug("Reading spec file", "file", fileName, "branch", branch, "accept", r.Header.Get("Accept"))
+	return writeSpec(w, r, fileName, bytes)
+}
 
+func (s *server) commitFileHandler(w http.ResponseWriter, r *http.Request) error {
+	commitMessage := r.Header.Get("Commit-Message")
 	fileName := mux.Vars(r)["filename"]
-	err = index.AddByPath(fileName)
-	if err != nil {
-		log.Printf("Can't AddByPath %+v", err)
-	}
 
-	treeId, err := index.WriteTree()
+	branch, err := s.requestBranch(r)
 	if err != nil {
-		log.Printf("WriteTree error: %+v", err)
+		return err
 	}
-	tree, err := repo.LookupTree(treeId)
+	fileBytes, err := s.store.ReadSaved(branch, fileName)
 	if err != nil {
-		log.Printf("LookupTree error: %+v", err)
-	}
-
-	var commitErr error
-	currentBranch, err := repo.Head()
-	log.Printf("currentBranch = %+v", currentBranch)
-	sig.Name = committer
-	sig.When = time.Now()
-	sig.Email = committer
-	if currentBranch != nil {
-		currentTip, _ := repo.LookupCommit(currentBranch.Target())
-		_, commitErr = repo.CreateCommit("HEAD", sig, sig, commitMessage, tree, currentTip)
-	} else {
-		_, commitErr = repo.CreateCommit("HEAD", sig, sig, commitMessage, tree)
+		return withStatus(http.StatusNotFound, fmt.Errorf("%s hasn't been saved on %s", fileName, branch))
+	}
+	if !validateSpec(w, r, fileName, fileBytes) {
+		return nil
+	}
+	if !s.checkCompat(w, branch, map[string][]byte{fileName: fileBytes}) {
+		return nil
 	}
 
-	if commitErr != nil {
-		log.Printf("Commit error: %+v", commitErr)
+	commitId, err := s.store.Commit(branch, requestIdentity(r), commitMessage, map[string][]byte{fileName: fileBytes})
+	if err != nil {
+		return withStatus(http.StatusInternalServerError, err)
 	}
-	index.Write()
+	requestLogger(r).Info("Committed", "file", fileName, "branch", branch, "commit", commitId)
+	s.publishCommit(branch, commitId)
 	fmt.Fprintf(w, "Filename = %s", fileName)
+	return nil
 }
 
-func historyHandler(w http.ResponseWriter, r *http.Request) {
+// historyHandler returns the commits that changed a spec on the branch, most
+// recent first.  ?limit= caps how many, 5 by default.
+func (s *server) historyHandler(w http.ResponseWriter, r *http.Request) error {
 	fileName := mux.Vars(r)["filename"]
-	log.Printf("filename = %s", fileName)
-	revspec, err := repo.Revparse("HEAD^{tree}")
+	limit := 5
+	if param := r.URL.Query().Get("limit"); len(param) > 0 {
+		n, err := strconv.Atoi(param)
+		if err != nil || n < 1 {
+			return withStatus(http.StatusBadRequest, fmt.Errorf("invalid limit %q", param))
+		}
+		limit = n
+	}
+	branch, err := s.requestBranch(r)
 	if err != nil {
-		log.Printf("revparse err = %+v", err)
+		return err
 	}
-	head := revspec.From().Id()
-	log.Printf("head = %+v", head)
-	tree, err := repo.LookupTree(head)
+	commits, err := s.store.History(branch, fileName, limit)
 	if err != nil {
-		log.Printf("err = %+v", err)
-	}
-	entry := tree.EntryByName(fileName)
-	log.Printf("entry = %+v", entry)
-	walk, _ := repo.Walk()
-	walk.Push(entry.Id)
-	historyResponse := make([]LogEntry, 5)
-	numWalked := 0
-	walk.Iterate(func(commit *git.Commit) bool {
-		if numWalked > len(historyResponse) {
-			return false
-		}
-		log.Printf("oid = %+v", commit.Id())
-		historyResponse[numWalked] = LogEntry{
-			CommitterUsername: commit.Committer().Name,
-			CommittedTime:     commit.Committer().When,
-			Message:           commit.Message(),
-		}
-
-		numWalked += 1
-		return false
-	})
+		return err
+	}
 
+	historyResponse := make([]LogEntry, 0, len(commits))
+	for _, commit := range commits {
+		historyResponse = append(historyResponse, LogEntry{
+			CommitterUsername: commit.Committer,
+			CommittedTime:     commit.Time,
+			Message:           commit.Message,
+			FileName:          commit.Path,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(historyResponse)
+	return nil
 }
 
 func main() {
+	var repoDir, reposDir, defaultRepo, staticDir string
 	var passwordFile string
-
-	flag.StringVar(&repoDir, "repo-dir", "", "The directory where the Git repository will be saved.")
+	var usersFile string
+	var authzFile string
+	var corsAllowedOrigins util.StringSlice
+	var level string
+	var tokensFile, jwtPublicKey, jwtIssuer, jwtAudience string
+	var compatGateBranches util.StringSlice
+	var canonicalFormat string
+	var webhookURLs util.StringSlice
+	var webhookSecret string
+	var remoteURL, remoteName, remoteUsername, remotePassword string
+	var syncInterval time.Duration
+
+	flag.StringVar(&repoDir, "repo-dir", "", "The directory where the default Git repository will be saved.  It defaults to a directory named after -default-repo in -repos-dir.")
+	flag.StringVar(&reposDir, "repos-dir", "", "The directory holding the repositories served under /repos/, where new ones are created.  Only the default repository is served without it.")
+	flag.StringVar(&defaultRepo, "default-repo", "default", "The name of the default repository, which is also served without the /repos/{repo} prefix.")
 	flag.StringVar(&staticDir, "static-dir", "", "The directory containing static content to be served.")
-	flag.StringVar(&corsAllowedHost, "cors-allowed-origin", "*", "The hostname of the allowed origin for cors support.  All hosts are allowed by default.")
+	flag.Var(&corsAllowedOrigins, "cors-allowed-origin", "An origin allowed to make cross-origin requests, or a comma separated list of them.  May be repeated.  All origins are allowed by default, but without credentials.")
 	flag.StringVar(&passwordFile, "password-file", "htpasswd", "The path to the password file that should match the format of htpasswd.")
+	flag.StringVar(&canonicalFormat, "canonical-format", "", "Store saved spec files as json or yaml, with sorted keys and two space indentation.  They are stored as written by default.")
+	flag.Var(&compatGateBranches, "compat-gate", "A branch on which commits that break clients of a spec are rejected.  May be repeated.")
+	flag.StringVar(&tokensFile, "tokens-file", "", "The path to the file API tokens are kept in.  Token authentication is off if it is empty.")
+	flag.StringVar(&jwtPublicKey, "jwt-public-key", "", "The path to a PEM encoded public key.  Bearer JWTs signed with it are accepted when it is given.")
+	flag.StringVar(&jwtIssuer, "jwt-issuer", "", "The iss claim JWTs must have.  Any issuer is accepted if it is empty.")
+	flag.StringVar(&jwtAudience, "jwt-audience", "", "The audience JWTs must name in their aud claim.  Any audience is accepted if it is empty.")
+	flag.StringVar(&authzFile, "authz-file", "", "The path to a YAML file granting roles to users and groups.  Every user is an admin without one.")
+	flag.StringVar(&usersFile, "users-file", "", "The path to a YAML file mapping user names to the name and email used in commits.")
+	flag.Var(&webhookURLs, "webhook-url", "A URL that is posted an event for every commit.  May be repeated.")
+	flag.StringVar(&webhookSecret, "webhook-secret", "", "The key webhook deliveries are signed with, using HMAC-SHA256.")
+	flag.StringVar(&remoteURL, "remote-url", "", "The URL of an upstream repository to keep in sync with.  Nothing is synced by default.")
+	flag.StringVar(&remoteName, "remote-name", "origin", "The name of the upstream remote.")
+	flag.StringVar(&remoteUsername, "remote-username", "", "The user name for the upstream repository.  An ssh agent is used when it is empty.")
+	flag.StringVar(&remotePassword, "remote-password", os.Getenv("GITREST_REMOTE_PASSWORD"), "The password or token for the upstream repository.")
+	flag.DurationVar(&syncInterval, "sync-interval", 5*time.Minute, "How often to pull from the upstream repository.")
+	flag.StringVar(&level, "log-level", "info", "The lowest level logged: debug, info, warn or error.")
+	flag.BoolVar(&logBodies, "log-bodies", false, "Log the spec files clients save at the debug level.")
 	flag.Parse()
+	if err := setupLogging(os.Stderr, level); err != nil {
+		fatal("Bad -log-level", "error", err)
+	}
+	if len(repoDir) == 0 && len(reposDir) > 0 {
+		repoDir = filepath.Join(reposDir, defaultRepo)
+	}
 	if len(repoDir) == 0 {
-		log.Fatalf("repo-dir is required.")
+		fatal("repo-dir or repos-dir is required.")
+	}
+	if !validRepoName.MatchString(defaultRepo) {
+		fatal("Invalid default-repo", "name", defaultRepo)
+	}
+	if canonicalFormat != "" && canonicalFormat != formatJSON && canonicalFormat != formatYAML {
+		fatal("canonical-format must be json or yaml", "format", canonicalFormat)
 	}
 
 	if len(staticDir) == 0 {
@@ -211,42 +233,88 @@ func main() {
 	if !strings.HasSuffix(repoDir, "/") {
 		repoDir = fmt.Sprintf("%s%s", repoDir, "/")
 	}
-	log.Printf("repoDir = %s, staticDir = %s", repoDir, staticDir)
+	slog.Info("Starting gitrest", "repoDir", repoDir, "reposDir", reposDir, "staticDir", staticDir)
 	var err error
-	repo, err = git.InitRepository(repoDir, false)
-	if err != nil {
-		log.Fatalf("Can't initialize repository %+v", err)
-		return
+	if len(usersFile) > 0 {
+		if identities, err = loadIdentities(usersFile); err != nil {
+			fatal("Can't load users file", "path", usersFile, "error", err)
+		}
+	}
+
+	if len(tokensFile) > 0 {
+		if tokens, err = newTokenStore(tokensFile); err != nil {
+			fatal("Can't load tokens", "path", tokensFile, "error", err)
+		}
+	}
+	if len(jwtPublicKey) > 0 {
+		if jwtKey, err = loadJWTVerifier(jwtPublicKey); err != nil {
+			fatal("Can't load JWT public key", "path", jwtPublicKey, "error", err)
+		}
+		jwtKey.issuer, jwtKey.audience = jwtIssuer, jwtAudience
 	}
-	repo.Head()
-	repo.DefaultSignature()
-	sig, _ = repo.DefaultSignature()
-	log.Printf("sig = %+v", sig)
-	sig.Name = "vince.sheffer"
-	sig.Email = "vince.sheffer@bhnetwork.com"
 
-	log.Printf("repos = %+v", repo)
+	repos := newRepoSet(reposDir, defaultRepo)
+	if len(authzFile) > 0 {
+		if repos.authz, err = newAuthorizer(authzFile); err != nil {
+			fatal("Can't load authorization policy", "path", authzFile, "error", err)
+		}
+		go repos.authz.watch(10 * time.Second)
+	}
+	repos.notifications = newNotifier(webhookURLs.Get(), webhookSecret)
+	repos.compatGate = compatGateBranches.Get()
+	repos.canonicalFormat = canonicalFormat
 
-	r := mux.NewRouter().StrictSlash(false)
+	store, err := openGitStore(repoDir)
+	if err != nil {
+		fatal("Can't initialize repository", "error", err)
+	}
+	srv := repos.add(defaultRepo, store)
+	if err = repos.open(); err != nil {
+		fatal("Can't open repositories", "dir", reposDir, "error", err)
+	}
+	metrics.repoDirs = []string{repoDir}
+	if len(reposDir) > 0 {
+		if rel, err := filepath.Rel(reposDir, repoDir); err == nil && !strings.HasPrefix(rel, "..") {
+			metrics.repoDirs = []string{reposDir}
+		} else {
+			metrics.repoDirs = append(metrics.repoDirs, reposDir)
+		}
+	}
 
-	secrets := auth.HtpasswdFileProvider(passwordFile)
-	authenticator := auth.NewBasicAuthenticator("gitrest", secrets)
+	if len(remoteURL) > 0 {
+		srv.remote = newSyncer(srv, remoteName, remoteURL, remoteUsername, remotePassword)
+		if err = srv.remote.setup(); err != nil {
+			fatal("Can't set up remote", "remote", remoteName, "error", err)
+		}
+		if err = srv.remote.Sync(); err != nil {
+			slog.Warn("Initial sync failed", "url", remoteURL, "error", err)
+		}
+		go srv.remote.Run(syncInterval)
+	}
 
-	r.HandleFunc("/specfiles", getRepoDirListingHandler).Methods("GET")
-	r.HandleFunc("/specfiles/{filename}", getSpecFileHandler).Methods("GET")
-	r.HandleFunc("/specfiles/{filename}", saveSpecFileHandler).Methods("PUT")
-	r.HandleFunc("/commitfile/{filename}", commitFileHandler).Methods("POST")
-	r.HandleFunc("/history/{filename}", historyHandler).Methods("GET")
+	r := mux.NewRouter().StrictSlash(false)
+	r.Use(recordRoute)
+	repos.routes(r)
+	if tokens != nil {
+		r.HandleFunc("/tokens", repos.requireRole(RoleAdmin, getTokensHandler)).Methods("GET")
+		r.HandleFunc("/tokens", repos.requireRole(RoleAdmin, repos.createTokenHandler)).Methods("POST")
+		r.HandleFunc("/tokens/{id}", repos.requireRole(RoleAdmin, deleteTokenHandler)).Methods("DELETE")
+	}
+	// The routes of the default repository are also served without a prefix.
+	srv.routes(r)
 	r.PathPrefix("/").Handler(http.FileServer(http.Dir(staticDir)))
-	http.Handle("/", authenticator.Wrap(func(w http.ResponseWriter, ar *auth.AuthenticatedRequest) {
-		w.Header().Add("X-Basic-Auth-Username", ar.Username)
-		r.ServeHTTP(w, &ar.Request)
-	}))
+
+	authenticator := newAuthenticator(passwordFile)
+	// Probes and scrapers don't authenticate, and aren't logged.
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", srv.readyzHandler)
+	http.HandleFunc("/metrics", metricsHandler)
+	http.Handle("/", accessLog(newCORSPolicy(corsAllowedOrigins.Get()).Wrap(authenticator.Wrap(r))))
 	s := &http.Server{
 		Addr:           ":8080",
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
 	}
-	log.Fatal(s.ListenAndServe())
+	fatal("Server stopped", "error", s.ListenAndServe())
 }
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/libgit2/git2go"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRepo returns a store for a fresh repository in a temporary
+// directory.
+func newTestRepo(t *testing.T) *gitStore {
+	store, err := openGitStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.repo.Free)
+	return store
+}
+
+// commitTo commits content as fileName to the checked out branch of r.
+func commitTo(t *testing.T, r *git.Repository, fileName, content string) *git.Oid {
+	head, err := r.References.Lookup("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refName := head.SymbolicTarget()
+
+	index, err := git.NewIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parents []*git.Commit
+	if ref, err := r.References.Lookup(refName); err == nil {
+		parent, err := r.LookupCommit(ref.Target())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, _ := parent.Tree()
+		index.ReadTree(tree)
+		parents = append(parents, parent)
+	}
+
+	blobId, err := r.CreateBlobFromBuffer([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Add(&git.IndexEntry{Path: fileName, Id: blobId, Mode: git.FilemodeBlob})
+	treeId, err := index.WriteTreeTo(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, _ := r.LookupTree(treeId)
+	s := &git.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	id, err := r.CreateCommit(refName, s, s, "Update "+fileName, tree, parents...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// serve sends a request for target through a router with the handler routed
+// at route, the way main sets it up.
+func serve(method, route, target string, body io.Reader, h errorHandler) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	r.HandleFunc(route, handle(h)).Methods(method)
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set(usernameHeader, "jdoe")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// expectError checks that w holds an error Response with status.
+func expectError(t *testing.T, name string, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	var resp Response
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != status || resp.Status != Error || len(resp.Message) == 0 {
+		t.Errorf("%s: got %d %s, want %d", name, w.Code, w.Body.String(), status)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestSaveSpecFileErrors(t *testing.T) {
+	store := newTestRepo(t)
+	srv := newServer(store)
+	route := "/specfiles/{filename:.+}"
+
+	w := serve("PUT", route, "/specfiles/accounts.yaml", failingReader{}, srv.saveSpecFileHandler)
+	expectError(t, "unreadable body", w, http.StatusBadRequest)
+
+	w = serve("PUT", route, "/specfiles/accounts.yaml?branch=nope", strings.NewReader(accountsSpec), srv.saveSpecFileHandler)
+	expectError(t, "unknown branch", w, http.StatusNotFound)
+
+	os.MkdirAll(store.dir+"taken.yaml", 0755)
+	w = serve("PUT", route, "/specfiles/taken.yaml", strings.NewReader(accountsSpec), srv.saveSpecFileHandler)
+	expectError(t, "unwritable file", w, http.StatusInternalServerError)
+
+	w = serve("PUT", route, "/specfiles/accounts.yaml", strings.NewReader(accountsSpec), srv.saveSpecFileHandler)
+	if w.Code != http.StatusOK {
+		t.Errorf("Save failed: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCommitFileErrors(t *testing.T) {
+	store := newTestRepo(t)
+	srv := newServer(store)
+	route := "/commitfile/{filename:.+}"
+
+	w := serve("POST", route, "/commitfile/accounts.yaml", nil, srv.commitFileHandler)
+	expectError(t, "unsaved file", w, http.StatusNotFound)
+
+	w = serve("POST", route, "/commitfile/accounts.yaml?branch=nope", nil, srv.commitFileHandler)
+	expectError(t, "unknown branch", w, http.StatusNotFound)
+
+	os.WriteFile(store.dir+"accounts.yaml", []byte(accountsSpec), 0644)
+	w = serve("POST", route, "/commitfile/accounts.yaml", nil, srv.commitFileHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Commit failed: %d %s", w.Code, w.Body.String())
+	}
+	branch, _ := store.HeadBranch()
+	if content, err := store.ReadAt("refs/heads/"+branch, "accounts.yaml"); err != nil || string(content) != accountsSpec {
+		t.Errorf("Committed %q, %v", content, err)
+	}
+}
+
+func TestCommitFileErrorsMemStore(t *testing.T) {
+	store := newMemStore("master")
+	srv := newServer(store)
+	route := "/commitfile/{filename:.+}"
+
+	w := serve("POST", route, "/commitfile/accounts.yaml", nil, srv.commitFileHandler)
+	expectError(t, "unsaved file", w, http.StatusNotFound)
+
+	w = serve("POST", route, "/commitfile/accounts.yaml?branch=nope", nil, srv.commitFileHandler)
+	expectError(t, "unknown branch", w, http.StatusNotFound)
+
+	store.Write("master", "accounts.yaml", []byte(accountsSpec))
+	w = serve("POST", route, "/commitfile/accounts.yaml", nil, srv.commitFileHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Commit failed: %d %s", w.Code, w.Body.String())
+	}
+	if content, err := store.ReadAt("master", "accounts.yaml"); err != nil || string(content) != accountsSpec {
+		t.Errorf("Committed %q, %v", content, err)
+	}
+}
+
+func TestHistoryErrors(t *testing.T) {
+	store := newTestRepo(t)
+	srv := newServer(store)
+	route := "/history/{filename:.+}"
+
+	w := serve("GET", route, "/history/accounts.yaml", nil, srv.historyHandler)
+	expectError(t, "empty repository", w, http.StatusNotFound)
+
+	commitTo(t, store.repo, "accounts.yaml", "v1")
+	commitTo(t, store.repo, "pets.yaml", "v1")
+	commitTo(t, store.repo, "accounts.yaml", "v2")
+
+	w = serve("GET", route, "/history/accounts.yaml?branch=nope", nil, srv.historyHandler)
+	expectError(t, "unknown branch", w, http.StatusNotFound)
+
+	w = serve("GET", route, "/history/owners.yaml", nil, srv.historyHandler)
+	expectError(t, "missing file", w, http.StatusNotFound)
+
+	w = serve("GET", route, "/history/accounts.yaml?limit=none", nil, srv.historyHandler)
+	expectError(t, "bad limit", w, http.StatusBadRequest)
+
+	w = serve("GET", route, "/history/accounts.yaml", nil, srv.historyHandler)
+	var entries []LogEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Got %d %s", w.Code, w.Body.String())
+	}
+	if len(entries) != 2 || entries[0].Message != "Update accounts.yaml" {
+		t.Errorf("Got %+v", entries)
+	}
+}
+
+func TestHistoryErrorsMemStore(t *testing.T) {
+	store := newMemStore("master")
+	srv := newServer(store)
+	route := "/history/{filename:.+}"
+
+	w := serve("GET", route, "/history/accounts.yaml", nil, srv.historyHandler)
+	expectError(t, "empty repository", w, http.StatusNotFound)
+
+	jdoe := Identity{Name: "jdoe", Email: "jdoe@example.com"}
+	store.Commit("master", jdoe, "Add accounts.yaml", map[string][]byte{"accounts.yaml": []byte("v1")})
+	store.Commit("master", jdoe, "Add pets.yaml", map[string][]byte{"pets.yaml": []byte("v1")})
+	store.Commit("master", jdoe, "Update accounts.yaml", map[string][]byte{"accounts.yaml": []byte("v2")})
+
+	w = serve("GET", route, "/history/accounts.yaml?branch=nope", nil, srv.historyHandler)
+	expectError(t, "unknown branch", w, http.StatusNotFound)
+
+	w = serve("GET", route, "/history/owners.yaml", nil, srv.historyHandler)
+	expectError(t, "missing file", w, http.StatusNotFound)
+
+	w = serve("GET", route, "/history/accounts.yaml?limit=none", nil, srv.historyHandler)
+	expectError(t, "bad limit", w, http.StatusBadRequest)
+
+	w = serve("GET", route, "/history/accounts.yaml", nil, srv.historyHandler)
+	var entries []LogEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Got %d %s", w.Code, w.Body.String())
+	}
+	if len(entries) != 2 || entries[0].Message != "Update accounts.yaml" || entries[1].CommitterUsername != "jdoe" {
+		t.Errorf("Got %+v", entries)
+	}
+}
@@ -0,0 +1,685 @@
+package main
+
+import (
+	"errors"
+	"github.com/libgit2/git2go"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gitStore is a SpecStore backed by a Git repository with a working tree.
+// Files saved on the checked out branch live in the working tree, files saved
+// on other branches get a directory of their own inside .git.
+type gitStore struct {
+	repo *git.Repository
+	dir  string
+
+	// mutex serializes everything that moves a branch or a tag.
+	mutex sync.Mutex
+}
+
+// openGitStore opens the repository in dir, initializing it if there is
+// none yet.
+func openGitStore(dir string) (*gitStore, error) {
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	repo, err := git.InitRepository(dir, false)
+	if err != nil {
+		return nil, err
+	}
+	return &gitStore{repo: repo, dir: dir}, nil
+}
+
+// ready opens the repository afresh and resolves HEAD.  HEAD is looked up
+// symbolically, as HeadBranch does, so a branch without commits yet, as in a
+// fresh repository, is ready too.
+func (s *gitStore) ready() error {
+	r, err := git.OpenRepository(s.dir)
+	if err != nil {
+		return err
+	}
+	defer r.Free()
+	head, err := r.References.Lookup("HEAD")
+	if err != nil {
+		return err
+	}
+	defer head.Free()
+	resolved, err := head.Resolve()
+	if err != nil {
+		if git.IsErrorCode(err, git.ErrNotFound) || git.IsErrorCode(err, git.ErrUnbornBranch) {
+			return nil
+		}
+		return err
+	}
+	resolved.Free()
+	return nil
+}
+
+// signature returns a fresh signature for a commit by id.  Every commit gets
+// its own, so concurrent commits never share one.
+func signature(id Identity) *git.Signature {
+	return &git.Signature{Name: id.Name, Email: id.Email, When: time.Now()}
+}
+
+// HeadBranch returns the short name of the branch HEAD points at.  This works
+// for the unborn branch of a freshly initialized repository too.
+func (s *gitStore) HeadBranch() (string, error) {
+	head, err := s.repo.References.Lookup("HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(head.SymbolicTarget(), "refs/heads/"), nil
+}
+
+func (s *gitStore) HasBranch(branch string) (bool, error) {
+	head, err := s.HeadBranch()
+	if err != nil || branch == head {
+		return err == nil, err
+	}
+	if _, err := s.repo.LookupBranch(branch, git.BranchLocal); err != nil {
+		if git.IsErrorCode(err, git.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// branchWorkDir returns the directory holding saved but uncommitted files for
+// branch.
+func (s *gitStore) branchWorkDir(branch string) (string, error) {
+	head, err := s.HeadBranch()
+	if err != nil {
+		return "", err
+	}
+	if branch == head {
+		return s.dir, nil
+	}
+	return filepath.Join(s.repo.Path(), "gitrest", "worktrees", branch) + "/", nil
+}
+
+// branchTip returns the commit at the tip of branch, or nil if the branch
+// has no commits yet.
+func (s *gitStore) branchTip(branch string) (*git.Commit, error) {
+	b, err := s.repo.LookupBranch(branch, git.BranchLocal)
+	if err != nil {
+		if git.IsErrorCode(err, git.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return s.repo.LookupCommit(b.Target())
+}
+
+// resolveCommit finds the commit named by spec, which may be a branch, a tag
+// or a commit id.
+func (s *gitStore) resolveCommit(spec string) (*git.Commit, error) {
+	obj, err := s.repo.RevparseSingle(spec)
+	if err != nil {
+		return nil, err
+	}
+	peeled, err := obj.Peel(git.ObjectCommit)
+	if err != nil {
+		return nil, err
+	}
+	return peeled.AsCommit()
+}
+
+// readFileAtCommit returns the content of path as recorded in commit.
+func (s *gitStore) readFileAtCommit(commit *git.Commit, path string) ([]byte, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	return s.readFileAtTree(tree, path)
+}
+
+// readFileAtTree returns the content of path in tree.
+func (s *gitStore) readFileAtTree(tree *git.Tree, path string) ([]byte, error) {
+	entry, err := tree.EntryByPath(path)
+	if err != nil {
+		if git.IsErrorCode(err, git.ErrNotFound) {
+			return nil, notFound("%s doesn't exist", path)
+		}
+		return nil, err
+	}
+	blob, err := s.repo.LookupBlob(entry.Id)
+	if err != nil {
+		return nil, err
+	}
+	return blob.Contents(), nil
+}
+
+// changedFiles returns the content in newTree of the files that were added
+// or modified since oldTree.
+func (s *gitStore) changedFiles(oldTree, newTree *git.Tree) (map[string][]byte, error) {
+	changes, err := s.diffTrees(oldTree, newTree)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte)
+	for _, change := range changes {
+		if change.Status != FileDeleted {
+			files[change.Path] = change.Content
+		}
+	}
+	return files, nil
+}
+
+// diffTrees returns the files that differ between oldTree, which may be nil,
+// and newTree.
+func (s *gitStore) diffTrees(oldTree, newTree *git.Tree) ([]FileChange, error) {
+	diff, err := s.repo.DiffTreeToTree(oldTree, newTree, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer diff.Free()
+
+	numDeltas, err := diff.NumDeltas()
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]FileChange, 0, numDeltas)
+	for i := 0; i < numDeltas; i++ {
+		delta, err := diff.GetDelta(i)
+		if err != nil {
+			return nil, err
+		}
+		change := FileChange{Path: delta.NewFile.Path, Status: FileModified}
+		switch delta.Status {
+		case git.DeltaDeleted:
+			change.Path, change.Status = delta.OldFile.Path, FileDeleted
+		case git.DeltaAdded:
+			change.Status = FileAdded
+		}
+		if change.Status != FileDeleted {
+			if change.Content, err = s.readFileAtTree(newTree, change.Path); err != nil {
+				return nil, err
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// Read returns the saved copy of fileName on branch, falling back to the
+// committed one.
+func (s *gitStore) Read(branch, fileName string) ([]byte, error) {
+	dir, err := s.branchWorkDir(branch)
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := ioutil.ReadFile(dir + fileName)
+	if err == nil || dir == s.dir || !os.IsNotExist(err) {
+		return bytes, err
+	}
+	tip, err := s.branchTip(branch)
+	if err != nil {
+		return nil, err
+	}
+	if tip == nil {
+		return nil, notFound("%s doesn't exist on %s", fileName, branch)
+	}
+	return s.readFileAtCommit(tip, fileName)
+}
+
+// ReadSaved returns the copy of fileName in the working directory of branch,
+// which for the checked out branch includes committed files.
+func (s *gitStore) ReadSaved(branch, fileName string) ([]byte, error) {
+	dir, err := s.branchWorkDir(branch)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(dir + fileName)
+}
+
+func (s *gitStore) ReadAt(ref, fileName string) ([]byte, error) {
+	commit, err := s.resolveCommit(ref)
+	if err != nil {
+		return nil, err
+	}
+	return s.readFileAtCommit(commit, fileName)
+}
+
+func (s *gitStore) Write(branch, fileName string, content []byte) error {
+	dir, err := s.branchWorkDir(branch)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(dir+fileName), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dir+fileName, content, 0644)
+}
+
+// List returns the paths of the spec files on branch, both committed and
+// saved.  Hidden files and directories are left out.
+func (s *gitStore) List(branch string) ([]string, error) {
+	dir, err := s.branchWorkDir(branch)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	if dir != s.dir {
+		tip, err := s.branchTip(branch)
+		if err != nil {
+			return nil, err
+		}
+		if tip != nil {
+			tree, err := tip.Tree()
+			if err != nil {
+				return nil, err
+			}
+			err = tree.Walk(func(root string, entry *git.TreeEntry) int {
+				if strings.Index(entry.Name, ".") == 0 {
+					return 1
+				}
+				if entry.Type == git.ObjectBlob {
+					names[root+entry.Name] = true
+				}
+				return 0
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	filepath.Walk(dir, func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil || path == filepath.Clean(dir) {
+			return nil
+		}
+		if strings.Index(fileInfo.Name(), ".") == 0 {
+			if fileInfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fileInfo.IsDir() {
+			names[strings.TrimPrefix(path, dir)] = true
+		}
+		return nil
+	})
+
+	fileNames := make([]string, 0, len(names))
+	for name := range names {
+		fileNames = append(fileNames, name)
+	}
+	sort.Strings(fileNames)
+	return fileNames, nil
+}
+
+func (s *gitStore) Commit(branch string, author Identity, message string, files map[string][]byte) (string, error) {
+	return s.commitOn(branch, nil, author, message, files)
+}
+
+func (s *gitStore) CommitOn(branch, parent string, author Identity, message string, files map[string][]byte) (string, error) {
+	return s.commitOn(branch, &parent, author, message, files)
+}
+
+// commitOn commits files to branch, provided it's at expected when that
+// isn't nil, and brings the saved copies of them in line with the commit.
+func (s *gitStore) commitOn(branch string, expected *string, author Identity, message string, files map[string][]byte) (string, error) {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	commitId, err := s.commitChanges(branch, expected, signature(author), message, func(index *git.Index) error {
+		for _, path := range paths {
+			var err error
+			if files[path] == nil {
+				err = index.RemoveByPath(path)
+			} else {
+				err = s.addBlob(index, path, files[path])
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err = s.syncWorkDir(branch, paths...); err != nil {
+		slog.Error("Can't clean up after commit", "branch", branch, "error", err)
+	}
+	if dir, err := s.branchWorkDir(branch); err == nil {
+		for _, path := range paths {
+			if files[path] == nil {
+				os.Remove(dir + path)
+			}
+		}
+	}
+	return commitId.String(), nil
+}
+
+// addBlob stores content in the object database and stages it as path.
+func (s *gitStore) addBlob(index *git.Index, path string, content []byte) error {
+	blobId, err := s.repo.CreateBlobFromBuffer(content)
+	if err != nil {
+		return err
+	}
+	return index.Add(&git.IndexEntry{Path: path, Id: blobId, Mode: git.FilemodeBlob})
+}
+
+// commitChanges starts from the tree at the tip of branch, lets edit change
+// it and commits the result to branch.  When expected isn't nil, the tip has
+// to be that commit, checked under the same lock as the commit itself.  The
+// on-disk index is left alone, so this works the same way for every branch.
+func (s *gitStore) commitChanges(branch string, expected *string, sig *git.Signature, message string, edit func(*git.Index) error) (*git.Oid, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	parent, err := s.branchTip(branch)
+	if err != nil {
+		return nil, err
+	}
+	if expected != nil {
+		tip := ""
+		if parent != nil {
+			tip = parent.Id().String()
+		}
+		if tip != *expected {
+			return nil, errBranchMoved
+		}
+	}
+	index, err := git.NewIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer index.Free()
+	if parent != nil {
+		parentTree, err := parent.Tree()
+		if err != nil {
+			return nil, err
+		}
+		if err = index.ReadTree(parentTree); err != nil {
+			return nil, err
+		}
+	}
+	if err = edit(index); err != nil {
+		return nil, err
+	}
+	if parent == nil {
+		return s.commitIndex(index, branch, sig, message)
+	}
+	return s.commitIndex(index, branch, sig, message, parent)
+}
+
+// commitIndex writes index out as a tree and commits it to branch.
+func (s *gitStore) commitIndex(index *git.Index, branch string, sig *git.Signature, message string, parents ...*git.Commit) (*git.Oid, error) {
+	treeId, err := index.WriteTreeTo(s.repo)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := s.repo.LookupTree(treeId)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.CreateCommit("refs/heads/"+branch, sig, sig, message, tree, parents...)
+}
+
+// checkoutIfHead updates paths in the working tree and the index after a
+// commit to branch, provided branch is the one checked out.  With no paths
+// the whole tree is checked out, leaving locally modified files alone.
+func (s *gitStore) checkoutIfHead(branch string, paths ...string) error {
+	head, err := s.HeadBranch()
+	if err != nil || branch != head {
+		return err
+	}
+	opts := &git.CheckoutOpts{Strategy: git.CheckoutSafe}
+	if len(paths) > 0 {
+		opts = &git.CheckoutOpts{Strategy: git.CheckoutForce, Paths: paths}
+	}
+	return s.repo.CheckoutHead(opts)
+}
+
+// syncWorkDir brings the saved copies of paths on branch in line with a
+// commit just made there.  The checked out branch gets them checked out,
+// other branches simply drop them.
+func (s *gitStore) syncWorkDir(branch string, paths ...string) error {
+	dir, err := s.branchWorkDir(branch)
+	if err != nil {
+		return err
+	}
+	if dir == s.dir {
+		return s.checkoutIfHead(branch, paths...)
+	}
+	for _, path := range paths {
+		if err := os.Remove(dir + path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileChanged tells whether commit changed path relative to its first parent,
+// including adding it.
+func fileChanged(commit *git.Commit, path string) (bool, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return false, err
+	}
+	entry, err := tree.EntryByPath(path)
+	if err != nil {
+		if git.IsErrorCode(err, git.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if commit.ParentCount() == 0 {
+		return true, nil
+	}
+	parentTree, err := commit.Parent(0).Tree()
+	if err != nil {
+		return false, err
+	}
+	parentEntry, err := parentTree.EntryByPath(path)
+	if err != nil {
+		if git.IsErrorCode(err, git.ErrNotFound) {
+			return true, nil
+		}
+		return false, err
+	}
+	return !entry.Id.Equal(parentEntry.Id), nil
+}
+
+// renamedFrom returns the path commit renamed to path: one with the same
+// content in its first parent that commit deleted.  It returns an empty path
+// when path wasn't renamed.
+func renamedFrom(commit *git.Commit, path string) (string, error) {
+	if commit.ParentCount() == 0 {
+		return "", nil
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", err
+	}
+	entry, err := tree.EntryByPath(path)
+	if err != nil {
+		return "", err
+	}
+	parentTree, err := commit.Parent(0).Tree()
+	if err != nil {
+		return "", err
+	}
+	if _, err = parentTree.EntryByPath(path); err == nil {
+		return "", nil
+	}
+
+	var candidates []string
+	err = parentTree.Walk(func(root string, parentEntry *git.TreeEntry) int {
+		if parentEntry.Type == git.ObjectBlob && parentEntry.Id.Equal(entry.Id) {
+			candidates = append(candidates, root+parentEntry.Name)
+		}
+		return 0
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, candidate := range candidates {
+		if _, err := tree.EntryByPath(candidate); git.IsErrorCode(err, git.ErrNotFound) {
+			return candidate, nil
+		}
+	}
+	return "", nil
+}
+
+func commitInfo(commit *git.Commit) CommitInfo {
+	author, committer := commit.Author(), commit.Committer()
+	return CommitInfo{
+		Id:        commit.Id().String(),
+		Author:    author.Name,
+		Email:     author.Email,
+		Committer: committer.Name,
+		Time:      committer.When,
+		Message:   commit.Message(),
+	}
+}
+
+// History walks back from the tip of branch, in topological and then time
+// order, so merged commits show up too.
+func (s *gitStore) History(branch, fileName string, limit int) ([]CommitInfo, error) {
+	tip, err := s.branchTip(branch)
+	if err != nil {
+		return nil, err
+	}
+	if tip == nil {
+		return nil, notFound("branch %s has no commits", branch)
+	}
+	if _, err = s.readFileAtCommit(tip, fileName); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, notFound("%s isn't committed on %s", fileName, branch)
+		}
+		return nil, err
+	}
+
+	walk, err := s.repo.Walk()
+	if err != nil {
+		return nil, err
+	}
+	defer walk.Free()
+	walk.Sorting(git.SortTopological | git.SortTime)
+	if err = walk.Push(tip.Id()); err != nil {
+		return nil, err
+	}
+
+	history := make([]CommitInfo, 0, limit)
+	path := fileName
+	var walkErr error
+	err = walk.Iterate(func(commit *git.Commit) bool {
+		changed, err := fileChanged(commit, path)
+		if err != nil {
+			walkErr = err
+			return false
+		}
+		if !changed {
+			return true
+		}
+		info := commitInfo(commit)
+		info.Path = path
+		history = append(history, info)
+		from, err := renamedFrom(commit, path)
+		if err != nil {
+			walkErr = err
+			return false
+		}
+		if len(from) > 0 {
+			path = from
+		}
+		return len(history) < limit
+	})
+	if walkErr != nil {
+		err = walkErr
+	}
+	return history, err
+}
+
+func (s *gitStore) Diff(from, to string) ([]FileChange, error) {
+	var fromTree *git.Tree
+	if len(from) > 0 {
+		commit, err := s.resolveCommit(from)
+		if err != nil {
+			return nil, err
+		}
+		if fromTree, err = commit.Tree(); err != nil {
+			return nil, err
+		}
+	}
+	commit, err := s.resolveCommit(to)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := s.diffTrees(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+func (s *gitStore) LookupCommit(ref string) (*CommitInfo, error) {
+	commit, err := s.resolveCommit(ref)
+	if err != nil {
+		return nil, err
+	}
+	info := commitInfo(commit)
+	if info.Files, err = s.commitFiles(commit); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// commitFiles returns the paths a commit changed relative to its first
+// parent.
+func (s *gitStore) commitFiles(commit *git.Commit) ([]string, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var parentTree *git.Tree
+	if commit.ParentCount() > 0 {
+		if parentTree, err = commit.Parent(0).Tree(); err != nil {
+			return nil, err
+		}
+	}
+
+	diff, err := s.repo.DiffTreeToTree(parentTree, tree, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer diff.Free()
+
+	numDeltas, err := diff.NumDeltas()
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, numDeltas)
+	for i := 0; i < numDeltas; i++ {
+		delta, err := diff.GetDelta(i)
+		if err != nil {
+			return nil, err
+		}
+		if delta.Status == git.DeltaDeleted {
+			files = append(files, delta.OldFile.Path)
+		} else {
+			files = append(files, delta.NewFile.Path)
+		}
+	}
+	return files, nil
+}
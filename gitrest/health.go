@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// healthzHandler tells that gitrest is up.  It doesn't look at the
+// repository, so a slow disk doesn't get gitrest restarted.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Response{Status: Success, Message: "ok"})
+}
+
+// readyzHandler tells whether gitrest can serve requests, which for a Git
+// store means the repository opens and HEAD resolves.
+func (s *server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if s.git != nil {
+		err = s.git.ready()
+	}
+	if err != nil {
+		slog.Warn("Not ready", "error", err)
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Response{Status: Success, Message: "ready"})
+}
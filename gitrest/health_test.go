@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	ready := func(srv *server) int {
+		w := httptest.NewRecorder()
+		srv.readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+		return w.Code
+	}
+
+	if code := ready(newServer(newMemStore("master"))); code != http.StatusOK {
+		t.Errorf("Memory store got %d", code)
+	}
+
+	store := newTestRepo(t)
+	srv := newServer(store)
+	if code := ready(srv); code != http.StatusOK {
+		t.Errorf("Fresh repository, without commits, got %d", code)
+	}
+	commitTo(t, store.repo, "accounts.yaml", accountsSpec)
+	if code := ready(srv); code != http.StatusOK {
+		t.Errorf("Repository with a commit got %d", code)
+	}
+	os.RemoveAll(store.dir)
+	if code := ready(srv); code != http.StatusServiceUnavailable {
+		t.Errorf("Missing repository got %d", code)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/ghodss/yaml"
+	"github.com/libgit2/git2go"
+	"io/ioutil"
+	"net/http"
+)
+
+// Identity is the name and email recorded in commits made by a user.
+type Identity struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// identities maps basic auth user names to their commit identity.  Users
+// without an entry commit under their user name.
+var identities = map[string]Identity{}
+
+// loadIdentities reads a YAML or JSON file mapping user names to identities:
+//
+//	jdoe:
+//	  name: Jane Doe
+//	  email: jane.doe@example.com
+func loadIdentities(path string) (map[string]Identity, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]Identity)
+	if err = yaml.Unmarshal(bytes, &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// authenticatedUser returns the user the request was made by.
+func authenticatedUser(r *http.Request) string {
+	return r.Header.Get(usernameHeader)
+}
+
+// requestIdentity returns the identity commits made by the authenticated user
+// of r are recorded under.
+func requestIdentity(r *http.Request) Identity {
+	user := authenticatedUser(r)
+	id, ok := identities[user]
+	if !ok {
+		id = Identity{Name: user, Email: user}
+	}
+	if len(id.Name) == 0 {
+		id.Name = user
+	}
+	if len(id.Email) == 0 {
+		id.Email = user
+	}
+	return id
+}
+
+// commitSignature returns a fresh signature for a commit made by the
+// authenticated user of r.
+func commitSignature(r *http.Request) *git.Signature {
+	return signature(requestIdentity(r))
+}
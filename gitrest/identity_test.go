@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const identitiesFile = `
+jdoe:
+  name: Jane Doe
+  email: jane.doe@example.com
+bsmith:
+  name: Bob Smith
+`
+
+func TestIdentityMapping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identities.yaml")
+	os.WriteFile(path, []byte(identitiesFile), 0644)
+	ids, err := loadIdentities(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identities = ids
+	defer func() { identities = map[string]Identity{} }()
+
+	store := newMemStore("master")
+	request := routed(newServer(store).routes)
+	commitAs := func(user string) *CommitInfo {
+		store.Write("master", "accounts.yaml", []byte(accountsSpec+"# "+user+"\n"))
+		w := request("POST", "/commitfile/accounts.yaml", user, nil, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Commit as %s got %d %s", user, w.Code, w.Body.String())
+		}
+		commit, _ := store.LookupCommit("master")
+		return commit
+	}
+
+	tests := []struct {
+		user, name, email string
+	}{
+		{"jdoe", "Jane Doe", "jane.doe@example.com"},
+		// Missing parts of an identity fall back to the user name, and
+		// unmapped users commit under their user name alone.
+		{"bsmith", "Bob Smith", "bsmith"},
+		{"asmith", "asmith", "asmith"},
+	}
+	for _, test := range tests {
+		if commit := commitAs(test.user); commit.Author != test.name || commit.Email != test.email {
+			t.Errorf("%s committed as %s <%s>", test.user, commit.Author, commit.Email)
+		}
+	}
+
+	os.WriteFile(path, []byte("jdoe: [\n"), 0644)
+	if _, err = loadIdentities(path); err == nil {
+		t.Error("Loaded an invalid identities file")
+	}
+}
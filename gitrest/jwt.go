@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// jwtKey verifies JWTs signed by an identity provider whose public key is
+// configured with -jwt-public-key.  It is nil when JWTs aren't accepted.
+var jwtKey *jwtVerifier
+
+// JWTClaims are the claims gitrest looks at.  Sub is the user name and Scope
+// a space separated list that may contain "read" or "write".  Tokens without
+// a scope are read-only.
+type JWTClaims struct {
+	Sub   string   `json:"sub"`
+	Scope string   `json:"scope"`
+	Exp   int64    `json:"exp"`
+	Nbf   int64    `json:"nbf"`
+	Iss   string   `json:"iss,omitempty"`
+	Aud   Audience `json:"aud,omitempty"`
+}
+
+// Audience is the aud claim, which issuers send as a single string or as a
+// list of them.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = Audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return errors.New("malformed JWT audience")
+	}
+	*a = many
+	return nil
+}
+
+func (a Audience) contains(audience string) bool {
+	for _, aud := range a {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+}
+
+type jwtVerifier struct {
+	key crypto.PublicKey
+	// leeway allows for clock skew between gitrest and the issuer.
+	leeway time.Duration
+	// issuer and audience, when set, have to match the iss and aud claims,
+	// so that tokens the same key signed for other services are refused.
+	issuer, audience string
+}
+
+// loadJWTVerifier reads a PEM encoded RSA, ECDSA or Ed25519 public key, or a
+// certificate holding one.
+func loadJWTVerifier(path string) (*jwtVerifier, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bytes)
+	if block == nil {
+		return nil, errors.New("no PEM data in " + path)
+	}
+
+	var key crypto.PublicKey
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &jwtVerifier{key: key, leeway: time.Minute}, nil
+}
+
+// isJWT tells a JWT apart from a gitrest API token.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// Verify checks the signature, validity period, issuer and audience of token
+// and returns its claims.
+func (v *jwtVerifier) Verify(token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed JWT")
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed JWT signature")
+	}
+	if err = v.verifySignature(header.Alg, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	claims := &JWTClaims{}
+	if err = decodeSegment(parts[1], claims); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if claims.Exp == 0 || now.After(time.Unix(claims.Exp, 0).Add(v.leeway)) {
+		return nil, errors.New("JWT expired")
+	}
+	if claims.Nbf != 0 && now.Add(v.leeway).Before(time.Unix(claims.Nbf, 0)) {
+		return nil, errors.New("JWT not valid yet")
+	}
+	if len(claims.Sub) == 0 {
+		return nil, errors.New("JWT has no subject")
+	}
+	if len(v.issuer) > 0 && claims.Iss != v.issuer {
+		return nil, fmt.Errorf("JWT issued by %q", claims.Iss)
+	}
+	if len(v.audience) > 0 && !claims.Aud.contains(v.audience) {
+		return nil, errors.New("JWT not meant for " + v.audience)
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	bytes, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New("malformed JWT")
+	}
+	return json.Unmarshal(bytes, v)
+}
+
+// verifySignature checks signature against signed with the algorithm named
+// in the header, which has to suit the configured key.  "none" and the HMAC
+// algorithms are never accepted.
+func (v *jwtVerifier) verifySignature(alg, signed string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+	default:
+		return fmt.Errorf("unsupported JWT algorithm %q", alg)
+	}
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write([]byte(signed))
+		digest = h.Sum(nil)
+	}
+
+	invalid := errors.New("invalid JWT signature")
+	switch key := v.key.(type) {
+	case *rsa.PublicKey:
+		var err error
+		switch alg[:2] {
+		case "RS":
+			err = rsa.VerifyPKCS1v15(key, hash, digest, signature)
+		case "PS":
+			err = rsa.VerifyPSS(key, hash, digest, signature, nil)
+		default:
+			return fmt.Errorf("JWT algorithm %s doesn't suit an RSA key", alg)
+		}
+		if err != nil {
+			return invalid
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(signature) != 2*size {
+			return invalid
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return invalid
+		}
+	case ed25519.PublicKey:
+		if alg != "EdDSA" || !ed25519.Verify(key, []byte(signed), signature) {
+			return invalid
+		}
+	default:
+		return fmt.Errorf("unsupported public key %T", v.key)
+	}
+	return nil
+}
+
+// jwtScope maps the scope claim onto a token scope.
+func jwtScope(claims *JWTClaims) string {
+	for _, scope := range strings.Fields(claims.Scope) {
+		if scope == ScopeWrite || scope == "gitrest:"+ScopeWrite {
+			return ScopeWrite
+		}
+	}
+	return ScopeRead
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/vsheffer/gofun/util"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+)
+
+const requestIdHeader = "X-Request-Id"
+
+// logLevel is set by -log-level.  logBodies, set by -log-bodies, logs the
+// spec files clients save at the debug level, which is off by default since
+// specs can be large and may hold internal details.
+var (
+	logLevel  = new(slog.LevelVar)
+	logBodies bool
+)
+
+// validRequestId is what a request id passed in by a proxy has to look like
+// to be used instead of a fresh one.
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// setupLogging makes every log line, including those of the log package, a
+// JSON object written to w.
+func setupLogging(w io.Writer, level string) error {
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: logLevel})))
+	return nil
+}
+
+// requestInfo is filled in as a request makes its way through the handlers,
+// for the access log.
+type requestInfo struct {
+	id     string
+	user   string
+	route  string
+	logger *slog.Logger
+}
+
+type requestInfoKey struct{}
+
+func getRequestInfo(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// requestLogger returns a logger that tags every line with the id of r.
+func requestLogger(r *http.Request) *slog.Logger {
+	if info := getRequestInfo(r); info != nil {
+		return info.logger
+	}
+	return slog.Default()
+}
+
+// setRequestUser records the user a request was authenticated as.
+func setRequestUser(r *http.Request, user string) {
+	if info := getRequestInfo(r); info != nil {
+		info.user = user
+		info.logger = info.logger.With("user", user)
+	}
+}
+
+// recordRoute is mux middleware recording the route template a request
+// matched, so requests are logged and counted by route rather than by URL.
+func recordRoute(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := getRequestInfo(r); info != nil {
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					info.route = template
+				}
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// statusWriter remembers the status and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// accessLog gives every request an id, returned in the X-Request-Id header,
+// and logs the user, route, status and latency of each once it's done.
+func accessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIdHeader)
+		if !validRequestId.MatchString(id) {
+			guid, err := util.NewGuid()
+			if err != nil {
+				id = fmt.Sprintf("%x", start.UnixNano())
+			} else {
+				id = guid.String()
+			}
+		}
+		w.Header().Set(requestIdHeader, id)
+
+		info := &requestInfo{id: id, logger: slog.Default().With("requestId", id)}
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		latency := time.Since(start)
+		metrics.observeRequest(info.route, r.Method, sw.status, latency)
+
+		level := slog.LevelInfo
+		if sw.status >= 500 {
+			level = slog.LevelError
+		}
+		slog.Default().LogAttrs(r.Context(), level, "request",
+			slog.String("requestId", id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", info.route),
+			slog.String("user", info.user),
+			slog.Int("status", sw.status),
+			slog.Int("bytes", sw.bytes),
+			slog.Duration("latency", latency),
+			slog.String("remote", r.RemoteAddr),
+		)
+	})
+}
+
+// fatal logs msg and exits, for errors that keep gitrest from starting.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	var out bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	if err := setupLogging(&out, "info"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.Use(recordRoute)
+	r.HandleFunc("/specfiles/{filename:.+}", func(w http.ResponseWriter, r *http.Request) {
+		setRequestUser(r, "jdoe")
+		requestLogger(r).Debug("not logged at info")
+		requestLogger(r).Info("saving")
+		w.WriteHeader(http.StatusCreated)
+	}).Methods("PUT")
+	handler := accessLog(r)
+
+	req := httptest.NewRequest("PUT", "/specfiles/pets.yaml", strings.NewReader("secret: body"))
+	req.Header.Set(requestIdHeader, "abc-123")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Header().Get(requestIdHeader) != "abc-123" {
+		t.Errorf("Request id %q", w.Header().Get(requestIdHeader))
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || strings.Contains(out.String(), "secret") {
+		t.Fatalf("Unexpected log %s", out.String())
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["msg"] != "saving" || entry["requestId"] != "abc-123" || entry["user"] != "jdoe" {
+		t.Errorf("Handler log %v", entry)
+	}
+	entry = nil
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["route"] != "/specfiles/{filename:.+}" || entry["status"] != float64(201) || entry["user"] != "jdoe" || entry["requestId"] != "abc-123" {
+		t.Errorf("Access log %v", entry)
+	}
+
+	// Request ids that don't look like one are replaced.
+	req = httptest.NewRequest("PUT", "/specfiles/pets.yaml", nil)
+	req.Header.Set(requestIdHeader, "bad id\n")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if id := w.Header().Get(requestIdHeader); len(id) == 0 || id == "bad id\n" {
+		t.Errorf("Request id %q", id)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memStore is a SpecStore kept in memory, for tests.  Every commit holds a
+// full snapshot of the files on its branch.
+type memStore struct {
+	mutex    sync.Mutex
+	head     string
+	branches map[string]string
+	commits  map[string]*memCommit
+	saved    map[string]map[string][]byte
+}
+
+type memCommit struct {
+	info   CommitInfo
+	parent string
+	files  map[string][]byte
+}
+
+// newMemStore returns an empty store whose only branch is head.
+func newMemStore(head string) *memStore {
+	return &memStore{
+		head:     head,
+		branches: map[string]string{head: ""},
+		commits:  make(map[string]*memCommit),
+		saved:    make(map[string]map[string][]byte),
+	}
+}
+
+func (m *memStore) HeadBranch() (string, error) {
+	return m.head, nil
+}
+
+func (m *memStore) HasBranch(branch string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, ok := m.branches[branch]
+	return ok, nil
+}
+
+// resolve returns the commit at ref, a branch or a commit id.  It returns
+// nil for a branch without commits.
+func (m *memStore) resolve(ref string) (*memCommit, error) {
+	id, ok := m.branches[strings.TrimPrefix(ref, "refs/heads/")]
+	if !ok {
+		id = ref
+	}
+	if len(id) == 0 {
+		return nil, nil
+	}
+	commit, ok := m.commits[id]
+	if !ok {
+		return nil, notFound("unknown ref %s", ref)
+	}
+	return commit, nil
+}
+
+func (m *memStore) List(branch string) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	tip, err := m.resolve(branch)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	if tip != nil {
+		for name := range tip.files {
+			names[name] = true
+		}
+	}
+	for name := range m.saved[branch] {
+		names[name] = true
+	}
+	fileNames := make([]string, 0, len(names))
+	for name := range names {
+		fileNames = append(fileNames, name)
+	}
+	sort.Strings(fileNames)
+	return fileNames, nil
+}
+
+func (m *memStore) Read(branch, fileName string) ([]byte, error) {
+	if content, err := m.ReadSaved(branch, fileName); err == nil {
+		return content, nil
+	}
+	return m.ReadAt(branch, fileName)
+}
+
+func (m *memStore) ReadSaved(branch, fileName string) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	content, ok := m.saved[branch][fileName]
+	if !ok {
+		return nil, notFound("%s hasn't been saved on %s", fileName, branch)
+	}
+	return content, nil
+}
+
+func (m *memStore) ReadAt(ref, fileName string) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	commit, err := m.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	if commit == nil || commit.files[fileName] == nil {
+		return nil, notFound("%s doesn't exist at %s", fileName, ref)
+	}
+	return commit.files[fileName], nil
+}
+
+func (m *memStore) Write(branch, fileName string, content []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.branches[branch]; !ok {
+		return errBranchNotFound
+	}
+	if m.saved[branch] == nil {
+		m.saved[branch] = make(map[string][]byte)
+	}
+	m.saved[branch][fileName] = append([]byte(nil), content...)
+	return nil
+}
+
+func (m *memStore) Commit(branch string, author Identity, message string, files map[string][]byte) (string, error) {
+	return m.commitOn(branch, nil, author, message, files)
+}
+
+func (m *memStore) CommitOn(branch, parent string, author Identity, message string, files map[string][]byte) (string, error) {
+	return m.commitOn(branch, &parent, author, message, files)
+}
+
+// commitOn commits files to branch, provided it's at expected when that isn't
+// nil.
+func (m *memStore) commitOn(branch string, expected *string, author Identity, message string, files map[string][]byte) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	parentId, ok := m.branches[branch]
+	if !ok {
+		return "", errBranchNotFound
+	}
+	if expected != nil && *expected != parentId {
+		return "", errBranchMoved
+	}
+
+	snapshot := make(map[string][]byte)
+	if parent := m.commits[parentId]; parent != nil {
+		for name, content := range parent.files {
+			snapshot[name] = content
+		}
+	}
+	changed := make([]string, 0, len(files))
+	for name, content := range files {
+		if content == nil {
+			delete(snapshot, name)
+		} else {
+			snapshot[name] = append([]byte(nil), content...)
+		}
+		delete(m.saved[branch], name)
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+
+	id := fmt.Sprintf("%040x", len(m.commits)+1)
+	m.commits[id] = &memCommit{
+		info: CommitInfo{
+			Id:        id,
+			Author:    author.Name,
+			Email:     author.Email,
+			Committer: author.Name,
+			Time:      time.Now(),
+			Message:   message,
+			Files:     changed,
+		},
+		parent: parentId,
+		files:  snapshot,
+	}
+	m.branches[branch] = id
+	return id, nil
+}
+
+func (m *memStore) History(branch, fileName string, limit int) ([]CommitInfo, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	commit, err := m.resolve(branch)
+	if err != nil {
+		return nil, err
+	}
+	if commit == nil {
+		return nil, notFound("branch %s has no commits", branch)
+	}
+	if commit.files[fileName] == nil {
+		return nil, notFound("%s isn't committed on %s", fileName, branch)
+	}
+
+	history := make([]CommitInfo, 0, limit)
+	path := fileName
+	for ; commit != nil && len(history) < limit; commit = m.commits[commit.parent] {
+		content := commit.files[path]
+		if content == nil {
+			continue
+		}
+		parent := m.commits[commit.parent]
+		if parent != nil && bytes.Equal(parent.files[path], content) {
+			continue
+		}
+		info := commit.info
+		info.Files = nil
+		info.Path = path
+		history = append(history, info)
+		if parent != nil && parent.files[path] == nil {
+			path = commit.renamedFrom(parent, path)
+		}
+	}
+	return history, nil
+}
+
+// renamedFrom returns the path c renamed to path, or path itself when it
+// wasn't renamed.
+func (c *memCommit) renamedFrom(parent *memCommit, path string) string {
+	var candidates []string
+	for name, content := range parent.files {
+		if c.files[name] == nil && bytes.Equal(content, c.files[path]) {
+			candidates = append(candidates, name)
+		}
+	}
+	if len(candidates) == 0 {
+		return path
+	}
+	sort.Strings(candidates)
+	return candidates[0]
+}
+
+func (m *memStore) Diff(from, to string) ([]FileChange, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var old map[string][]byte
+	if len(from) > 0 {
+		commit, err := m.resolve(from)
+		if err != nil {
+			return nil, err
+		}
+		if commit != nil {
+			old = commit.files
+		}
+	}
+	commit, err := m.resolve(to)
+	if err != nil {
+		return nil, err
+	}
+	var current map[string][]byte
+	if commit != nil {
+		current = commit.files
+	}
+
+	var changes []FileChange
+	for name, content := range current {
+		if previous, ok := old[name]; !ok {
+			changes = append(changes, FileChange{Path: name, Status: FileAdded, Content: content})
+		} else if !bytes.Equal(previous, content) {
+			changes = append(changes, FileChange{Path: name, Status: FileModified, Content: content})
+		}
+	}
+	for name := range old {
+		if _, ok := current[name]; !ok {
+			changes = append(changes, FileChange{Path: name, Status: FileDeleted})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+func (m *memStore) LookupCommit(ref string) (*CommitInfo, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	commit, err := m.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	if commit == nil {
+		return nil, notFound("%s has no commits", ref)
+	}
+	info := commit.info
+	return &info, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metrics collects what /metrics reports, in the Prometheus text format.
+var metrics = newMetricSet()
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency
+// histogram.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// repoSizeTTL is how long the size of the repository is cached, since
+// measuring it walks the whole directory.
+const repoSizeTTL = time.Minute
+
+type requestKey struct {
+	route  string
+	method string
+	status int
+}
+
+type histogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+type metricSet struct {
+	mutex        sync.Mutex
+	requests     map[requestKey]uint64
+	latencies    map[string]*histogram
+	commits      uint64
+	authFailures map[string]uint64
+
+	// repoDirs are the directories whose size is reported.
+	repoDirs     []string
+	repoSize     int64
+	repoSizeTime time.Time
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{
+		requests:     make(map[requestKey]uint64),
+		latencies:    make(map[string]*histogram),
+		authFailures: make(map[string]uint64),
+	}
+}
+
+// observeRequest counts a request to route.  Requests that didn't match a
+// route, including those that failed authentication, are counted as "none".
+func (m *metricSet) observeRequest(route, method string, status int, latency time.Duration) {
+	if len(route) == 0 {
+		route = "none"
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.requests[requestKey{route, method, status}]++
+	h, ok := m.latencies[route]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(latencyBuckets))}
+		m.latencies[route] = h
+	}
+	seconds := latency.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (m *metricSet) countCommit() {
+	m.mutex.Lock()
+	m.commits++
+	m.mutex.Unlock()
+}
+
+// countAuthFailure counts a request rejected by the authenticator, by the
+// kind of credentials it had: basic, bearer or none.
+func (m *metricSet) countAuthFailure(kind string) {
+	m.mutex.Lock()
+	m.authFailures[kind]++
+	m.mutex.Unlock()
+}
+
+// repositorySize returns the bytes taken up by the repository directories,
+// measured at most once every repoSizeTTL.  The directories are walked
+// without holding the lock, which would hold up every request meanwhile.
+func (m *metricSet) repositorySize() int64 {
+	m.mutex.Lock()
+	size, measured, dirs := m.repoSize, m.repoSizeTime, m.repoDirs
+	m.mutex.Unlock()
+	if time.Since(measured) < repoSizeTTL {
+		return size
+	}
+
+	size = 0
+	for _, dir := range dirs {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				size += info.Size()
+			}
+			return nil
+		})
+	}
+	m.mutex.Lock()
+	m.repoSize, m.repoSizeTime = size, time.Now()
+	m.mutex.Unlock()
+	return size
+}
+
+// escapeLabel escapes a label value for the text format.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// write writes every metric in the Prometheus text exposition format.
+func (m *metricSet) write(w io.Writer) {
+	repoSize := m.repositorySize()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	fmt.Fprintln(w, "# HELP gitrest_http_requests_total Requests handled, by route, method and status.")
+	fmt.Fprintln(w, "# TYPE gitrest_http_requests_total counter")
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "gitrest_http_requests_total{route=\"%s\",method=\"%s\",status=\"%d\"} %d\n",
+			escapeLabel(key.route), escapeLabel(key.method), key.status, m.requests[key])
+	}
+
+	fmt.Fprintln(w, "# HELP gitrest_http_request_duration_seconds Request latency, by route.")
+	fmt.Fprintln(w, "# TYPE gitrest_http_request_duration_seconds histogram")
+	routes := make([]string, 0, len(m.latencies))
+	for route := range m.latencies {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		h, label := m.latencies[route], escapeLabel(route)
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "gitrest_http_request_duration_seconds_bucket{route=\"%s\",le=\"%s\"} %d\n", label, formatFloat(bound), h.buckets[i])
+		}
+		fmt.Fprintf(w, "gitrest_http_request_duration_seconds_bucket{route=\"%s\",le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(w, "gitrest_http_request_duration_seconds_sum{route=\"%s\"} %s\n", label, formatFloat(h.sum))
+		fmt.Fprintf(w, "gitrest_http_request_duration_seconds_count{route=\"%s\"} %d\n", label, h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP gitrest_commits_total Commits added to branches, including those pulled from the remote.")
+	fmt.Fprintln(w, "# TYPE gitrest_commits_total counter")
+	fmt.Fprintf(w, "gitrest_commits_total %d\n", m.commits)
+
+	fmt.Fprintln(w, "# HELP gitrest_auth_failures_total Requests rejected for bad or missing credentials, by kind of credentials.")
+	fmt.Fprintln(w, "# TYPE gitrest_auth_failures_total counter")
+	kinds := make([]string, 0, len(m.authFailures))
+	for kind := range m.authFailures {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "gitrest_auth_failures_total{kind=\"%s\"} %d\n", escapeLabel(kind), m.authFailures[kind])
+	}
+
+	fmt.Fprintln(w, "# HELP gitrest_repo_size_bytes Size of the repository directories on disk.")
+	fmt.Fprintln(w, "# TYPE gitrest_repo_size_bytes gauge")
+	fmt.Fprintf(w, "gitrest_repo_size_bytes %d\n", repoSize)
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.write(w)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsFormat(t *testing.T) {
+	m := newMetricSet()
+	m.repoSizeTime = time.Now()
+	m.repoSize = 4096
+	m.observeRequest("/specfiles/{filename:.+}", "GET", 200, 3*time.Millisecond)
+	m.observeRequest("/specfiles/{filename:.+}", "GET", 200, 300*time.Millisecond)
+	m.observeRequest("", "GET", 401, time.Millisecond)
+	m.countCommit()
+	m.countAuthFailure("basic")
+
+	var out bytes.Buffer
+	m.write(&out)
+	text := out.String()
+	for _, want := range []string{
+		"# TYPE gitrest_http_requests_total counter\n",
+		`gitrest_http_requests_total{route="/specfiles/{filename:.+}",method="GET",status="200"} 2` + "\n",
+		`gitrest_http_requests_total{route="none",method="GET",status="401"} 1` + "\n",
+		`gitrest_http_request_duration_seconds_bucket{route="/specfiles/{filename:.+}",le="0.005"} 1` + "\n",
+		`gitrest_http_request_duration_seconds_bucket{route="/specfiles/{filename:.+}",le="0.5"} 2` + "\n",
+		`gitrest_http_request_duration_seconds_bucket{route="/specfiles/{filename:.+}",le="+Inf"} 2` + "\n",
+		`gitrest_http_request_duration_seconds_count{route="/specfiles/{filename:.+}"} 2` + "\n",
+		"gitrest_commits_total 1\n",
+		`gitrest_auth_failures_total{kind="basic"} 1` + "\n",
+		"gitrest_repo_size_bytes 4096\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Missing %q in\n%s", want, text)
+		}
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if got := escapeLabel("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Errorf("Got %s", got)
+	}
+}
+
+func TestRepositorySize(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "specs"), 0755)
+	os.WriteFile(filepath.Join(dir, "a.yaml"), make([]byte, 100), 0644)
+	os.WriteFile(filepath.Join(dir, "specs", "b.yaml"), make([]byte, 20), 0644)
+
+	m := newMetricSet()
+	m.repoDirs = []string{dir}
+	if size := m.repositorySize(); size != 120 {
+		t.Errorf("Measured %d bytes", size)
+	}
+	// The size is only measured again once it's stale.
+	os.WriteFile(filepath.Join(dir, "c.yaml"), make([]byte, 5), 0644)
+	if size := m.repositorySize(); size != 120 {
+		t.Errorf("Measured %d bytes again", size)
+	}
+	m.repoSizeTime = time.Now().Add(-repoSizeTTL)
+	if size := m.repositorySize(); size != 125 {
+		t.Errorf("Measured %d bytes after it was stale", size)
+	}
+}
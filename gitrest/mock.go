@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/vsheffer/gofun/mock"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// mockRefHeader names the ref of the spec file to mock, the tip of the head
+// branch by default.  It's a header rather than ?ref= so that the query is
+// left to the mocked API.
+const mockRefHeader = "Mock-Ref"
+
+// maxMockServers bounds the mock servers kept between requests.
+const maxMockServers = 64
+
+// mockKey identifies a mock server: commits don't change, so neither does
+// the spec file bundled at one.
+type mockKey struct {
+	commit, fileName string
+}
+
+// mockServer is a cached mock server with the files its spec was bundled
+// from, which a user has to be able to read to use it.
+type mockServer struct {
+	server *mock.Server
+	files  []string
+}
+
+// mockCache keeps the mock servers of a repository so that requests don't
+// bundle and parse the spec file every time.
+type mockCache struct {
+	mutex   sync.Mutex
+	servers map[mockKey]*mockServer
+}
+
+func newMockCache() *mockCache {
+	return &mockCache{servers: make(map[mockKey]*mockServer)}
+}
+
+func (c *mockCache) get(key mockKey) *mockServer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.servers[key]
+}
+
+// put adds a mock server, dropping another one when the cache is full.
+func (c *mockCache) put(key mockKey, server *mockServer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.servers) >= maxMockServers {
+		for k := range c.servers {
+			delete(c.servers, k)
+			break
+		}
+	}
+	c.servers[key] = server
+}
+
+// mockHandler serves a fake implementation of the API a spec file
+// describes, for clients to be developed against before it exists:
+// /mock/pets.yaml/pets/1 answers like GET /pets/1 would, with an example or
+// sample data, once the request has been checked against the spec.
+func (s *server) mockHandler(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	fileName := vars["filename"]
+	ref := r.Header.Get(mockRefHeader)
+	if len(ref) == 0 {
+		head, err := s.store.HeadBranch()
+		if err != nil {
+			return err
+		}
+		ref = "refs/heads/" + head
+	}
+	commit, err := s.store.LookupCommit(ref)
+	if err != nil {
+		return withStatus(http.StatusNotFound, err)
+	}
+	key := mockKey{commit.Id, fileName}
+	cached := s.mocks.get(key)
+	if cached != nil {
+		for _, path := range cached.files {
+			if err := s.checkReader(r, path); err != nil {
+				return err
+			}
+		}
+	} else {
+		_, bundle, files, err := s.bundleFiles(r, commit.Id, fileName)
+		if err != nil {
+			return err
+		}
+		server, err := mock.New(bundle)
+		if err != nil {
+			return withStatus(http.StatusUnprocessableEntity, fmt.Errorf("can't mock %s: %v", fileName, err))
+		}
+		cached = &mockServer{server, files}
+		s.mocks.put(key, cached)
+	}
+
+	mocked := r.Clone(r.Context())
+	mocked.URL = &url.URL{Path: vars["path"], RawQuery: r.URL.RawQuery}
+	if len(mocked.URL.Path) == 0 {
+		mocked.URL.Path = "/"
+	}
+	requestLogger(r).Debug("Mocking", "file", fileName, "commit", commit.Id, "method", r.Method, "path", mocked.URL.Path)
+	cached.server.ServeHTTP(w, mocked)
+	return nil
+}
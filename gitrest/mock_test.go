@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMock(t *testing.T) {
+	store := newMemStore("master")
+	srv := newServer(store)
+	jdoe := Identity{Name: "jdoe", Email: "jdoe@example.com"}
+	store.Commit("master", jdoe, "Add pets", map[string][]byte{
+		"specs/pets.yaml":         []byte(petsSpec),
+		"specs/common/pet.yaml":   []byte(petSpec),
+		"specs/common/owner.yaml": []byte("type: string\n"),
+	})
+	first, _ := store.LookupCommit("master")
+	store.Commit("master", jdoe, "Name owners", map[string][]byte{
+		"specs/common/owner.yaml": []byte("type: object\nproperties:\n  name:\n    type: string\n    example: Ann\n"),
+	})
+
+	request := routed(srv.routes)
+
+	tests := []struct {
+		method, target, ref string
+		status              int
+		body                string
+	}{
+		{"GET", "/mock/specs/pets.yaml/pets", "", http.StatusOK, `{"owner":{"name":"Ann"}}`},
+		{"GET", "/mock/specs/pets.yaml/pets?ref=ignored", first.Id, http.StatusOK, `{"owner":"string"}`},
+		{"POST", "/mock/specs/pets.yaml/pets", "", http.StatusMethodNotAllowed, `"message":"POST isn't an operation of /pets."`},
+		{"GET", "/mock/specs/pets.yaml/owners", "", http.StatusNotFound, `"message":"No path of the spec matches /owners."`},
+		{"GET", "/mock/specs/pets.yaml", "", http.StatusNotFound, `"message":"No path of the spec matches /."`},
+		{"GET", "/mock/specs/nope.yaml/pets", "", http.StatusNotFound, `"status":"error"`},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if len(test.ref) > 0 {
+			header.Set(mockRefHeader, test.ref)
+		}
+		w := request(test.method, test.target, "jdoe", header, nil)
+		if w.Code != test.status || !strings.Contains(w.Body.String(), test.body) {
+			t.Errorf("%s %s got %d %s", test.method, test.target, w.Code, w.Body.String())
+		}
+	}
+}
+
+// mockPolicy lets jdoe read specs/pets.yaml but not the files it refers to.
+const mockPolicy = `
+default: none
+grants:
+  - role: reader
+    users: [jdoe]
+    prefix: specs/pets.yaml
+`
+
+func TestMockCache(t *testing.T) {
+	store := newMemStore("master")
+	srv := newServer(store)
+	jdoe := Identity{Name: "jdoe", Email: "jdoe@example.com"}
+	store.Commit("master", jdoe, "Add pets", map[string][]byte{
+		"specs/pets.yaml":         []byte(petsSpec),
+		"specs/common/pet.yaml":   []byte(petSpec),
+		"specs/common/owner.yaml": []byte("type: string\n"),
+	})
+	request := routed(srv.routes)
+
+	for i := 0; i < 2; i++ {
+		if w := request("GET", "/mock/specs/pets.yaml/pets", "jdoe", nil, nil); w.Code != http.StatusOK {
+			t.Fatalf("Mock got %d %s", w.Code, w.Body.String())
+		}
+	}
+	if len(srv.mocks.servers) != 1 {
+		t.Errorf("Cached %d servers for one commit", len(srv.mocks.servers))
+	}
+	store.Commit("master", jdoe, "Name owners", map[string][]byte{
+		"specs/common/owner.yaml": []byte("type: object\nproperties:\n  name:\n    type: string\n    example: Ann\n"),
+	})
+	if w := request("GET", "/mock/specs/pets.yaml/pets", "jdoe", nil, nil); !strings.Contains(w.Body.String(), `{"owner":{"name":"Ann"}}`) {
+		t.Errorf("Mock after a commit got %d %s", w.Code, w.Body.String())
+	}
+	if len(srv.mocks.servers) != 2 {
+		t.Errorf("Cached %d servers for two commits", len(srv.mocks.servers))
+	}
+
+	policyFile := filepath.Join(t.TempDir(), "authz.yaml")
+	os.WriteFile(policyFile, []byte(mockPolicy), 0644)
+	var err error
+	if srv.authz, err = newAuthorizer(policyFile); err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, "cached server with an unreadable file", request("GET", "/mock/specs/pets.yaml/pets", "jdoe", nil, nil), http.StatusForbidden)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/vsheffer/gofun/openapi"
+	"net/http"
+	"sort"
+)
+
+type DependentsResponse struct {
+	Status     string   `json:"status"`
+	Message    string   `json:"message"`
+	File       string   `json:"file"`
+	Commit     string   `json:"commit"`
+	Dependents []string `json:"dependents"`
+}
+
+// bundleHandler returns a spec file at ?ref= with the files it refers to
+// through $ref resolved into it, as a single document.  It's served like a
+// spec file, so it can be converted and asked for as YAML or JSON too.
+func (s *server) bundleHandler(w http.ResponseWriter, r *http.Request) error {
+	fileName := mux.Vars(r)["filename"]
+	ref, err := s.requestRef(r)
+	if err != nil {
+		return err
+	}
+	commit, bundle, err := s.bundleAt(r, ref, fileName)
+	if err != nil {
+		return err
+	}
+	requestLogger(r).Debug("Bundled spec file", "file", fileName, "commit", commit.Id)
+	return writeSpec(w, r, fileName, bundle)
+}
+
+// bundleAt bundles a spec file at ref with the files it refers to, which
+// the user has to be able to read.
+func (s *server) bundleAt(r *http.Request, ref, fileName string) (*CommitInfo, []byte, error) {
+	commit, bundle, _, err := s.bundleFiles(r, ref, fileName)
+	return commit, bundle, err
+}
+
+// bundleFiles does what bundleAt does and also returns the files the bundle
+// was made of.
+func (s *server) bundleFiles(r *http.Request, ref, fileName string) (*CommitInfo, []byte, []string, error) {
+	commit, err := s.store.LookupCommit(ref)
+	if err != nil {
+		return nil, nil, nil, withStatus(http.StatusNotFound, err)
+	}
+	if _, err = s.store.ReadAt(commit.Id, fileName); err != nil {
+		return nil, nil, nil, withStatus(http.StatusNotFound, fmt.Errorf("%s doesn't exist at %s", fileName, ref))
+	}
+
+	var files []string
+	bundle, err := openapi.Bundle(fileName, func(path string) ([]byte, error) {
+		if err := checkFileName(path); err != nil {
+			return nil, err
+		}
+		if err := s.checkReader(r, path); err != nil {
+			return nil, err
+		}
+		files = append(files, path)
+		return s.store.ReadAt(commit.Id, path)
+	})
+	if err != nil {
+		var refErr *openapi.RefError
+		if errors.As(err, &refErr) && errorStatus(err) != http.StatusForbidden {
+			return nil, nil, nil, withStatus(http.StatusUnprocessableEntity, err)
+		}
+		return nil, nil, nil, err
+	}
+	return commit, bundle, files, nil
+}
+
+// checkReader fails with a 403 unless the user can read path.
+func (s *server) checkReader(r *http.Request, path string) error {
+	if s.userRole(r, path) < RoleReader {
+		return withStatus(http.StatusForbidden, fmt.Errorf("%s needs the %s role for %s", authenticatedUser(r), RoleReader, path))
+	}
+	return nil
+}
+
+// dependentsHandler lists the spec files at ?ref= that refer to a file
+// through $ref, and with ?transitive=true also those that do through other
+// files.
+func (s *server) dependentsHandler(w http.ResponseWriter, r *http.Request) error {
+	fileName := mux.Vars(r)["filename"]
+	transitive := r.URL.Query().Get("transitive") == "true"
+	ref, err := s.requestRef(r)
+	if err != nil {
+		return err
+	}
+	commit, err := s.store.LookupCommit(ref)
+	if err != nil {
+		return withStatus(http.StatusNotFound, err)
+	}
+	files, err := s.committedFiles(commit.Id)
+	if err != nil {
+		return err
+	}
+
+	// referrers maps each file to the readable files referring to it.
+	referrers := make(map[string][]string)
+	for name, content := range files {
+		if checkFileName(name) != nil || s.userRole(r, name) < RoleReader {
+			continue
+		}
+		references, err := openapi.References(name, content)
+		if err != nil {
+			continue
+		}
+		for _, reference := range references {
+			referrers[reference] = append(referrers[reference], name)
+		}
+	}
+
+	found := map[string]bool{fileName: true}
+	queue := []string{fileName}
+	dependents := []string{}
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+		for _, name := range referrers[file] {
+			if found[name] {
+				continue
+			}
+			found[name] = true
+			dependents = append(dependents, name)
+			if transitive {
+				queue = append(queue, name)
+			}
+		}
+	}
+	sort.Strings(dependents)
+	writeJSON(w, http.StatusOK, DependentsResponse{
+		Status:     Success,
+		Message:    fmt.Sprintf("%d spec files refer to %s.", len(dependents), fileName),
+		File:       fileName,
+		Commit:     commit.Id,
+		Dependents: dependents,
+	})
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const petsSpec = `swagger: "2.0"
+info:
+  title: Pets
+  version: "1.0"
+paths:
+  /pets:
+    get:
+      responses:
+        200:
+          description: The pets.
+          schema:
+            $ref: common/pet.yaml
+`
+
+const petSpec = `type: object
+properties:
+  owner:
+    $ref: owner.yaml
+`
+
+// referencesPolicy lets asmith read everything but private/.
+const referencesPolicy = `
+default: none
+grants:
+  - role: reader
+    users: [asmith]
+    prefix: common/
+  - role: reader
+    users: [asmith]
+    prefix: pets.yaml
+  - role: reader
+    users: [asmith]
+    prefix: leaky.yaml
+  - role: reader
+    users: [asmith]
+    prefix: loop.yaml
+  - role: reader
+    users: [asmith]
+    prefix: node.yaml
+`
+
+func TestBundleAndDependents(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "authz.yaml")
+	os.WriteFile(policyFile, []byte(referencesPolicy), 0644)
+	store := newMemStore("master")
+	srv := newServer(store)
+	var err error
+	if srv.authz, err = newAuthorizer(policyFile); err != nil {
+		t.Fatal(err)
+	}
+	jdoe := Identity{Name: "jdoe", Email: "jdoe@example.com"}
+	store.Commit("master", jdoe, "Add pets", map[string][]byte{
+		"pets.yaml":         []byte(petsSpec),
+		"common/pet.yaml":   []byte(petSpec),
+		"common/owner.yaml": []byte("type: string\n"),
+		"loop.yaml":         []byte("swagger: \"2.0\"\npaths:\n  /a:\n    $ref: node.yaml\n"),
+		"node.yaml":         []byte("$ref: node.yaml\n"),
+		"private/uses.yaml": []byte("swagger: \"2.0\"\npaths:\n  /pets:\n    $ref: ../pets.yaml#/paths/~1pets\n"),
+		"leaky.yaml":        []byte("swagger: \"2.0\"\npaths:\n  /secret:\n    $ref: private/uses.yaml#/paths/~1pets\n"),
+	})
+	first, _ := store.LookupCommit("master")
+	store.Commit("master", jdoe, "Drop owner", map[string][]byte{"common/owner.yaml": nil})
+
+	request := routed(srv.routes)
+
+	w := request("GET", "/bundle/pets.yaml?ref="+first.Id, "asmith", nil, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"owner":{"type":"string"}`) {
+		t.Errorf("Bundle got %d %s", w.Code, w.Body.String())
+	}
+	expectError(t, "missing reference", request("GET", "/bundle/pets.yaml", "asmith", nil, nil), http.StatusUnprocessableEntity)
+	expectError(t, "cycle", request("GET", "/bundle/loop.yaml", "asmith", nil, nil), http.StatusUnprocessableEntity)
+	expectError(t, "unreadable reference", request("GET", "/bundle/leaky.yaml", "asmith", nil, nil), http.StatusForbidden)
+	expectError(t, "missing file", request("GET", "/bundle/common/nope.yaml", "asmith", nil, nil), http.StatusNotFound)
+
+	dependents := func(target string) string {
+		var resp DependentsResponse
+		w := request("GET", target, "asmith", nil, nil)
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("Got %d %s", w.Code, w.Body.String())
+		}
+		return strings.Join(resp.Dependents, ",")
+	}
+	if got := dependents("/dependents/common/owner.yaml?ref=" + first.Id); got != "common/pet.yaml" {
+		t.Errorf("Dependents of owner.yaml: %s", got)
+	}
+	// private/uses.yaml can't be read by asmith, so neither it nor what
+	// depends on it through it is listed.
+	if got := dependents("/dependents/common/owner.yaml?transitive=true"); got != "common/pet.yaml,pets.yaml" {
+		t.Errorf("Transitive dependents of owner.yaml: %s", got)
+	}
+}
+
+func TestSaveAndBundleFragments(t *testing.T) {
+	srv := newServer(newMemStore("master"))
+	request := routed(srv.routes)
+
+	for _, file := range []struct{ name, content string }{
+		{"common/owner.yaml", "type: string\n"},
+		{"common/pet.yaml", petSpec},
+		{"pets.yaml", petsSpec},
+	} {
+		if w := request("PUT", "/specfiles/"+file.name, "jdoe", nil, strings.NewReader(file.content)); w.Code != http.StatusOK {
+			t.Fatalf("Saving %s got %d %s", file.name, w.Code, w.Body.String())
+		}
+		if w := request("POST", "/commitfile/"+file.name, "jdoe", nil, nil); w.Code != http.StatusOK {
+			t.Fatalf("Committing %s got %d %s", file.name, w.Code, w.Body.String())
+		}
+	}
+	w := request("GET", "/bundle/pets.yaml", "jdoe", nil, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"owner":{"type":"string"}`) {
+		t.Errorf("Bundle got %d %s", w.Code, w.Body.String())
+	}
+
+	expectError(t, "fragment that isn't a mapping", request("PUT", "/specfiles/common/list.yaml", "jdoe", nil, strings.NewReader("- a\n- b\n")), http.StatusUnprocessableEntity)
+	expectError(t, "spec missing info", request("PUT", "/specfiles/broken.yaml", "jdoe", nil, strings.NewReader("swagger: \"2.0\"\npaths: {}\n")), http.StatusUnprocessableEntity)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+type RepoInfo struct {
+	Name    string `json:"name"`
+	Default bool   `json:"default"`
+}
+
+type RepoListResponse struct {
+	Repos []RepoInfo `json:"repos"`
+}
+
+type CreateRepoRequest struct {
+	Name string `json:"name"`
+}
+
+// validRepoName is what the name of a repository has to look like, since it
+// is both a path element of its URLs and the name of its directory.
+var validRepoName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// repoSet holds the repositories gitrest serves, each under
+// /repos/{repo}/.  New repositories are created in dir.
+type repoSet struct {
+	dir         string
+	defaultName string
+
+	// authz, notifications, compatGate and canonicalFormat are shared by
+	// every repository.
+	authz           *authorizer
+	notifications   *notifier
+	compatGate      []string
+	canonicalFormat string
+
+	mutex   sync.RWMutex
+	servers map[string]*server
+	routers map[string]http.Handler
+}
+
+func newRepoSet(dir, defaultName string) *repoSet {
+	return &repoSet{
+		dir:           dir,
+		defaultName:   defaultName,
+		notifications: newNotifier(nil, ""),
+		servers:       make(map[string]*server),
+		routers:       make(map[string]http.Handler),
+	}
+}
+
+// newRepo returns the server for store as the repository called name, and a
+// router for its routes under /repos/{repo}.
+func (rs *repoSet) newRepo(name string, store SpecStore) (*server, http.Handler) {
+	srv := newServer(store)
+	srv.name = name
+	srv.authz = rs.authz
+	srv.notifications = rs.notifications
+	srv.compatGate = rs.compatGate
+	srv.canonicalFormat = rs.canonicalFormat
+	if srv.git != nil {
+		go srv.indexCommits()
+	}
+
+	r := mux.NewRouter()
+	r.Use(recordRoute)
+	srv.routes(r.PathPrefix("/repos/{repo}").Subrouter())
+	return srv, r
+}
+
+// add starts serving store as the repository called name.
+func (rs *repoSet) add(name string, store SpecStore) *server {
+	srv, router := rs.newRepo(name, store)
+	rs.mutex.Lock()
+	rs.servers[name] = srv
+	rs.routers[name] = router
+	rs.mutex.Unlock()
+	return srv
+}
+
+func (rs *repoSet) get(name string) *server {
+	rs.mutex.RLock()
+	defer rs.mutex.RUnlock()
+	return rs.servers[name]
+}
+
+// open serves every repository in dir that isn't served yet.
+func (rs *repoSet) open() error {
+	if len(rs.dir) == 0 {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(rs.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || !validRepoName.MatchString(name) || rs.get(name) != nil {
+			continue
+		}
+		path := filepath.Join(rs.dir, name)
+		if _, err := os.Stat(filepath.Join(path, ".git")); err != nil {
+			continue
+		}
+		store, err := openGitStore(path)
+		if err != nil {
+			return fmt.Errorf("can't open repository %s: %v", name, err)
+		}
+		rs.add(name, store)
+		slog.Info("Serving repository", "repo", name, "dir", path)
+	}
+	return nil
+}
+
+// create initializes a new repository called name in dir.
+func (rs *repoSet) create(name string) (*server, error) {
+	if len(rs.dir) == 0 {
+		return nil, withStatus(http.StatusNotImplemented, errors.New("repositories can't be created without -repos-dir"))
+	}
+	if !validRepoName.MatchString(name) {
+		return nil, withStatus(http.StatusBadRequest, fmt.Errorf("invalid repository name %q", name))
+	}
+
+	// The lock is held throughout so two requests can't create the same
+	// repository.
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	path := filepath.Join(rs.dir, name)
+	if _, err := os.Stat(path); rs.servers[name] != nil || err == nil {
+		return nil, withStatus(http.StatusConflict, fmt.Errorf("repository %s already exists", name))
+	}
+	store, err := openGitStore(path)
+	if err != nil {
+		return nil, err
+	}
+	srv, router := rs.newRepo(name, store)
+	rs.servers[name] = srv
+	rs.routers[name] = router
+	return srv, nil
+}
+
+// requireRole only lets users with at least role in the top-level policy
+// through to h.
+func (rs *repoSet) requireRole(role Role, h http.HandlerFunc) http.HandlerFunc {
+	root := &server{authz: rs.authz}
+	return root.requireRole(role, h)
+}
+
+// routes adds the endpoints listing and creating repositories to r, and
+// hands everything under /repos/{repo}/ to the repository.
+func (rs *repoSet) routes(r *mux.Router) {
+	r.HandleFunc("/repos", handle(rs.listReposHandler)).Methods("GET")
+	r.HandleFunc("/repos", rs.requireRole(RoleAdmin, handle(rs.createRepoHandler))).Methods("POST")
+	r.PathPrefix("/repos/{repo}/").Handler(rs)
+}
+
+// ServeHTTP hands requests for /repos/{repo}/ to the repository.
+func (rs *repoSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["repo"]
+	rs.mutex.RLock()
+	router := rs.routers[name]
+	rs.mutex.RUnlock()
+	if router == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no repository called %s", name))
+		return
+	}
+	router.ServeHTTP(w, r)
+}
+
+// listReposHandler lists the repositories the user may read.
+func (rs *repoSet) listReposHandler(w http.ResponseWriter, r *http.Request) error {
+	rs.mutex.RLock()
+	repos := make([]RepoInfo, 0, len(rs.servers))
+	for name, srv := range rs.servers {
+		if srv.userRole(r, "") >= RoleReader {
+			repos = append(repos, RepoInfo{Name: name, Default: name == rs.defaultName})
+		}
+	}
+	rs.mutex.RUnlock()
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Name < repos[j].Name })
+	writeJSON(w, http.StatusOK, RepoListResponse{Repos: repos})
+	return nil
+}
+
+func (rs *repoSet) createRepoHandler(w http.ResponseWriter, r *http.Request) error {
+	var req CreateRepoRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Name) == 0 {
+		return withStatus(http.StatusBadRequest, errors.New("a repository name is required"))
+	}
+	if _, err := rs.create(req.Name); err != nil {
+		return err
+	}
+	requestLogger(r).Info("Created repository", "repo", req.Name)
+	writeJSON(w, http.StatusCreated, RepoInfo{Name: req.Name})
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const reposPolicy = `
+default: reader
+grants:
+  - role: admin
+    users: [vsheffer]
+repos:
+  billing:
+    default: none
+    grants:
+      - role: editor
+        users: [jdoe]
+`
+
+func TestRepoSet(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "authz.yaml")
+	os.WriteFile(policyFile, []byte(reposPolicy), 0644)
+	rs := newRepoSet("", "default")
+	var err error
+	if rs.authz, err = newAuthorizer(policyFile); err != nil {
+		t.Fatal(err)
+	}
+
+	jdoe := Identity{Name: "jdoe", Email: "jdoe@example.com"}
+	defaultStore, billingStore := newMemStore("master"), newMemStore("master")
+	defaultStore.Commit("master", jdoe, "Add accounts", map[string][]byte{"accounts.yaml": []byte("v1")})
+	billingStore.Commit("master", jdoe, "Add invoices", map[string][]byte{"invoices.yaml": []byte("v1")})
+	rs.add("default", defaultStore)
+	rs.add("billing", billingStore)
+
+	request := routed(rs.routes)
+	repoNames := func(user string) string {
+		var resp RepoListResponse
+		json.Unmarshal(request("GET", "/repos", user, nil, nil).Body.Bytes(), &resp)
+		var names []string
+		for _, repo := range resp.Repos {
+			names = append(names, repo.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	if names := repoNames("asmith"); names != "default" {
+		t.Errorf("asmith sees %s", names)
+	}
+	if names := repoNames("jdoe"); names != "billing,default" {
+		t.Errorf("jdoe sees %s", names)
+	}
+
+	w := request("GET", "/repos/billing/specfiles", "jdoe", nil, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "invoices.yaml") || strings.Contains(w.Body.String(), "accounts.yaml") {
+		t.Errorf("Got %d %s", w.Code, w.Body.String())
+	}
+	if w = request("GET", "/repos/billing/specfiles/invoices.yaml", "asmith", nil, nil); w.Code != http.StatusForbidden {
+		t.Errorf("asmith read a billing spec: %d", w.Code)
+	}
+	if w = request("GET", "/repos/default/specfiles/accounts.yaml", "asmith", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("asmith can't read a default spec: %d", w.Code)
+	}
+	expectError(t, "unknown repository", request("GET", "/repos/nope/specfiles", "jdoe", nil, nil), http.StatusNotFound)
+
+	expectError(t, "create as editor", request("POST", "/repos", "jdoe", nil, strings.NewReader(`{"name": "pets"}`)), http.StatusForbidden)
+	expectError(t, "create without -repos-dir", request("POST", "/repos", "vsheffer", nil, strings.NewReader(`{"name": "pets"}`)), http.StatusNotImplemented)
+}
+
+func TestCreateRepo(t *testing.T) {
+	rs := newRepoSet(t.TempDir(), "default")
+	request := routed(rs.routes)
+	create := func(body string) *httptest.ResponseRecorder {
+		return request("POST", "/repos", "", nil, strings.NewReader(body))
+	}
+
+	if w := create(`{"name": "pets"}`); w.Code != http.StatusCreated {
+		t.Fatalf("Got %d %s", w.Code, w.Body.String())
+	}
+	if rs.get("pets") == nil || rs.get("pets").git == nil {
+		t.Error("pets isn't served")
+	}
+	expectError(t, "existing repository", create(`{"name": "pets"}`), http.StatusConflict)
+	expectError(t, "invalid name", create(`{"name": "../pets"}`), http.StatusBadRequest)
+
+	// Repositories created earlier are served after a restart.
+	restarted := newRepoSet(rs.dir, "default")
+	if err := restarted.open(); err != nil || restarted.get("pets") == nil {
+		t.Errorf("pets wasn't opened: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/libgit2/git2go"
+	"net/http"
+	"strconv"
+)
+
+func (s *server) revertHandler(w http.ResponseWriter, r *http.Request) error {
+	branch, err := s.requestBranch(r)
+	if err != nil {
+		return err
+	}
+
+	reverted, err := s.git.resolveCommit(mux.Vars(r)["commit"])
+	if err != nil {
+		return withStatus(http.StatusNotFound, err)
+	}
+
+	// Reverting a merge needs to know which parent the result should follow.
+	var mainline uint64
+	if reverted.ParentCount() > 1 {
+		mainline = 1
+	}
+	if param := r.URL.Query().Get("mainline"); len(param) > 0 {
+		if mainline, err = strconv.ParseUint(param, 10, 32); err != nil {
+			return withStatus(http.StatusBadRequest, errors.New("mainline must be a parent number"))
+		}
+	}
+
+	s.git.mutex.Lock()
+	defer s.git.mutex.Unlock()
+
+	tip, err := s.git.branchTip(branch)
+	if err == nil && tip == nil {
+		err = errors.New("branch " + branch + " has no commits")
+	}
+	if err != nil {
+		return withStatus(http.StatusNotFound, err)
+	}
+
+	index, err := s.git.repo.RevertCommit(reverted, tip, uint(mainline), nil)
+	if err != nil {
+		return withStatus(http.StatusConflict, err)
+	}
+	defer index.Free()
+
+	if index.HasConflicts() {
+		conflicts, err := mergeConflicts(index)
+		if err != nil {
+			return err
+		}
+		writeJSON(w, http.StatusConflict, CommitResponse{
+			Status:    Error,
+			Message:   "Revert of " + reverted.Id().String() + " has conflicts.",
+			Conflicts: conflicts,
+		})
+		return nil
+	}
+
+	tipTree, err := tip.Tree()
+	var revertedTree *git.Tree
+	if err == nil {
+		var treeId *git.Oid
+		if treeId, err = index.WriteTreeTo(s.git.repo); err == nil {
+			revertedTree, err = s.git.repo.LookupTree(treeId)
+		}
+	}
+	var files map[string][]byte
+	if err == nil {
+		files, err = s.git.changedFiles(tipTree, revertedTree)
+	}
+	if err != nil {
+		return err
+	}
+	if !s.checkCompat(w, branch, files) {
+		return nil
+	}
+
+	message := fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s.\n", reverted.Summary(), reverted.Id())
+	commitId, err := s.git.commitIndex(index, branch, commitSignature(r), message, tip)
+	if err != nil {
+		return err
+	}
+
+	s.publishCommit(branch, commitId.String())
+
+	if err = s.git.checkoutIfHead(branch); err != nil {
+		requestLogger(r).Error("Can't update working tree after revert", "branch", branch, "error", err)
+	}
+	writeJSON(w, http.StatusOK, CommitResponse{
+		Status:  Success,
+		Message: "Reverted " + reverted.Id().String() + " on " + branch + ".",
+		Commit:  commitId.String(),
+	})
+	return nil
+}
+
+func (s *server) restoreFileHandler(w http.ResponseWriter, r *http.Request) error {
+	fileName := mux.Vars(r)["filename"]
+	revision := r.URL.Query().Get("revision")
+	if len(revision) == 0 {
+		return withStatus(http.StatusBadRequest, errors.New("a revision is required"))
+	}
+
+	branch, err := s.requestBranch(r)
+	if err != nil {
+		return err
+	}
+
+	commit, err := s.store.LookupCommit(revision)
+	if err != nil {
+		return withStatus(http.StatusNotFound, err)
+	}
+	fileBytes, err := s.store.ReadAt(commit.Id, fileName)
+	if err != nil {
+		return withStatus(http.StatusNotFound, err)
+	}
+
+	if !s.checkCompat(w, branch, map[string][]byte{fileName: fileBytes}) {
+		return nil
+	}
+
+	message := fmt.Sprintf("Restore %s to %s", fileName, commit.Id)
+	commitId, err := s.store.Commit(branch, requestIdentity(r), message, map[string][]byte{fileName: fileBytes})
+	if err != nil {
+		return err
+	}
+
+	s.publishCommit(branch, commitId)
+	writeJSON(w, http.StatusOK, CommitResponse{
+		Status:  Success,
+		Message: "Restored " + fileName + " to " + revision + " on " + branch + ".",
+		Commit:  commitId,
+	})
+	return nil
+}
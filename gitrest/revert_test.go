@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/vsheffer/gofun/openapi"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRevert(t *testing.T) {
+	store := newTestRepo(t)
+	added := commitTo(t, store.repo, "accounts.yaml", accountsSpec).String()
+	retitled := strings.Replace(accountsSpec, "title: Accounts", "title: Accounts API", 1)
+	updated := commitTo(t, store.repo, "accounts.yaml", retitled).String()
+	request := routed(newServer(store).routes)
+
+	var resp CommitResponse
+	w := request("POST", "/revert/"+updated, "jdoe", nil, nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Revert got %d %s", w.Code, w.Body.String())
+	}
+	if content, err := store.ReadAt("HEAD", "accounts.yaml"); err != nil || string(content) != accountsSpec {
+		t.Errorf("accounts.yaml after the revert: %q, %v", content, err)
+	}
+	commit, _ := store.LookupCommit(resp.Commit)
+	if !strings.Contains(commit.Message, "This reverts commit "+updated) || commit.Author != "jdoe" {
+		t.Errorf("Committed %+v", commit)
+	}
+
+	// accounts.yaml was changed again since it was added, so taking it out
+	// conflicts.
+	commitTo(t, store.repo, "accounts.yaml", retitled)
+	w = request("POST", "/revert/"+added, "jdoe", nil, nil)
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusConflict || strings.Join(resp.Conflicts, ",") != "accounts.yaml" {
+		t.Errorf("Conflicting revert got %d %s", w.Code, w.Body.String())
+	}
+
+	expectError(t, "unknown commit", request("POST", "/revert/0123456789abcdef0123456789abcdef01234567", "jdoe", nil, nil), http.StatusNotFound)
+	expectError(t, "unknown branch", request("POST", "/revert/"+updated+"?branch=nope", "jdoe", nil, nil), http.StatusNotFound)
+	expectError(t, "bad mainline", request("POST", "/revert/"+updated+"?mainline=first", "jdoe", nil, nil), http.StatusBadRequest)
+}
+
+func TestRestoreFile(t *testing.T) {
+	store := newMemStore("master")
+	jdoe := Identity{Name: "jdoe", Email: "jdoe@example.com"}
+	first, _ := store.Commit("master", jdoe, "Add accounts", map[string][]byte{"accounts.yaml": []byte(accountsSpec)})
+	retitled := strings.Replace(accountsSpec, "title: Accounts", "title: Accounts API", 1)
+	store.Commit("master", jdoe, "Retitle accounts, add pets", map[string][]byte{
+		"accounts.yaml": []byte(retitled),
+		"pets.yaml":     []byte(accountsSpec),
+	})
+	request := routed(newServer(store).routes)
+
+	var resp CommitResponse
+	w := request("POST", "/restore/accounts.yaml?revision="+first, "jdoe", nil, nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Restore got %d %s", w.Code, w.Body.String())
+	}
+	if content, err := store.ReadAt("master", "accounts.yaml"); err != nil || string(content) != accountsSpec {
+		t.Errorf("accounts.yaml after the restore: %q, %v", content, err)
+	}
+	commit, _ := store.LookupCommit("master")
+	if commit.Id != resp.Commit || commit.Message != "Restore accounts.yaml to "+first || commit.Author != "jdoe" {
+		t.Errorf("Committed %+v", commit)
+	}
+
+	expectError(t, "no revision", request("POST", "/restore/accounts.yaml", "jdoe", nil, nil), http.StatusBadRequest)
+	expectError(t, "unknown commit", request("POST", "/restore/accounts.yaml?revision=nope", "jdoe", nil, nil), http.StatusNotFound)
+	expectError(t, "file missing at the commit", request("POST", "/restore/pets.yaml?revision="+first, "jdoe", nil, nil), http.StatusNotFound)
+	expectError(t, "unknown branch", request("POST", "/restore/accounts.yaml?revision="+first+"&branch=nope", "jdoe", nil, nil), http.StatusNotFound)
+}
+
+func TestRestoreFileCompat(t *testing.T) {
+	store := newMemStore("master")
+	srv := newServer(store)
+	srv.compatGate = []string{"master"}
+	jdoe := Identity{Name: "jdoe", Email: "jdoe@example.com"}
+	first, _ := store.Commit("master", jdoe, "Add accounts", map[string][]byte{"accounts.yaml": []byte(accountsSpec)})
+	store.Commit("master", jdoe, "Add owners", map[string][]byte{
+		"accounts.yaml": []byte(strings.Replace(accountsSpec, "definitions:\n", "  /owners:\n    get:\n      responses:\n        200:\n          description: The owners.\ndefinitions:\n", 1)),
+	})
+
+	w := routed(srv.routes)("POST", "/restore/accounts.yaml?revision="+first, "jdoe", nil, nil)
+	var resp CompatResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusConflict ||
+		len(resp.Changes) != 1 || resp.Changes[0].Kind != openapi.PathRemoved {
+		t.Fatalf("Breaking restore got %d %s", w.Code, w.Body.String())
+	}
+	if commit, _ := store.LookupCommit("master"); commit.Message != "Add owners" {
+		t.Errorf("Committed %+v", commit)
+	}
+}
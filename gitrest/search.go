@@ -0,0 +1,419 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/libgit2/git2go"
+	"github.com/vsheffer/gofun/openapi"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// maxLineMatches caps the number of matching lines returned per file.
+const maxLineMatches = 20
+
+// minPruneBlobs is the smallest index that gets pruned.
+const minPruneBlobs = 1024
+
+type SearchMatch struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+type SearchResult struct {
+	File    string        `json:"file"`
+	Matches []SearchMatch `json:"matches"`
+}
+
+type SearchResponse struct {
+	Status  string         `json:"status"`
+	Message string         `json:"message"`
+	Ref     string         `json:"ref"`
+	Commit  string         `json:"commit"`
+	Results []SearchResult `json:"results"`
+}
+
+// indexedBlob is what the index knows about one version of a file.  Outline
+// is nil for files that aren't specs.
+type indexedBlob struct {
+	lines   []string
+	outline *openapi.Outline
+}
+
+// index indexes spec files by the id of their blob, so a file is only indexed
+// once however many commits and branches share it, and any ref can be
+// searched by looking up the blobs of its tree.  Only the blobs at the tips
+// of branches are kept for long: the others are pruned once the index has
+// doubled in size, so the index doesn't grow with history.
+type index struct {
+	mutex sync.RWMutex
+	blobs map[string]*indexedBlob
+	// terms maps every lower cased word to the blobs it occurs in.
+	terms map[string]map[string]bool
+	// pruneAt is the number of blobs at which the index is pruned next,
+	// twice what was kept the last time.  Pruning walks every branch, so
+	// that keeps its cost in proportion to how much the index grew.
+	pruneAt int
+
+	// pinned is held for reading by searches while they use the blobs of
+	// a tree, and for writing by prune, which would drop them from under
+	// the search otherwise.
+	pinned sync.RWMutex
+}
+
+func newIndex() *index {
+	return &index{
+		blobs:   make(map[string]*indexedBlob),
+		terms:   make(map[string]map[string]bool),
+		pruneAt: minPruneBlobs,
+	}
+}
+
+// words splits text into the terms it is indexed under.  Anything that isn't
+// a letter or digit separates words, so /accounts/{id} is "accounts" and "id".
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+}
+
+// add indexes the blob of repo with id unless it already is.
+func (ix *index) add(repo *git.Repository, id *git.Oid) (*indexedBlob, error) {
+	key := id.String()
+	ix.mutex.RLock()
+	doc, ok := ix.blobs[key]
+	ix.mutex.RUnlock()
+	if ok {
+		return doc, nil
+	}
+
+	blob, err := repo.LookupBlob(id)
+	if err != nil {
+		return nil, err
+	}
+	content := blob.Contents()
+	doc = &indexedBlob{lines: strings.Split(string(content), "\n")}
+	doc.outline, _ = openapi.Summarize(content)
+
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+	ix.blobs[key] = doc
+	for _, word := range words(string(content)) {
+		if ix.terms[word] == nil {
+			ix.terms[word] = make(map[string]bool)
+		}
+		ix.terms[word][key] = true
+	}
+	return doc, nil
+}
+
+// treeFiles returns the blobs of the files in tree by path, leaving out
+// hidden files and directories.
+func treeFiles(tree *git.Tree) (map[string]*git.Oid, error) {
+	files := make(map[string]*git.Oid)
+	err := tree.Walk(func(root string, entry *git.TreeEntry) int {
+		if strings.Index(entry.Name, ".") == 0 {
+			return 1
+		}
+		if entry.Type == git.ObjectBlob {
+			files[root+entry.Name] = entry.Id
+		}
+		return 0
+	})
+	return files, err
+}
+
+// addTree indexes every file in tree, a tree of repo, and returns them by
+// path.
+func (ix *index) addTree(repo *git.Repository, tree *git.Tree) (map[string]*git.Oid, error) {
+	files, err := treeFiles(tree)
+	if err != nil {
+		return nil, err
+	}
+	for path, id := range files {
+		if _, err = ix.add(repo, id); err != nil {
+			return nil, fmt.Errorf("can't index %s: %v", path, err)
+		}
+	}
+	return files, nil
+}
+
+// prune drops every blob but those in keep.
+func (ix *index) prune(keep map[string]bool) {
+	ix.pinned.Lock()
+	defer ix.pinned.Unlock()
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+	for key := range ix.blobs {
+		if !keep[key] {
+			delete(ix.blobs, key)
+		}
+	}
+	for word, keys := range ix.terms {
+		for key := range keys {
+			if !keep[key] {
+				delete(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			delete(ix.terms, word)
+		}
+	}
+	ix.pruneAt = 2 * len(ix.blobs)
+	if ix.pruneAt < minPruneBlobs {
+		ix.pruneAt = minPruneBlobs
+	}
+}
+
+// full tells whether the index has grown enough to be pruned.
+func (ix *index) full() bool {
+	ix.mutex.RLock()
+	defer ix.mutex.RUnlock()
+	return len(ix.blobs) >= ix.pruneAt
+}
+
+// withTerms returns the blobs that contain every one of terms.
+func (ix *index) withTerms(terms []string) map[string]bool {
+	ix.mutex.RLock()
+	defer ix.mutex.RUnlock()
+	var found map[string]bool
+	for _, term := range terms {
+		next := make(map[string]bool)
+		for key := range ix.terms[term] {
+			if found == nil || found[key] {
+				next[key] = true
+			}
+		}
+		found = next
+	}
+	return found
+}
+
+func (ix *index) blob(id *git.Oid) *indexedBlob {
+	ix.mutex.RLock()
+	defer ix.mutex.RUnlock()
+	return ix.blobs[id.String()]
+}
+
+// textMatches returns the lines of doc containing query, ignoring case.
+func textMatches(doc *indexedBlob, query string) []SearchMatch {
+	query = strings.ToLower(query)
+	var matches []SearchMatch
+	for i, line := range doc.lines {
+		if strings.Contains(strings.ToLower(line), query) {
+			matches = append(matches, SearchMatch{i + 1, strings.TrimSpace(line)})
+			if len(matches) == maxLineMatches {
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// structureMatches returns the paths, operations and definitions of doc that
+// the structural query asks for.  Every query given has to match something.
+func structureMatches(doc *indexedBlob, path, operationId, definition string) []SearchMatch {
+	if doc.outline == nil {
+		return nil
+	}
+	var matches []SearchMatch
+	if len(path) > 0 {
+		found := false
+		for _, p := range doc.outline.Paths {
+			if openapi.SamePath(p.Name, path) {
+				matches = append(matches, SearchMatch{p.Line, "path " + p.Name})
+				found = true
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	if len(operationId) > 0 {
+		found := false
+		for _, op := range doc.outline.Operations {
+			if op.OperationId == operationId {
+				matches = append(matches, SearchMatch{op.Line, "operation " + op.Method + " " + op.Path})
+				found = true
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	if len(definition) > 0 {
+		found := false
+		for _, d := range doc.outline.Definitions {
+			if d.Name == definition {
+				matches = append(matches, SearchMatch{d.Line, "definition " + d.Name})
+				found = true
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	return matches
+}
+
+// branchTrees calls f with the tree at the tip of every branch.
+func (s *server) branchTrees(f func(tree *git.Tree) error) error {
+	repo := s.git.repo
+	it, err := repo.NewBranchIterator(git.BranchLocal)
+	if err != nil {
+		return err
+	}
+	defer it.Free()
+	return it.ForEach(func(b *git.Branch, _ git.BranchType) error {
+		commit, err := repo.LookupCommit(b.Target())
+		if err != nil {
+			return err
+		}
+		tree, err := commit.Tree()
+		if err != nil {
+			return err
+		}
+		return f(tree)
+	})
+}
+
+// pruneIndex drops the blobs that aren't at the tip of any branch from the
+// index once it is full.
+func (s *server) pruneIndex() {
+	if !s.index.full() {
+		return
+	}
+	keep := make(map[string]bool)
+	err := s.branchTrees(func(tree *git.Tree) error {
+		files, err := treeFiles(tree)
+		for _, id := range files {
+			keep[id.String()] = true
+		}
+		return err
+	})
+	if err != nil {
+		slog.Error("Can't prune the search index", "error", err)
+		return
+	}
+	s.index.prune(keep)
+}
+
+// indexCommits indexes the tips of all branches and then every commit as it
+// is made, so that searches rarely have to wait for indexing.
+func (s *server) indexCommits() {
+	events := s.notifications.Subscribe(s.name)
+	repo := s.git.repo
+	err := s.branchTrees(func(tree *git.Tree) error {
+		_, err := s.index.addTree(repo, tree)
+		return err
+	})
+	if err != nil {
+		slog.Error("Can't index branches", "error", err)
+	}
+
+	for event := range events {
+		commit, err := s.git.resolveCommit(event.Commit)
+		if err != nil {
+			slog.Error("Can't index commit", "commit", event.Commit, "error", err)
+			continue
+		}
+		tree, err := commit.Tree()
+		if err == nil {
+			for _, file := range event.Files {
+				var entry *git.TreeEntry
+				if entry, err = tree.EntryByPath(file); err == nil {
+					_, err = s.index.add(repo, entry.Id)
+				}
+			}
+		}
+		if err != nil && !git.IsErrorCode(err, git.ErrNotFound) {
+			slog.Error("Can't index commit", "commit", event.Commit, "error", err)
+		}
+		// The files the commit replaced are only at older commits now.
+		s.pruneIndex()
+	}
+}
+
+// searchHandler finds spec files at a ref, by default the tip of the branch.
+// q finds text, path, operationId and definition find the specs defining
+// them.  Paths match whatever their parameters are named.
+func (s *server) searchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	text, path, operationId, definition := query.Get("q"), query.Get("path"), query.Get("operationId"), query.Get("definition")
+	if len(text) == 0 && len(path) == 0 && len(operationId) == 0 && len(definition) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("one of q, path, operationId or definition is required"))
+		return
+	}
+
+	ref := query.Get("ref")
+	if len(ref) == 0 {
+		branch, err := s.requestBranch(r)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		ref = "refs/heads/" + branch
+	}
+	commit, err := s.git.resolveCommit(ref)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// Searching another ref than a branch indexes files that no branch
+	// has, which aren't worth keeping.
+	if len(query.Get("ref")) > 0 {
+		defer s.pruneIndex()
+	}
+	s.index.pinned.RLock()
+	defer s.index.pinned.RUnlock()
+	files, err := s.index.addTree(s.git.repo, tree)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	var candidates map[string]bool
+	if terms := words(text); len(terms) > 0 {
+		candidates = s.index.withTerms(terms)
+	}
+	results := make([]SearchResult, 0)
+	for file, id := range files {
+		if candidates != nil && !candidates[id.String()] || s.userRole(r, file) < RoleReader {
+			continue
+		}
+		doc := s.index.blob(id)
+		var matches []SearchMatch
+		if len(path) > 0 || len(operationId) > 0 || len(definition) > 0 {
+			if matches = structureMatches(doc, path, operationId, definition); matches == nil {
+				continue
+			}
+		}
+		if len(text) > 0 {
+			lines := textMatches(doc, text)
+			if lines == nil {
+				continue
+			}
+			matches = append(matches, lines...)
+		}
+		results = append(results, SearchResult{File: file, Matches: matches})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].File < results[j].File })
+
+	writeJSON(w, http.StatusOK, SearchResponse{
+		Status:  Success,
+		Message: strconv.Itoa(len(results)) + " files found.",
+		Ref:     ref,
+		Commit:  commit.Id().String(),
+		Results: results,
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/vsheffer/gofun/openapi"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const accountsSpec = `swagger: "2.0"
+info:
+  title: Accounts
+  version: "1.0"
+paths:
+  /accounts/{accountId}:
+    get:
+      operationId: getAccount
+      responses:
+        200:
+          description: The Account.
+      parameters:
+        - name: accountId
+          in: path
+          required: true
+          type: string
+definitions:
+  Account:
+    type: object
+`
+
+func TestWords(t *testing.T) {
+	if got := words("GET /accounts/{accountId}: Account"); !reflect.DeepEqual(got, []string{"get", "accounts", "accountid", "account"}) {
+		t.Errorf("Got %q", got)
+	}
+}
+
+func TestSearchMatches(t *testing.T) {
+	outline, err := openapi.Summarize([]byte(accountsSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := &indexedBlob{lines: strings.Split(accountsSpec, "\n"), outline: outline}
+
+	if got := textMatches(doc, "the account"); !reflect.DeepEqual(got, []SearchMatch{{11, "description: The Account."}}) {
+		t.Errorf("Text matches %+v", got)
+	}
+	if got := structureMatches(doc, "/accounts/{id}", "", ""); !reflect.DeepEqual(got, []SearchMatch{{6, "path /accounts/{accountId}"}}) {
+		t.Errorf("Path matches %+v", got)
+	}
+	if got := structureMatches(doc, "", "getAccount", "Account"); len(got) != 2 {
+		t.Errorf("Operation and definition matches %+v", got)
+	}
+	if got := structureMatches(doc, "/accounts/{id}", "", "Owner"); got != nil {
+		t.Errorf("Matched a missing definition: %+v", got)
+	}
+}
+
+func TestIndexTerms(t *testing.T) {
+	ix := newIndex()
+	ix.terms["account"] = map[string]bool{"a": true, "b": true}
+	ix.terms["owner"] = map[string]bool{"b": true}
+	if got := ix.withTerms([]string{"account", "owner"}); !reflect.DeepEqual(got, map[string]bool{"b": true}) {
+		t.Errorf("Got %v", got)
+	}
+	if got := ix.withTerms([]string{"missing"}); len(got) != 0 {
+		t.Errorf("Got %v", got)
+	}
+}
+
+func TestIndexPrune(t *testing.T) {
+	ix := newIndex()
+	ix.blobs["a"] = &indexedBlob{}
+	ix.blobs["b"] = &indexedBlob{}
+	ix.terms["account"] = map[string]bool{"a": true, "b": true}
+	ix.terms["owner"] = map[string]bool{"b": true}
+	ix.pruneAt = 2
+	if !ix.full() {
+		t.Error("Not full at 2 blobs")
+	}
+	ix.prune(map[string]bool{"a": true})
+	if len(ix.blobs) != 1 || ix.blobs["a"] == nil {
+		t.Errorf("Kept %v", ix.blobs)
+	}
+	if !reflect.DeepEqual(ix.terms, map[string]map[string]bool{"account": {"a": true}}) {
+		t.Errorf("Kept %v", ix.terms)
+	}
+	if ix.full() || ix.pruneAt != minPruneBlobs {
+		t.Errorf("Prunes next at %d blobs", ix.pruneAt)
+	}
+}
+
+func TestSearchOldRef(t *testing.T) {
+	store := newTestRepo(t)
+	first := commitTo(t, store.repo, "accounts.yaml", accountsSpec).String()
+	commitTo(t, store.repo, "accounts.yaml", strings.Replace(accountsSpec, "The Account.", "The owner's account.", 1))
+	srv := newServer(store)
+	srv.index.pruneAt = 1
+	request := routed(srv.routes)
+
+	var resp SearchResponse
+	w := request("GET", "/search?q=the+account&ref="+first, "jdoe", nil, nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK || len(resp.Results) != 1 || resp.Commit != first {
+		t.Fatalf("Search got %d %s", w.Code, w.Body.String())
+	}
+	// Only the version of accounts.yaml at the tip of the branch stays
+	// indexed.
+	if len(srv.index.blobs) != 1 {
+		t.Errorf("%d blobs indexed", len(srv.index.blobs))
+	}
+	if w = request("GET", "/search?q=the+account", "jdoe", nil, nil); !strings.Contains(w.Body.String(), `"results":[]`) {
+		t.Errorf("Search at the tip got %d %s", w.Code, w.Body.String())
+	}
+}
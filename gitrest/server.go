@@ -0,0 +1,125 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// server serves the API for the spec files in one store.
+type server struct {
+	// name is the name of the repository, under /repos/.
+	name  string
+	store SpecStore
+	// git is the store when it's a Git repository, which branches, tags,
+	// merges, reverts, blame, search and sync need.  It's nil otherwise and
+	// their routes are left out.
+	git *gitStore
+
+	// notifications delivers an event for every commit to the configured
+	// webhooks and to everyone subscribed, such as /events streams.  It
+	// may be shared with other repositories.
+	notifications *notifier
+	// authz is nil when no -authz-file is given, which makes every
+	// authenticated user an admin.
+	authz *authorizer
+	// compatGate lists the branches that refuse commits breaking clients.
+	compatGate []string
+	// canonicalFormat is the format saved spec files are stored in, json or
+	// yaml.  They are stored as written when it's empty.
+	canonicalFormat string
+	// remote mirrors the repository to an upstream remote.  It is nil unless
+	// -remote-url is given.
+	remote *syncer
+	// index is the search index, for Git stores.
+	index *index
+	// changeSets holds the change sets open on the repository.
+	changeSets *changeSets
+	// mocks keeps the servers of /mock requests.
+	mocks *mockCache
+}
+
+func newServer(store SpecStore) *server {
+	s := &server{store: store, notifications: newNotifier(nil, ""), changeSets: newChangeSets(), mocks: newMockCache()}
+	if gs, ok := store.(*gitStore); ok {
+		s.git = gs
+		s.index = newIndex()
+	}
+	return s
+}
+
+// requestBranch returns the branch selected with the branch query parameter,
+// defaulting to the head branch of the store.
+func (s *server) requestBranch(r *http.Request) (string, error) {
+	head, err := s.store.HeadBranch()
+	if err != nil {
+		return "", err
+	}
+	branch := r.URL.Query().Get("branch")
+	if len(branch) == 0 || branch == head {
+		return head, nil
+	}
+	ok, err := s.store.HasBranch(branch)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", errBranchNotFound
+	}
+	return branch, nil
+}
+
+// requestRef returns the ref named with ?ref=, defaulting to the tip of the
+// branch.
+func (s *server) requestRef(r *http.Request) (string, error) {
+	if ref := r.URL.Query().Get("ref"); len(ref) > 0 {
+		return ref, nil
+	}
+	branch, err := s.requestBranch(r)
+	if err != nil {
+		return "", err
+	}
+	return "refs/heads/" + branch, nil
+}
+
+// routes adds the API to r.
+func (s *server) routes(r *mux.Router) {
+	r.HandleFunc("/specfiles", handle(s.getRepoDirListingHandler)).Methods("GET")
+	r.HandleFunc("/specfiles/{filename:.+}", s.requireFileRole(RoleReader, handle(s.getSpecFileHandler))).Methods("GET")
+	r.HandleFunc("/specfiles/{filename:.+}", s.requireFileRole(RoleEditor, handle(s.saveSpecFileHandler))).Methods("PUT")
+	r.HandleFunc("/specfiles/{filename:.+}", s.requireFileRole(RoleEditor, handle(s.deleteSpecFileHandler))).Methods("DELETE")
+	r.HandleFunc("/move/{filename:.+}", s.requireFileRole(RoleEditor, handle(s.moveSpecFileHandler))).Methods("POST")
+	r.HandleFunc("/commitfile/{filename:.+}", s.requireFileRole(RoleEditor, handle(s.commitFileHandler))).Methods("POST")
+	r.HandleFunc("/history/{filename:.+}", s.requireFileRole(RoleReader, handle(s.historyHandler))).Methods("GET")
+	r.HandleFunc("/restore/{filename:.+}", s.requireFileRole(RoleEditor, handle(s.restoreFileHandler))).Methods("POST")
+	r.HandleFunc("/compat/{filename:.+}", s.requireFileRole(RoleReader, s.compatHandler)).Methods("GET")
+	r.HandleFunc("/bundle/{filename:.+}", s.requireFileRole(RoleReader, handle(s.bundleHandler))).Methods("GET")
+	r.HandleFunc("/dependents/{filename:.+}", s.requireFileRole(RoleReader, handle(s.dependentsHandler))).Methods("GET")
+	r.HandleFunc("/codegen/{filename:.+}", s.requireFileRole(RoleReader, handle(s.codegenHandler))).Methods("GET")
+	r.HandleFunc("/docs", handle(s.docsIndexHandler)).Methods("GET")
+	r.HandleFunc("/docs/{filename:.+}", s.requireFileRole(RoleReader, handle(s.docsHandler))).Methods("GET")
+	r.HandleFunc(`/mock/{filename:.+?\.(?:yaml|yml|json)}{path:(?:/.*)?}`, s.requireFileRole(RoleReader, handle(s.mockHandler)))
+	r.HandleFunc("/archive", handle(s.archiveHandler)).Methods("GET")
+	r.HandleFunc("/import", handle(s.importHandler)).Methods("POST")
+	r.HandleFunc("/changesets", handle(s.listChangeSetsHandler)).Methods("GET")
+	r.HandleFunc("/changesets", handle(s.openChangeSetHandler)).Methods("POST")
+	r.HandleFunc("/changesets/{id}", handle(s.getChangeSetHandler)).Methods("GET")
+	r.HandleFunc("/changesets/{id}", handle(s.discardChangeSetHandler)).Methods("DELETE")
+	r.HandleFunc("/changesets/{id}/commit", handle(s.commitChangeSetHandler)).Methods("POST")
+	r.HandleFunc("/changesets/{id}/files/{filename:.+}", s.requireFileRole(RoleEditor, handle(s.stageFileHandler))).Methods("PUT")
+	r.HandleFunc("/changesets/{id}/files/{filename:.+}", s.requireFileRole(RoleEditor, handle(s.unstageFileHandler))).Methods("DELETE")
+	r.HandleFunc("/events", s.requireRole(RoleReader, s.eventsHandler)).Methods("GET")
+	if s.git != nil {
+		r.HandleFunc("/blame/{filename:.+}", s.requireFileRole(RoleReader, s.blameHandler)).Methods("GET")
+		r.HandleFunc("/branches", s.requireRole(RoleReader, handle(s.getBranchesHandler))).Methods("GET")
+		r.HandleFunc("/branches", s.requireRole(RoleEditor, handle(s.createBranchHandler))).Methods("POST")
+		r.HandleFunc("/branches/{name:.+}", s.requireRole(RoleAdmin, handle(s.deleteBranchHandler))).Methods("DELETE")
+		r.HandleFunc("/tags", s.requireRole(RoleReader, handle(s.getTagsHandler))).Methods("GET")
+		r.HandleFunc("/tags", s.requireRole(RoleAdmin, handle(s.createTagHandler))).Methods("POST")
+		r.HandleFunc("/tags/{name:.+}", s.requireRole(RoleAdmin, handle(s.deleteTagHandler))).Methods("DELETE")
+		r.HandleFunc("/merge", s.requireRole(RoleEditor, handle(s.mergeHandler))).Methods("POST")
+		r.HandleFunc("/revert/{commit}", s.requireRole(RoleEditor, handle(s.revertHandler))).Methods("POST")
+		r.HandleFunc("/search", s.searchHandler).Methods("GET")
+		r.HandleFunc("/sync", s.requireRole(RoleAdmin, s.syncHandler)).Methods("POST")
+		r.HandleFunc("/sync/status", s.requireRole(RoleReader, s.syncStatusHandler)).Methods("GET")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+var errBranchNotFound = errors.New("branch not found")
+
+// errBranchMoved is returned by CommitOn when the branch isn't at the commit
+// the caller expected any more.
+var errBranchMoved = errors.New("branch moved")
+
+// notFoundError is a missing file or ref, described more precisely than
+// os.ErrNotExist does.
+type notFoundError struct {
+	message string
+}
+
+func (e *notFoundError) Error() string {
+	return e.message
+}
+
+func (e *notFoundError) Is(target error) bool {
+	return target == os.ErrNotExist
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &notFoundError{fmt.Sprintf(format, args...)}
+}
+
+// A SpecStore keeps spec files on branches.  A file can be saved on a branch
+// before it's committed; reading it on the branch then returns the saved
+// copy, while reading it at a ref only ever returns what was committed.
+//
+// Missing files are reported with errors wrapping os.ErrNotExist and missing
+// branches with errBranchNotFound.
+type SpecStore interface {
+	// HeadBranch returns the branch used when a request doesn't name one.
+	HeadBranch() (string, error)
+	// HasBranch tells whether branch exists, even if it has no commits yet.
+	HasBranch(branch string) (bool, error)
+
+	// List returns the paths of the spec files on branch, saved or
+	// committed, sorted.
+	List(branch string) ([]string, error)
+	// Read returns the saved copy of fileName on branch, falling back to the
+	// committed one.
+	Read(branch, fileName string) ([]byte, error)
+	// ReadSaved returns the saved copy of fileName on branch only.
+	ReadSaved(branch, fileName string) ([]byte, error)
+	// ReadAt returns fileName as committed at ref, which may be a branch, a
+	// tag or a commit id.
+	ReadAt(ref, fileName string) ([]byte, error)
+	// Write saves content as fileName on branch without committing it.
+	Write(branch, fileName string, content []byte) error
+
+	// Commit records files on branch as one commit and returns its id.  A
+	// nil content deletes the file.  Saved copies of the files are dropped,
+	// since they are now committed.
+	Commit(branch string, author Identity, message string, files map[string][]byte) (string, error)
+	// CommitOn commits as Commit does, provided the tip of branch is still
+	// parent, an empty parent standing for a branch without commits.  It
+	// returns errBranchMoved otherwise.
+	CommitOn(branch, parent string, author Identity, message string, files map[string][]byte) (string, error)
+	// History returns up to limit commits on branch that changed fileName,
+	// most recent first.  It follows the file back through renames, which
+	// are commits deleting a file and adding one with the same content.
+	History(branch, fileName string, limit int) ([]CommitInfo, error)
+	// Diff returns the files that differ between the commits at two refs.
+	// An empty from stands for an empty tree.
+	Diff(from, to string) ([]FileChange, error)
+	// LookupCommit describes the commit at ref.
+	LookupCommit(ref string) (*CommitInfo, error)
+}
+
+// CommitInfo describes a commit.  Files, the paths it changed relative to its
+// first parent, is only filled in by LookupCommit.  Path, the path the file
+// had in the commit, is only filled in by History.
+type CommitInfo struct {
+	Id        string    `json:"id"`
+	Author    string    `json:"author"`
+	Email     string    `json:"email"`
+	Committer string    `json:"committer"`
+	Time      time.Time `json:"time"`
+	Message   string    `json:"message"`
+	Files     []string  `json:"files,omitempty"`
+	Path      string    `json:"path,omitempty"`
+}
+
+// Statuses of a FileChange.
+const (
+	FileAdded    = "added"
+	FileModified = "modified"
+	FileDeleted  = "deleted"
+)
+
+// FileChange is a file that differs between two commits.  Content is the new
+// content, nil for deleted files.
+type FileChange struct {
+	Path    string `json:"path"`
+	Status  string `json:"status"`
+	Content []byte `json:"-"`
+}
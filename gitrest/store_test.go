@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// testSpecStore checks the behaviour every SpecStore has to share.
+func testSpecStore(t *testing.T, store SpecStore) {
+	head, err := store.HeadBranch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.HasBranch(head); !ok || err != nil {
+		t.Errorf("HasBranch(%s) = %v, %v", head, ok, err)
+	}
+	if ok, _ := store.HasBranch("nope"); ok {
+		t.Error("HasBranch(nope) = true")
+	}
+	ref := "refs/heads/" + head
+	if _, err := store.Read(head, "specs/a.yaml"); errorStatus(err) != http.StatusNotFound {
+		t.Errorf("Read of a missing file: %v", err)
+	}
+
+	if err = store.Write(head, "specs/a.yaml", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := store.Read(head, "specs/a.yaml"); err != nil || string(content) != "v1" {
+		t.Errorf("Read %q, %v", content, err)
+	}
+	if _, err := store.ReadAt(ref, "specs/a.yaml"); errorStatus(err) != http.StatusNotFound {
+		t.Errorf("ReadAt of an uncommitted file: %v", err)
+	}
+
+	jdoe := Identity{Name: "jdoe", Email: "jdoe@example.com"}
+	first, err := store.Commit(head, jdoe, "Add a", map[string][]byte{"specs/a.yaml": []byte("v1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.Commit(head, jdoe, "Update a, add b", map[string][]byte{"specs/a.yaml": []byte("v2"), "b.yaml": []byte("v1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := store.Commit(head, jdoe, "Delete b", map[string][]byte{"b.yaml": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if content, err := store.ReadAt(first, "specs/a.yaml"); err != nil || string(content) != "v1" {
+		t.Errorf("ReadAt first %q, %v", content, err)
+	}
+	if content, err := store.ReadAt(ref, "specs/a.yaml"); err != nil || string(content) != "v2" {
+		t.Errorf("ReadAt tip %q, %v", content, err)
+	}
+	if _, err := store.ReadAt(ref, "b.yaml"); errorStatus(err) != http.StatusNotFound {
+		t.Errorf("ReadAt of a deleted file: %v", err)
+	}
+	if files, err := store.List(head); err != nil || !reflect.DeepEqual(files, []string{"specs/a.yaml"}) {
+		t.Errorf("List %q, %v", files, err)
+	}
+
+	info, err := store.LookupCommit(second)
+	if err != nil || info.Author != "jdoe" || info.Email != "jdoe@example.com" || info.Message != "Update a, add b" ||
+		!reflect.DeepEqual(info.Files, []string{"b.yaml", "specs/a.yaml"}) {
+		t.Errorf("LookupCommit %+v, %v", info, err)
+	}
+
+	history, err := store.History(head, "specs/a.yaml", 5)
+	if err != nil || len(history) != 2 || history[0].Id != second || history[1].Id != first {
+		t.Errorf("History %+v, %v", history, err)
+	}
+	if history, _ = store.History(head, "specs/a.yaml", 1); len(history) != 1 {
+		t.Errorf("History with a limit of 1 %+v", history)
+	}
+	if _, err = store.History(head, "b.yaml", 5); errorStatus(err) != http.StatusNotFound {
+		t.Errorf("History of a deleted file: %v", err)
+	}
+
+	diffs := []struct {
+		from, to string
+		changes  []FileChange
+	}{
+		{"", first, []FileChange{{"specs/a.yaml", FileAdded, []byte("v1")}}},
+		{first, third, []FileChange{{"specs/a.yaml", FileModified, []byte("v2")}}},
+		{second, third, []FileChange{{"b.yaml", FileDeleted, nil}}},
+	}
+	for _, diff := range diffs {
+		if changes, err := store.Diff(diff.from, diff.to); err != nil || !reflect.DeepEqual(changes, diff.changes) {
+			t.Errorf("Diff(%.7s, %.7s) = %+v, %v", diff.from, diff.to, changes, err)
+		}
+	}
+	renamed, err := store.Commit(head, jdoe, "Rename a", map[string][]byte{"specs/a.yaml": nil, "c.yaml": []byte("v2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err = store.History(head, "c.yaml", 5)
+	if err != nil || len(history) != 3 || history[0].Id != renamed || history[2].Id != first ||
+		history[0].Path != "c.yaml" || history[1].Path != "specs/a.yaml" {
+		t.Errorf("History across a rename %+v, %v", history, err)
+	}
+
+	if _, err = store.CommitOn(head, third, jdoe, "Stale", map[string][]byte{"c.yaml": nil}); !errors.Is(err, errBranchMoved) {
+		t.Errorf("CommitOn a stale parent: %v", err)
+	}
+	if _, err = store.CommitOn(head, renamed, jdoe, "Update c", map[string][]byte{"c.yaml": []byte("v3")}); err != nil {
+		t.Errorf("CommitOn the tip: %v", err)
+	}
+}
+
+func TestMemStore(t *testing.T) {
+	testSpecStore(t, newMemStore("master"))
+}
+
+func TestGitStore(t *testing.T) {
+	testSpecStore(t, newTestRepo(t))
+}
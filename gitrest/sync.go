@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"github.com/libgit2/git2go"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BranchConflict is a branch that can't be synced without someone deciding
+// how the local and remote histories should be combined.
+type BranchConflict struct {
+	Branch  string `json:"branch"`
+	Local   string `json:"local"`
+	Remote  string `json:"remote"`
+	Message string `json:"message"`
+}
+
+type SyncStatus struct {
+	Remote    string           `json:"remote"`
+	Url       string           `json:"url"`
+	LastSync  time.Time        `json:"lastSync"`
+	LastPush  time.Time        `json:"lastPush"`
+	Error     string           `json:"error,omitempty"`
+	Conflicts []BranchConflict `json:"conflicts"`
+}
+
+type syncer struct {
+	server     *server
+	git        *gitStore
+	remoteName string
+	url        string
+	username   string
+	password   string
+
+	// running serializes fetches and pushes, mutex guards the status.
+	running   sync.Mutex
+	mutex     sync.Mutex
+	status    SyncStatus
+	conflicts map[string]BranchConflict
+}
+
+// newSyncer returns a syncer for the Git store of srv, which announces the
+// commits it pulls.
+func newSyncer(srv *server, remoteName, url, username, password string) *syncer {
+	return &syncer{
+		server:     srv,
+		git:        srv.git,
+		remoteName: remoteName,
+		url:        url,
+		username:   username,
+		password:   password,
+		status:     SyncStatus{Remote: remoteName, Url: url},
+		conflicts:  make(map[string]BranchConflict),
+	}
+}
+
+// setup makes sure the repository has the remote, pointing at the right URL.
+func (s *syncer) setup() error {
+	remote, err := s.git.repo.Remotes.Lookup(s.remoteName)
+	if err != nil {
+		remote, err = s.git.repo.Remotes.Create(s.remoteName, s.url)
+		if err != nil {
+			return err
+		}
+	} else if remote.Url() != s.url {
+		if err = s.git.repo.Remotes.SetUrl(s.remoteName, s.url); err != nil {
+			return err
+		}
+	}
+	remote.Free()
+	return nil
+}
+
+func (s *syncer) callbacks() git.RemoteCallbacks {
+	return git.RemoteCallbacks{
+		CredentialsCallback: func(url string, usernameFromURL string, allowedTypes git.CredType) (*git.Cred, error) {
+			if len(s.username) == 0 {
+				return git.NewCredSshKeyFromAgent(usernameFromURL)
+			}
+			return git.NewCredUserpassPlaintext(s.username, s.password)
+		},
+	}
+}
+
+// Status returns a snapshot of how the last sync went.
+func (s *syncer) Status() SyncStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	status := s.status
+	status.Conflicts = make([]BranchConflict, 0, len(s.conflicts))
+	for _, conflict := range s.conflicts {
+		status.Conflicts = append(status.Conflicts, conflict)
+	}
+	sort.Slice(status.Conflicts, func(i, j int) bool {
+		return status.Conflicts[i].Branch < status.Conflicts[j].Branch
+	})
+	return status
+}
+
+func (s *syncer) setConflict(branch string, conflict *BranchConflict) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if conflict == nil {
+		delete(s.conflicts, branch)
+	} else {
+		s.conflicts[branch] = *conflict
+	}
+}
+
+// Sync fetches from the remote, fast-forwards local branches that are behind
+// and pushes the ones that are ahead.  Branches that have diverged are left
+// alone and reported as conflicts.
+func (s *syncer) Sync() error {
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	err := s.sync()
+	s.mutex.Lock()
+	s.status.LastSync = time.Now()
+	s.status.Error = ""
+	if err != nil {
+		s.status.Error = err.Error()
+	}
+	s.mutex.Unlock()
+	return err
+}
+
+func (s *syncer) sync() error {
+	remote, err := s.git.repo.Remotes.Lookup(s.remoteName)
+	if err != nil {
+		return err
+	}
+	defer remote.Free()
+
+	refspecs := []string{
+		"+refs/heads/*:refs/remotes/" + s.remoteName + "/*",
+		"refs/tags/*:refs/tags/*",
+	}
+	err = remote.Fetch(refspecs, &git.FetchOptions{RemoteCallbacks: s.callbacks()}, "gitrest sync")
+	if err != nil {
+		return err
+	}
+
+	remoteBranches, err := s.remoteBranches()
+	if err != nil {
+		return err
+	}
+	for branch, remoteId := range remoteBranches {
+		if err = s.pull(branch, remoteId); err != nil {
+			slog.Error("Can't sync branch", "branch", branch, "error", err)
+		}
+	}
+
+	it, err := s.git.repo.NewBranchIterator(git.BranchLocal)
+	if err != nil {
+		return err
+	}
+	defer it.Free()
+	var ahead []string
+	err = it.ForEach(func(b *git.Branch, _ git.BranchType) error {
+		name, err := b.Name()
+		if err != nil {
+			return err
+		}
+		remoteId := remoteBranches[name]
+		if remoteId == nil {
+			ahead = append(ahead, name)
+		} else if base, err := s.git.repo.MergeBase(b.Target(), remoteId); err == nil && base.Equal(remoteId) && !remoteId.Equal(b.Target()) {
+			ahead = append(ahead, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, branch := range ahead {
+		if err = s.push(remote, branch); err != nil {
+			slog.Error("Can't push branch", "branch", branch, "error", err)
+		}
+	}
+	return nil
+}
+
+// remoteBranches returns the tips of the branches fetched from the remote.
+func (s *syncer) remoteBranches() (map[string]*git.Oid, error) {
+	prefix := "refs/remotes/" + s.remoteName + "/"
+	it, err := s.git.repo.NewReferenceIteratorGlob(prefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	defer it.Free()
+
+	branches := make(map[string]*git.Oid)
+	for {
+		ref, err := it.Next()
+		if git.IsErrorCode(err, git.ErrIterOver) {
+			return branches, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if branch := strings.TrimPrefix(ref.Name(), prefix); branch != "HEAD" {
+			branches[branch] = ref.Target()
+		}
+	}
+}
+
+// pull brings a local branch up to date with the remote one, if that can be
+// done by fast-forwarding.
+func (s *syncer) pull(branch string, remoteId *git.Oid) error {
+	s.git.mutex.Lock()
+	defer s.git.mutex.Unlock()
+
+	refName := "refs/heads/" + branch
+	local, err := s.git.repo.LookupBranch(branch, git.BranchLocal)
+	if err != nil {
+		if !git.IsErrorCode(err, git.ErrNotFound) {
+			return err
+		}
+		if _, err = s.git.repo.References.Create(refName, remoteId, false, "sync: created from "+s.remoteName); err != nil {
+			return err
+		}
+		return s.git.checkoutIfHead(branch)
+	}
+
+	localId := local.Target()
+	if localId.Equal(remoteId) {
+		s.setConflict(branch, nil)
+		return nil
+	}
+	base, err := s.git.repo.MergeBase(localId, remoteId)
+	switch {
+	case err != nil:
+		s.setConflict(branch, &BranchConflict{branch, localId.String(), remoteId.String(), "the local and remote branches have no common history"})
+	case base.Equal(remoteId):
+		// Ahead of the remote, sync pushes it next.
+	case base.Equal(localId):
+		if _, err = s.git.repo.References.Create(refName, remoteId, true, "sync: fast-forward from "+s.remoteName); err != nil {
+			return err
+		}
+		s.setConflict(branch, nil)
+		s.server.publishCommit(branch, remoteId.String())
+		return s.git.checkoutIfHead(branch)
+	default:
+		s.setConflict(branch, &BranchConflict{branch, localId.String(), remoteId.String(), "the local and remote branches have diverged"})
+	}
+	return nil
+}
+
+// Push pushes branch to the remote.
+func (s *syncer) Push(branch string) error {
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	remote, err := s.git.repo.Remotes.Lookup(s.remoteName)
+	if err != nil {
+		return err
+	}
+	defer remote.Free()
+	return s.push(remote, branch)
+}
+
+func (s *syncer) push(remote *git.Remote, branch string) error {
+	var rejection string
+	callbacks := s.callbacks()
+	callbacks.PushUpdateReferenceCallback = func(refName, status string) git.ErrorCode {
+		rejection = status
+		return git.ErrOk
+	}
+
+	refName := "refs/heads/" + branch
+	err := remote.Push([]string{refName + ":" + refName}, &git.PushOptions{RemoteCallbacks: callbacks})
+	if err == nil && len(rejection) > 0 {
+		err = errors.New(rejection)
+	}
+	if err != nil {
+		local, _ := s.git.repo.LookupBranch(branch, git.BranchLocal)
+		conflict := &BranchConflict{Branch: branch, Message: "push rejected: " + err.Error()}
+		if local != nil {
+			conflict.Local = local.Target().String()
+		}
+		s.setConflict(branch, conflict)
+		return err
+	}
+
+	s.setConflict(branch, nil)
+	s.mutex.Lock()
+	s.status.LastPush = time.Now()
+	s.mutex.Unlock()
+	return nil
+}
+
+// Run syncs every interval and pushes each branch as soon as something is
+// committed to it.
+func (s *syncer) Run(interval time.Duration) {
+	events := s.server.notifications.Subscribe(s.server.name)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case event := <-events:
+			if err := s.Push(event.Branch); err != nil {
+				slog.Error("Can't push branch", "branch", event.Branch, "error", err)
+			}
+		case <-ticker.C:
+			if err := s.Sync(); err != nil {
+				slog.Error("Sync failed", "url", s.url, "error", err)
+			}
+		}
+	}
+}
+
+func (s *server) syncStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if s.remote == nil {
+		writeError(w, http.StatusNotFound, errors.New("no remote is configured"))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.remote.Status())
+}
+
+// syncHandler syncs right away instead of waiting for the next interval.
+func (s *server) syncHandler(w http.ResponseWriter, r *http.Request) {
+	if s.remote == nil {
+		writeError(w, http.StatusNotFound, errors.New("no remote is configured"))
+		return
+	}
+	status := http.StatusOK
+	if err := s.remote.Sync(); err != nil {
+		status = http.StatusBadGateway
+	}
+	writeJSON(w, status, s.remote.Status())
+}
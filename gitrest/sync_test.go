@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/libgit2/git2go"
+	"testing"
+)
+
+func TestSync(t *testing.T) {
+	store := newTestRepo(t)
+	remote, err := git.InitRepository(t.TempDir(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Free()
+	branch, _ := store.HeadBranch()
+
+	s := newSyncer(newServer(store), "origin", remote.Path(), "", "")
+	if err = s.setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A branch the remote doesn't have is pushed.
+	local := commitTo(t, store.repo, "pets.yaml", "v1")
+	if err = s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := remote.References.Lookup("refs/heads/" + branch)
+	if err != nil || !ref.Target().Equal(local) {
+		t.Fatalf("Remote branch not pushed: %+v", err)
+	}
+
+	// A commit made upstream is fast-forwarded.
+	upstream := commitTo(t, remote, "pets.yaml", "v2")
+	if err = s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if tip, _ := store.branchTip(branch); tip == nil || !tip.Id().Equal(upstream) {
+		t.Fatalf("Local branch not fast-forwarded to %s", upstream)
+	}
+
+	// Diverged branches are reported, not merged.
+	commitTo(t, remote, "pets.yaml", "v3")
+	commitTo(t, store.repo, "pets.yaml", "v4")
+	if err = s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	status := s.Status()
+	if len(status.Conflicts) != 1 || status.Conflicts[0].Branch != branch {
+		t.Errorf("Expected a conflict on %s, got %+v", branch, status.Conflicts)
+	}
+	if err = s.Push(branch); err == nil {
+		t.Error("Expected the push to be rejected")
+	}
+}
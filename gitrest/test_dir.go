@@ -1,7 +1,11 @@
+//go:build ignore
+// +build ignore
+
+// test_dir lists the files of a spec directory.  Run it with go run.
 package main
 
 import (
-	"io/ioutil",
+	"io/ioutil"
 	"fmt"
 )
 
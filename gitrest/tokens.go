@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/vsheffer/gofun/util"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token scopes.  A read token can only do what a reader can, a write token
+// can do whatever its user's role allows.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// tokenPrefix starts every API token, which tells them apart from JWTs.
+const tokenPrefix = "gitrest_"
+
+// Token describes an API token.  Only the SHA-256 hash of the token itself is
+// kept, the token is shown once when it's issued.
+type Token struct {
+	Id          string     `json:"id"`
+	User        string     `json:"user"`
+	Scope       string     `json:"scope"`
+	Description string     `json:"description,omitempty"`
+	Created     time.Time  `json:"created"`
+	Expires     *time.Time `json:"expires,omitempty"`
+	Hash        string     `json:"hash,omitempty"`
+}
+
+func (t *Token) expired(now time.Time) bool {
+	return t.Expires != nil && !now.Before(*t.Expires)
+}
+
+type CreateTokenRequest struct {
+	User        string `json:"user"`
+	Scope       string `json:"scope"`
+	Description string `json:"description"`
+	// ExpiresIn is a duration such as "720h".  Tokens without one never
+	// expire.
+	ExpiresIn string `json:"expiresIn"`
+}
+
+type CreateTokenResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Token   string `json:"token"`
+	Info    Token  `json:"info"`
+}
+
+type TokenListResponse struct {
+	Tokens []Token `json:"tokens"`
+}
+
+// tokens holds the API tokens issued so far.  It is nil when tokens are
+// turned off.
+var tokens *tokenStore
+
+// tokenStore keeps API tokens in a JSON file.
+type tokenStore struct {
+	path   string
+	mutex  sync.RWMutex
+	byHash map[string]*Token
+}
+
+// newTokenStore loads the tokens in path.  A missing file is created when the
+// first token is issued.
+func newTokenStore(path string) (*tokenStore, error) {
+	s := &tokenStore{path: path, byHash: make(map[string]*Token)}
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*Token
+	if err = json.Unmarshal(bytes, &list); err != nil {
+		return nil, err
+	}
+	for _, t := range list {
+		s.byHash[t.Hash] = t
+	}
+	return s, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// save writes the tokens to a temporary file first, so that a crash never
+// leaves a truncated file behind.  The caller holds the write lock.
+func (s *tokenStore) save() error {
+	list := make([]*Token, 0, len(s.byHash))
+	for _, t := range s.byHash {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	bytes, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(s.path+".tmp", bytes, 0600); err != nil {
+		return err
+	}
+	return os.Rename(s.path+".tmp", s.path)
+}
+
+// Issue creates a token for user and returns it along with its description.
+func (s *tokenStore) Issue(user, scope, description string, expires *time.Time) (string, Token, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", Token{}, err
+	}
+	guid, err := util.NewGuid()
+	if err != nil {
+		return "", Token{}, err
+	}
+	token := tokenPrefix + hex.EncodeToString(secret)
+	t := &Token{
+		Id:          guid.String(),
+		User:        user,
+		Scope:       scope,
+		Description: description,
+		Created:     time.Now().UTC(),
+		Expires:     expires,
+		Hash:        hashToken(token),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.byHash[t.Hash] = t
+	if err = s.save(); err != nil {
+		delete(s.byHash, t.Hash)
+		return "", Token{}, err
+	}
+	info := *t
+	info.Hash = ""
+	return token, info, nil
+}
+
+// Check returns the token matching the one presented, unless it's unknown or
+// has expired.
+func (s *tokenStore) Check(token string) (Token, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	t, ok := s.byHash[hashToken(token)]
+	if !ok {
+		return Token{}, errors.New("unknown token")
+	}
+	if t.expired(time.Now()) {
+		return Token{}, errors.New("token expired")
+	}
+	return *t, nil
+}
+
+// List returns every token without its hash, oldest first.
+func (s *tokenStore) List() []Token {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	list := make([]Token, 0, len(s.byHash))
+	for _, t := range s.byHash {
+		info := *t
+		info.Hash = ""
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+// Revoke deletes the token with id, reporting whether there was one.
+func (s *tokenStore) Revoke(id string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for hash, t := range s.byHash {
+		if t.Id == id {
+			delete(s.byHash, hash)
+			return true, s.save()
+		}
+	}
+	return false, nil
+}
+
+func checkScope(scope string) error {
+	if scope != ScopeRead && scope != ScopeWrite {
+		return fmt.Errorf("scope must be %s or %s", ScopeRead, ScopeWrite)
+	}
+	return nil
+}
+
+// createTokenHandler issues a token.  Admins can issue tokens for other users
+// only when an authorization policy names them; without one, where every user
+// is an admin, users can only issue tokens for themselves.
+func (rs *repoSet) createTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	user := authenticatedUser(r)
+	if len(req.User) == 0 {
+		req.User = user
+	}
+	if req.User != user && rs.authz == nil {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%s can't issue tokens for %s without an authorization policy", user, req.User))
+		return
+	}
+	if len(req.Scope) == 0 {
+		req.Scope = ScopeRead
+	}
+	if err := checkScope(req.Scope); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var expires *time.Time
+	if len(req.ExpiresIn) > 0 {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid expiresIn %q", req.ExpiresIn))
+			return
+		}
+		t := time.Now().UTC().Add(d)
+		expires = &t
+	}
+
+	token, info, err := tokens.Issue(req.User, req.Scope, strings.TrimSpace(req.Description), expires)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, CreateTokenResponse{
+		Status:  Success,
+		Message: "Token " + info.Id + " issued to " + info.User + ".",
+		Token:   token,
+		Info:    info,
+	})
+}
+
+func getTokensHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, TokenListResponse{Tokens: tokens.List()})
+}
+
+func deleteTokenHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	found, err := tokens.Revoke(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, errors.New("no token "+id))
+		return
+	}
+	writeJSON(w, http.StatusOK, Response{Status: Success, Message: "Token " + id + " revoked."})
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/vsheffer/gofun/openapi"
+	"net/http"
+)
+
+type ValidationResponse struct {
+	Status  string                    `json:"status"`
+	Message string                    `json:"message"`
+	Errors  []openapi.ValidationError `json:"errors,omitempty"`
+}
+
+// validateSpec checks content before it is saved or committed as fileName.
+// Only documents declaring swagger, openapi, info or paths have to be
+// complete specs; fragments they refer to only have to be YAML or JSON
+// mappings.  It answers the request itself and returns false when the
+// content is invalid, or when the request only asked for validation with
+// ?validate=only.
+func validateSpec(w http.ResponseWriter, r *http.Request, fileName string, content []byte) bool {
+	if errs := openapi.ValidateFile(content); len(errs) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, ValidationResponse{
+			Status:  Error,
+			Message: fileName + " is not a valid Swagger or OpenAPI spec.",
+			Errors:  errs,
+		})
+		return false
+	}
+	if r.URL.Query().Get("validate") == "only" {
+		writeJSON(w, http.StatusOK, ValidationResponse{Status: Success, Message: fileName + " is valid."})
+		return false
+	}
+	return true
+}
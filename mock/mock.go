@@ -0,0 +1,365 @@
+// Package mock serves a fake implementation of the operations of a Swagger
+// 2.0 or OpenAPI 3 document, so clients can be developed before the real
+// implementation exists.
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/vsheffer/gofun/openapi"
+	"gopkg.in/yaml.v3"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Server answers the requests for the operations of a document with their
+// examples, or with sample data made up from their schemas, once the
+// requests have been checked against the document.  The Prefer header picks
+// another response than the first successful one, as in "Prefer: code=404",
+// and a named example, as in "Prefer: example=sold".  A Server is safe for
+// concurrent use.
+type Server struct {
+	doc      map[string]interface{}
+	basePath string
+	routes   []route
+}
+
+// route matches the paths of requests for a path of the document.
+type route struct {
+	pattern *regexp.Regexp
+	// names are the names of the parameters in the path, in order.
+	names []string
+	item  map[string]interface{}
+}
+
+// Error is the body of the responses to requests the document doesn't
+// allow.
+type Error struct {
+	Status  string   `json:"status"`
+	Message string   `json:"message"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+var pathParam = regexp.MustCompile(`{([^}]+)}`)
+
+// New returns a Server for the document in data.  References to other files
+// have to be resolved beforehand, with openapi.Bundle.
+func New(data []byte) (*Server, error) {
+	converted, err := openapi.ConvertToOpenAPI3(data)
+	if err != nil {
+		return nil, err
+	}
+	var root yaml.Node
+	if err = yaml.Unmarshal(converted, &root); err != nil {
+		return nil, err
+	}
+	doc, ok := value(&root).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the document isn't a mapping")
+	}
+
+	s := &Server{doc: doc}
+	if servers, _ := doc["servers"].([]interface{}); len(servers) > 0 {
+		if server, ok := servers[0].(map[string]interface{}); ok {
+			if u, err := url.Parse(fmt.Sprint(server["url"])); err == nil {
+				s.basePath = strings.TrimSuffix(u.Path, "/")
+			}
+		}
+	}
+	paths, _ := doc["paths"].(map[string]interface{})
+	for path, item := range paths {
+		item, ok := s.resolve(item).(map[string]interface{})
+		if !ok {
+			continue
+		}
+		rt := route{item: item}
+		pattern := "^"
+		rest := path
+		for _, match := range pathParam.FindAllStringSubmatchIndex(path, -1) {
+			offset := len(path) - len(rest)
+			pattern += regexp.QuoteMeta(rest[:match[0]-offset]) + "([^/]+)"
+			rt.names = append(rt.names, path[match[2]:match[3]])
+			rest = path[match[1]:]
+		}
+		rt.pattern = regexp.MustCompile(pattern + regexp.QuoteMeta(rest) + "$")
+		s.routes = append(s.routes, rt)
+	}
+	// Paths without parameters win over templated ones matching the same
+	// requests.
+	sort.SliceStable(s.routes, func(i, j int) bool {
+		if len(s.routes[i].names) != len(s.routes[j].names) {
+			return len(s.routes[i].names) < len(s.routes[j].names)
+		}
+		return s.routes[i].pattern.String() < s.routes[j].pattern.String()
+	})
+	return s, nil
+}
+
+// value turns a YAML node into the values encoding/json decodes to, with
+// every number a float64.
+func value(n *yaml.Node) interface{} {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) > 0 {
+			return value(n.Content[0])
+		}
+	case yaml.AliasNode:
+		return value(n.Alias)
+	case yaml.MappingNode:
+		m := make(map[string]interface{}, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			m[n.Content[i].Value] = value(n.Content[i+1])
+		}
+		return m
+	case yaml.SequenceNode:
+		list := make([]interface{}, 0, len(n.Content))
+		for _, c := range n.Content {
+			list = append(list, value(c))
+		}
+		return list
+	case yaml.ScalarNode:
+		switch n.Tag {
+		case "!!null":
+			return nil
+		case "!!int", "!!float":
+			if f, err := strconv.ParseFloat(strings.ReplaceAll(n.Value, "_", ""), 64); err == nil {
+				return f
+			}
+		case "!!bool":
+			var b bool
+			if n.Decode(&b) == nil {
+				return b
+			}
+		}
+		return n.Value
+	}
+	return nil
+}
+
+// resolve follows v while it's a reference within the document.
+func (s *Server) resolve(v interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return v
+		}
+		v = s.lookup(ref)
+	}
+	return nil
+}
+
+// lookup returns what a reference within the document points to, or nil.
+func (s *Server) lookup(ref string) interface{} {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var v interface{} = s.doc
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		if unescaped, err := url.PathUnescape(token); err == nil {
+			token = unescaped
+		}
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[token]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	if len(s.basePath) > 0 && strings.HasPrefix(path, s.basePath+"/") {
+		path = strings.TrimPrefix(path, s.basePath)
+	}
+	var rt *route
+	var params map[string]string
+	for i := range s.routes {
+		if match := s.routes[i].pattern.FindStringSubmatch(path); match != nil {
+			rt = &s.routes[i]
+			params = make(map[string]string)
+			for j, name := range rt.names {
+				params[name] = match[j+1]
+			}
+			break
+		}
+	}
+	if rt == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("No path of the spec matches %s.", path), nil)
+		return
+	}
+	op, ok := s.resolve(rt.item[strings.ToLower(r.Method)]).(map[string]interface{})
+	if !ok {
+		var allowed []string
+		for _, method := range []string{"GET", "PUT", "POST", "DELETE", "OPTIONS", "HEAD", "PATCH", "TRACE"} {
+			if rt.item[strings.ToLower(method)] != nil {
+				allowed = append(allowed, method)
+			}
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%s isn't an operation of %s.", r.Method, path), nil)
+		return
+	}
+
+	if status, errs := s.checkRequest(r, rt, op, params); len(errs) > 0 {
+		writeError(w, status, fmt.Sprintf("The request doesn't match %s %s.", r.Method, path), errs)
+		return
+	}
+	s.respond(w, r, op)
+}
+
+func writeError(w http.ResponseWriter, status int, message string, errs []string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Error{Status: "error", Message: message, Errors: errs})
+}
+
+// preferences returns the settings of the Prefer header of r.
+func preferences(r *http.Request) map[string]string {
+	prefs := make(map[string]string)
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.FieldsFunc(header, func(r rune) bool { return r == ',' || r == ';' }) {
+			if key, value, ok := strings.Cut(strings.TrimSpace(pref), "="); ok {
+				prefs[strings.ToLower(key)] = strings.Trim(value, `"`)
+			}
+		}
+	}
+	return prefs
+}
+
+// respond writes the response of op: the one with the status code asked
+// for, or the first successful one.
+func (s *Server) respond(w http.ResponseWriter, r *http.Request, op map[string]interface{}) {
+	responses, _ := op["responses"].(map[string]interface{})
+	prefs := preferences(r)
+	code := prefs["code"]
+	if _, ok := responses[code]; !ok {
+		codes := make([]string, 0, len(responses))
+		for c := range responses {
+			codes = append(codes, c)
+		}
+		sort.Strings(codes)
+		code = ""
+		for _, c := range codes {
+			if strings.HasPrefix(c, "2") {
+				code = c
+				break
+			}
+		}
+		if len(code) == 0 && responses["default"] != nil {
+			code = "default"
+		}
+	}
+	status := http.StatusOK
+	if n, err := fmt.Sscanf(strings.ReplaceAll(strings.ToUpper(code), "XX", "00"), "%d", &status); n != 1 || err != nil {
+		status = http.StatusOK
+	}
+	resp, _ := s.resolve(responses[code]).(map[string]interface{})
+
+	headers, _ := resp["headers"].(map[string]interface{})
+	for name, header := range headers {
+		header, _ := s.resolve(header).(map[string]interface{})
+		v, ok := header["example"]
+		if !ok {
+			schema, _ := header["schema"].(map[string]interface{})
+			v = s.sample(schema, nil)
+		}
+		if v != nil {
+			w.Header().Set(name, fmt.Sprint(v))
+		}
+	}
+
+	content, _ := resp["content"].(map[string]interface{})
+	contentType := negotiate(r, content)
+	media, _ := content[contentType].(map[string]interface{})
+	if media == nil || r.Method == "HEAD" {
+		w.WriteHeader(status)
+		return
+	}
+	body := s.example(media, prefs["example"])
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	if str, ok := body.(string); ok && !isJSON(contentType) {
+		fmt.Fprint(w, str)
+		return
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+// negotiate picks the content type of a response among those the document
+// lists: the first accepted by the request, application/json or the first
+// in order.
+func negotiate(r *http.Request, content map[string]interface{}) string {
+	contentTypes := make([]string, 0, len(content))
+	for contentType := range content {
+		contentTypes = append(contentTypes, contentType)
+	}
+	sort.Strings(contentTypes)
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		accepted, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil || accepted == "*/*" {
+			continue
+		}
+		for _, contentType := range contentTypes {
+			if matches(accepted, contentType) {
+				return contentType
+			}
+		}
+	}
+	if content["application/json"] != nil {
+		return "application/json"
+	}
+	if len(contentTypes) > 0 {
+		return contentTypes[0]
+	}
+	return ""
+}
+
+// matches tells whether a content type matches a media range, such as
+// application/* or */*.
+func matches(contentType, mediaRange string) bool {
+	if mediaRange == "*/*" || contentType == mediaRange {
+		return true
+	}
+	prefix := strings.TrimSuffix(mediaRange, "*")
+	return strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(contentType, prefix)
+}
+
+func isJSON(contentType string) bool {
+	return contentType == "application/json" || strings.HasSuffix(contentType, "+json")
+}
+
+// example returns the example of a media type called name, its first
+// example or sample data made up from its schema.
+func (s *Server) example(media map[string]interface{}, name string) interface{} {
+	examples, _ := media["examples"].(map[string]interface{})
+	if example, ok := s.resolve(examples[name]).(map[string]interface{}); ok {
+		return example["value"]
+	}
+	if example, ok := media["example"]; ok {
+		return example
+	}
+	names := make([]string, 0, len(examples))
+	for name := range examples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if example, ok := s.resolve(examples[name]).(map[string]interface{}); ok {
+			return example["value"]
+		}
+	}
+	schema, _ := media["schema"].(map[string]interface{})
+	return s.sample(schema, nil)
+}
@@ -0,0 +1,280 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const petstore = `openapi: 3.0.3
+info:
+  title: Petstore
+  version: "1.0"
+servers:
+  - url: https://pets.example.com/v1
+paths:
+  /pets:
+    get:
+      parameters:
+        - name: limit
+          in: query
+          schema:
+            type: integer
+            maximum: 100
+        - name: tags
+          in: query
+          explode: false
+          schema:
+            type: array
+            items:
+              type: string
+              enum: [dog, cat]
+      responses:
+        "200":
+          description: The pets.
+          content:
+            application/json:
+              example:
+                - id: 1
+                  name: Rex
+    post:
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Pet'
+      responses:
+        "201":
+          description: The pet.
+          content:
+            application/json:
+              examples:
+                rex:
+                  value: {id: 1, name: Rex}
+                tom:
+                  $ref: '#/components/examples/Tom'
+  /pets/mine:
+    get:
+      responses:
+        "204":
+          description: No pets.
+  /pets/{petId}:
+    parameters:
+      - name: petId
+        in: path
+        required: true
+        schema:
+          type: integer
+    get:
+      responses:
+        "200":
+          description: The pet.
+          headers:
+            X-Rate-Limit:
+              schema:
+                type: integer
+                minimum: 10
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Pet'
+        "404":
+          description: No such pet.
+          content:
+            application/json:
+              schema:
+                type: object
+                properties:
+                  message:
+                    type: string
+                    example: Not found
+    delete:
+      parameters:
+        - name: X-Request-Id
+          in: header
+          required: true
+          schema:
+            type: string
+            format: uuid
+      responses:
+        "204":
+          description: Deleted.
+  /tree:
+    get:
+      responses:
+        "200":
+          description: A tree.
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Node'
+components:
+  examples:
+    Tom:
+      value: {id: 2, name: Tom}
+  schemas:
+    Pet:
+      type: object
+      additionalProperties: false
+      required: [id, name]
+      properties:
+        id:
+          type: integer
+          format: int64
+          readOnly: true
+        name:
+          type: string
+          minLength: 2
+        born:
+          type: string
+          format: date-time
+        status:
+          type: string
+          enum: [available, sold]
+        secret:
+          type: string
+          writeOnly: true
+    Node:
+      type: object
+      properties:
+        name:
+          type: string
+        children:
+          type: array
+          items:
+            $ref: '#/components/schemas/Node'
+`
+
+func TestServer(t *testing.T) {
+	s, err := New([]byte(petstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method, target, body string
+		header               map[string]string
+		status               int
+		want                 string
+	}{
+		{"GET", "/pets?limit=2", "", nil, http.StatusOK, `[{"id":1,"name":"Rex"}]`},
+		{"GET", "/v1/pets?tags=dog,cat", "", nil, http.StatusOK, `[{"id":1,"name":"Rex"}]`},
+		{"GET", "/pets/7", "", nil, http.StatusOK, `{"born":"2006-01-02T15:04:05Z","id":0,"name":"string","status":"available"}`},
+		{"GET", "/pets/7", "", map[string]string{"Prefer": "code=404"}, http.StatusNotFound, `{"message":"Not found"}`},
+		{"GET", "/pets/mine", "", nil, http.StatusNoContent, ``},
+		{"GET", "/tree", "", nil, http.StatusOK, `{"children":[],"name":"string"}`},
+		{"POST", "/pets", `{"name": "Tom"}`, map[string]string{"Content-Type": "application/json"}, http.StatusCreated, `{"id":1,"name":"Rex"}`},
+		{"POST", "/pets", `{"name": "Tom"}`, map[string]string{"Content-Type": "application/json", "Prefer": "example=tom"}, http.StatusCreated, `{"id":2,"name":"Tom"}`},
+		{"DELETE", "/pets/7", "", map[string]string{"X-Request-Id": "3fa85f64-5717-4562-b3fc-2c963f66afa6"}, http.StatusNoContent, ``},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		for key, value := range test.header {
+			req.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if got := strings.TrimSpace(w.Body.String()); w.Code != test.status || got != test.want {
+			t.Errorf("%s %s got %d %s, want %d %s", test.method, test.target, w.Code, got, test.status, test.want)
+		}
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	s, err := New([]byte(petstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method, target, body string
+		header               map[string]string
+		status               int
+		errs                 []string
+	}{
+		{"GET", "/owners", "", nil, http.StatusNotFound, nil},
+		{"PUT", "/pets", "", nil, http.StatusMethodNotAllowed, nil},
+		{"GET", "/pets?limit=abc", "", nil, http.StatusBadRequest, []string{`query parameter limit should be a number, not "abc"`}},
+		{"GET", "/pets?limit=500&tags=dog,bird", "", nil, http.StatusBadRequest, []string{
+			"query parameter limit should be at most 100",
+			"query parameter tags[1] should be one of dog, cat",
+		}},
+		{"GET", "/pets/rex", "", nil, http.StatusBadRequest, []string{`path parameter petId should be a number, not "rex"`}},
+		{"DELETE", "/pets/7", "", nil, http.StatusBadRequest, []string{"header parameter X-Request-Id is required"}},
+		{"DELETE", "/pets/7", "", map[string]string{"X-Request-Id": "42"}, http.StatusBadRequest, []string{`header parameter X-Request-Id should be a uuid, not "42"`}},
+		{"POST", "/pets", "", nil, http.StatusBadRequest, []string{"the body is required"}},
+		{"POST", "/pets", "name=Tom", map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, http.StatusUnsupportedMediaType, []string{`the body can't be "application/x-www-form-urlencoded"`}},
+		{"POST", "/pets", `{"name":`, map[string]string{"Content-Type": "application/json"}, http.StatusBadRequest, []string{"the body isn't JSON: unexpected end of JSON input"}},
+		{"POST", "/pets", `{"name": "T", "status": "lost", "born": "yesterday", "color": "red"}`, map[string]string{"Content-Type": "application/json"}, http.StatusBadRequest, []string{
+			`body.born should be a date-time, not "yesterday"`,
+			"body.color isn't allowed",
+			"body.name should be at least 2 characters long",
+			"body.status should be one of available, sold",
+		}},
+		{"POST", "/pets", `[]`, map[string]string{"Content-Type": "application/json"}, http.StatusBadRequest, []string{"body should be an object, not an array"}},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		for key, value := range test.header {
+			req.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		var resp Error
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != test.status || resp.Status != "error" || !reflect.DeepEqual(resp.Errors, test.errs) {
+			t.Errorf("%s %s got %d %s, want %d %q", test.method, test.target, w.Code, w.Body.String(), test.status, test.errs)
+		}
+		if test.status == http.StatusMethodNotAllowed && w.Header().Get("Allow") != "GET, POST" {
+			t.Errorf("Allow: %s", w.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestSwagger(t *testing.T) {
+	s, err := New([]byte(`swagger: "2.0"
+info:
+  title: Pets
+  version: "1.0"
+basePath: /api
+paths:
+  /pets:
+    post:
+      parameters:
+        - name: pet
+          in: body
+          schema:
+            type: object
+            required: [name]
+            properties:
+              name:
+                type: string
+      responses:
+        200:
+          description: The pet.
+          schema:
+            type: object
+            properties:
+              name:
+                type: string
+                example: Rex
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/api/pets", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "body.name is required") {
+		t.Errorf("Got %d %s", w.Code, w.Body.String())
+	}
+	req = httptest.NewRequest("POST", "/api/pets", strings.NewReader(`{"name": "Tom"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"name":"Rex"}` {
+		t.Errorf("Got %d %s", w.Code, w.Body.String())
+	}
+}
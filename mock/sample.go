@@ -0,0 +1,150 @@
+package mock
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// samples are made up strings for string formats.
+var samples = map[string]string{
+	"date":      "2006-01-02",
+	"date-time": "2006-01-02T15:04:05Z",
+	"email":     "user@example.com",
+	"hostname":  "example.com",
+	"ipv4":      "192.0.2.1",
+	"ipv6":      "2001:db8::1",
+	"uri":       "https://example.com/",
+	"url":       "https://example.com/",
+	"uuid":      "3fa85f64-5717-4562-b3fc-2c963f66afa6",
+	"byte":      "c3RyaW5n",
+	"password":  "secret",
+}
+
+// sample makes up a value matching schema for a response, preferring the
+// examples, defaults and enums it declares.  refs holds the references
+// followed to get to schema, to stop at recursive schemas.
+func (s *Server) sample(schema map[string]interface{}, refs []string) interface{} {
+	if ref, ok := schema["$ref"].(string); ok {
+		for _, followed := range refs {
+			if followed == ref {
+				return nil
+			}
+		}
+		target, _ := s.lookup(ref).(map[string]interface{})
+		return s.sample(target, append(refs, ref))
+	}
+	if schema == nil {
+		return nil
+	}
+	for _, key := range []string{"example", "default"} {
+		if v, ok := schema[key]; ok {
+			return v
+		}
+	}
+	if enum := list(schema["enum"]); len(enum) > 0 {
+		return enum[0]
+	}
+	if parts := list(schema["allOf"]); len(parts) > 0 {
+		merged := make(map[string]interface{})
+		for _, part := range parts {
+			part, _ := part.(map[string]interface{})
+			if v, ok := s.sample(part, refs).(map[string]interface{}); ok {
+				for key, value := range v {
+					merged[key] = value
+				}
+			}
+		}
+		if props, ok := s.sample(withoutKey(schema, "allOf"), refs).(map[string]interface{}); ok {
+			for key, value := range props {
+				merged[key] = value
+			}
+		}
+		return merged
+	}
+	for _, keyword := range []string{"oneOf", "anyOf"} {
+		if parts := list(schema[keyword]); len(parts) > 0 {
+			part, _ := parts[0].(map[string]interface{})
+			return s.sample(part, refs)
+		}
+	}
+
+	switch schemaType(schema) {
+	case "string":
+		format, _ := schema["format"].(string)
+		str, ok := samples[format]
+		if !ok {
+			str = "string"
+		}
+		if min, ok := schema["minLength"].(float64); ok && float64(len(str)) < min {
+			str += strings.Repeat("x", int(min)-len(str))
+		}
+		if max, ok := schema["maxLength"].(float64); ok && float64(len(str)) > max {
+			str = str[:int(max)]
+		}
+		return str
+	case "integer", "number":
+		n := 0.0
+		if min, ok := schema["minimum"].(float64); ok {
+			n = min
+			if exclusive, _ := schema["exclusiveMinimum"].(bool); exclusive {
+				n++
+			}
+		} else if max, ok := schema["maximum"].(float64); ok && max < 0 {
+			n = max
+			if exclusive, _ := schema["exclusiveMaximum"].(bool); exclusive {
+				n--
+			}
+		}
+		if schemaType(schema) == "integer" {
+			n = math.Ceil(n)
+		}
+		return n
+	case "boolean":
+		return true
+	case "array":
+		items, _ := schema["items"].(map[string]interface{})
+		count := 1
+		if min, ok := schema["minItems"].(float64); ok && min > 1 {
+			count = int(min)
+		}
+		list := []interface{}{}
+		for i := 0; i < count; i++ {
+			if item := s.sample(items, refs); item != nil {
+				list = append(list, item)
+			}
+		}
+		return list
+	case "object":
+		obj := make(map[string]interface{})
+		properties, _ := schema["properties"].(map[string]interface{})
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, _ := properties[name].(map[string]interface{})
+			resolved, _ := s.resolve(property).(map[string]interface{})
+			if writeOnly, _ := resolved["writeOnly"].(bool); writeOnly {
+				continue
+			}
+			if v := s.sample(property, refs); v != nil {
+				obj[name] = v
+			}
+		}
+		return obj
+	}
+	return nil
+}
+
+// withoutKey returns a copy of m without key.
+func withoutKey(m map[string]interface{}, key string) map[string]interface{} {
+	c := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if k != key {
+			c[k] = v
+		}
+	}
+	return c
+}
@@ -0,0 +1,369 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBodySize caps the size of the request bodies checked.
+const maxBodySize = 8 << 20
+
+// checkRequest checks the parameters and body of a request for op, and
+// returns the status to answer with along with what's wrong with it.
+func (s *Server) checkRequest(r *http.Request, rt *route, op map[string]interface{}, pathParams map[string]string) (int, []string) {
+	var errs []string
+	for _, param := range s.parameters(rt.item, op) {
+		name, _ := param["name"].(string)
+		in, _ := param["in"].(string)
+		where := fmt.Sprintf("%s parameter %s", in, name)
+		var raw []string
+		switch in {
+		case "path":
+			raw = []string{pathParams[name]}
+		case "query":
+			raw = r.URL.Query()[name]
+		case "header":
+			raw = r.Header.Values(name)
+		case "cookie":
+			if cookie, err := r.Cookie(name); err == nil {
+				raw = []string{cookie.Value}
+			}
+		}
+		if len(raw) == 0 {
+			if required, _ := param["required"].(bool); required {
+				errs = append(errs, where+" is required")
+			}
+			continue
+		}
+		schema, _ := s.resolve(param["schema"]).(map[string]interface{})
+		explode, ok := param["explode"].(bool)
+		if !ok {
+			style, _ := param["style"].(string)
+			explode = (in == "query" || in == "cookie") && (len(style) == 0 || style == "form")
+		}
+		v, err := s.coerce(raw, schema, explode)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s %v", where, err))
+			continue
+		}
+		errs = append(errs, s.validate(schema, v, where)...)
+	}
+
+	body, _ := s.resolve(op["requestBody"]).(map[string]interface{})
+	if body == nil {
+		return http.StatusBadRequest, errs
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		return http.StatusBadRequest, append(errs, "can't read the body: "+err.Error())
+	}
+	if len(data) == 0 {
+		if required, _ := body["required"].(bool); required {
+			errs = append(errs, "the body is required")
+		}
+		return http.StatusBadRequest, errs
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	content, _ := body["content"].(map[string]interface{})
+	media, _ := content[contentType].(map[string]interface{})
+	for _, mediaRange := range []string{strings.Split(contentType, "/")[0] + "/*", "*/*"} {
+		if media == nil {
+			media, _ = content[mediaRange].(map[string]interface{})
+		}
+	}
+	if media == nil {
+		return http.StatusUnsupportedMediaType, append(errs, fmt.Sprintf("the body can't be %q", contentType))
+	}
+	if !isJSON(contentType) {
+		return http.StatusBadRequest, errs
+	}
+	var v interface{}
+	if err = json.Unmarshal(data, &v); err != nil {
+		return http.StatusBadRequest, append(errs, "the body isn't JSON: "+err.Error())
+	}
+	schema, _ := media["schema"].(map[string]interface{})
+	return http.StatusBadRequest, append(errs, s.validate(schema, v, "body")...)
+}
+
+// parameters returns the parameters of op, with those of its path item it
+// doesn't override.
+func (s *Server) parameters(item, op map[string]interface{}) []map[string]interface{} {
+	var params []map[string]interface{}
+	seen := make(map[string]bool)
+	for _, list := range []interface{}{op["parameters"], item["parameters"]} {
+		list, _ := list.([]interface{})
+		for _, param := range list {
+			param, ok := s.resolve(param).(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if key := fmt.Sprint(param["in"], " ", param["name"]); !seen[key] {
+				seen[key] = true
+				params = append(params, param)
+			}
+		}
+	}
+	return params
+}
+
+// coerce turns the values of a parameter into the value they stand for
+// according to its schema.  Arrays are repeated parameters when exploded,
+// and separated by commas otherwise.
+func (s *Server) coerce(raw []string, schema map[string]interface{}, explode bool) (interface{}, error) {
+	if schemaType(schema) != "array" {
+		return scalar(raw[0], schema)
+	}
+	if !explode {
+		raw = strings.Split(raw[0], ",")
+	}
+	items, _ := s.resolve(schema["items"]).(map[string]interface{})
+	list := make([]interface{}, 0, len(raw))
+	for _, str := range raw {
+		v, err := scalar(str, items)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+func scalar(str string, schema map[string]interface{}) (interface{}, error) {
+	switch schemaType(schema) {
+	case "integer", "number":
+		f, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return nil, fmt.Errorf("should be a number, not %q", str)
+		}
+		return f, nil
+	case "boolean":
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return nil, fmt.Errorf("should be true or false, not %q", str)
+		}
+		return b, nil
+	}
+	return str, nil
+}
+
+// schemaType returns the type of a schema, inferring it from its keywords
+// when it's left out.
+func schemaType(schema map[string]interface{}) string {
+	if t, ok := schema["type"].(string); ok {
+		return t
+	}
+	switch {
+	case schema["properties"] != nil || schema["additionalProperties"] != nil:
+		return "object"
+	case schema["items"] != nil:
+		return "array"
+	}
+	return ""
+}
+
+var formats = map[string]*regexp.Regexp{
+	"date":  regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`),
+	"email": regexp.MustCompile(`^[^@\s]+@[^@\s]+$`),
+	"uuid":  regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`),
+}
+
+// validate checks a value of a request against schema, and returns what's
+// wrong with it.  The readOnly properties of objects aren't required.
+func (s *Server) validate(schema map[string]interface{}, v interface{}, where string) []string {
+	return s.check(schema, v, where, 0)
+}
+
+func (s *Server) check(schema map[string]interface{}, v interface{}, where string, depth int) []string {
+	if depth > 64 {
+		return nil
+	}
+	schema, _ = s.resolve(schema).(map[string]interface{})
+	if schema == nil {
+		return nil
+	}
+	var errs []string
+	for _, part := range list(schema["allOf"]) {
+		part, _ := part.(map[string]interface{})
+		errs = append(errs, s.check(part, v, where, depth+1)...)
+	}
+	for _, keyword := range []string{"oneOf", "anyOf"} {
+		parts := list(schema[keyword])
+		if len(parts) == 0 {
+			continue
+		}
+		valid := 0
+		for _, part := range parts {
+			part, _ := part.(map[string]interface{})
+			if len(s.check(part, v, where, depth+1)) == 0 {
+				valid++
+			}
+		}
+		if keyword == "oneOf" && valid != 1 || valid == 0 {
+			errs = append(errs, fmt.Sprintf("%s matches %d of the schemas of its %s", where, valid, keyword))
+		}
+	}
+
+	if v == nil {
+		if nullable, _ := schema["nullable"].(bool); !nullable && len(schemaType(schema)) > 0 {
+			errs = append(errs, fmt.Sprintf("%s should be %s, not null", where, article(schemaType(schema))))
+		}
+		return errs
+	}
+	if enum := list(schema["enum"]); len(enum) > 0 {
+		found := false
+		for _, allowed := range enum {
+			found = found || reflect.DeepEqual(allowed, v)
+		}
+		if !found {
+			values := make([]string, 0, len(enum))
+			for _, allowed := range enum {
+				values = append(values, fmt.Sprint(allowed))
+			}
+			errs = append(errs, fmt.Sprintf("%s should be one of %s", where, strings.Join(values, ", ")))
+		}
+	}
+
+	t := schemaType(schema)
+	switch v := v.(type) {
+	case string:
+		if len(t) > 0 && t != "string" {
+			return append(errs, fmt.Sprintf("%s should be %s, not a string", where, article(t)))
+		}
+		errs = append(errs, checkString(schema, v, where)...)
+	case float64:
+		if t == "integer" && v != math.Trunc(v) {
+			return append(errs, fmt.Sprintf("%s should be an integer, not %v", where, v))
+		}
+		if len(t) > 0 && t != "integer" && t != "number" {
+			return append(errs, fmt.Sprintf("%s should be %s, not a number", where, article(t)))
+		}
+		errs = append(errs, checkNumber(schema, v, where)...)
+	case bool:
+		if len(t) > 0 && t != "boolean" {
+			return append(errs, fmt.Sprintf("%s should be %s, not a boolean", where, article(t)))
+		}
+	case []interface{}:
+		if len(t) > 0 && t != "array" {
+			return append(errs, fmt.Sprintf("%s should be %s, not an array", where, article(t)))
+		}
+		if min, ok := schema["minItems"].(float64); ok && float64(len(v)) < min {
+			errs = append(errs, fmt.Sprintf("%s should have at least %v items", where, min))
+		}
+		if max, ok := schema["maxItems"].(float64); ok && float64(len(v)) > max {
+			errs = append(errs, fmt.Sprintf("%s should have at most %v items", where, max))
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range v {
+			errs = append(errs, s.check(items, item, fmt.Sprintf("%s[%d]", where, i), depth+1)...)
+		}
+	case map[string]interface{}:
+		if len(t) > 0 && t != "object" {
+			return append(errs, fmt.Sprintf("%s should be %s, not an object", where, article(t)))
+		}
+		errs = append(errs, s.checkObject(schema, v, where, depth)...)
+	}
+	return errs
+}
+
+func checkString(schema map[string]interface{}, v, where string) []string {
+	var errs []string
+	length := float64(len([]rune(v)))
+	if min, ok := schema["minLength"].(float64); ok && length < min {
+		errs = append(errs, fmt.Sprintf("%s should be at least %v characters long", where, min))
+	}
+	if max, ok := schema["maxLength"].(float64); ok && length > max {
+		errs = append(errs, fmt.Sprintf("%s should be at most %v characters long", where, max))
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+			errs = append(errs, fmt.Sprintf("%s should match %s", where, pattern))
+		}
+	}
+	format, _ := schema["format"].(string)
+	valid := true
+	if format == "date-time" {
+		_, err := time.Parse(time.RFC3339, v)
+		valid = err == nil
+	} else if re, ok := formats[format]; ok {
+		valid = re.MatchString(v)
+	}
+	if !valid {
+		errs = append(errs, fmt.Sprintf("%s should be a %s, not %q", where, format, v))
+	}
+	return errs
+}
+
+func checkNumber(schema map[string]interface{}, v float64, where string) []string {
+	var errs []string
+	if min, ok := schema["minimum"].(float64); ok {
+		if exclusive, _ := schema["exclusiveMinimum"].(bool); exclusive && v <= min {
+			errs = append(errs, fmt.Sprintf("%s should be more than %v", where, min))
+		} else if v < min {
+			errs = append(errs, fmt.Sprintf("%s should be at least %v", where, min))
+		}
+	}
+	if max, ok := schema["maximum"].(float64); ok {
+		if exclusive, _ := schema["exclusiveMaximum"].(bool); exclusive && v >= max {
+			errs = append(errs, fmt.Sprintf("%s should be less than %v", where, max))
+		} else if v > max {
+			errs = append(errs, fmt.Sprintf("%s should be at most %v", where, max))
+		}
+	}
+	return errs
+}
+
+func (s *Server) checkObject(schema, v map[string]interface{}, where string, depth int) []string {
+	var errs []string
+	properties, _ := schema["properties"].(map[string]interface{})
+	for _, name := range list(schema["required"]) {
+		name, _ := name.(string)
+		property, _ := s.resolve(properties[name]).(map[string]interface{})
+		readOnly, _ := property["readOnly"].(bool)
+		if _, ok := v[name]; !ok && !readOnly {
+			errs = append(errs, fmt.Sprintf("%s.%s is required", where, name))
+		}
+	}
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if property, ok := properties[name].(map[string]interface{}); ok {
+			errs = append(errs, s.check(property, v[name], where+"."+name, depth+1)...)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				errs = append(errs, fmt.Sprintf("%s.%s isn't allowed", where, name))
+			}
+		case map[string]interface{}:
+			errs = append(errs, s.check(additional, v[name], where+"."+name, depth+1)...)
+		}
+	}
+	return errs
+}
+
+func list(v interface{}) []interface{} {
+	l, _ := v.([]interface{})
+	return l
+}
+
+// article returns a type name with its indefinite article.
+func article(t string) string {
+	if strings.IndexByte("aeiou", t[0]) >= 0 {
+		return "an " + t
+	}
+	return "a " + t
+}
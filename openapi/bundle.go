@@ -0,0 +1,316 @@
+package openapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// A Loader returns the content of the file at a path relative to the root of
+// the repository holding the documents.
+type Loader func(fileName string) ([]byte, error)
+
+// A RefError is a $ref that can't be resolved.
+type RefError struct {
+	File string
+	Ref  string
+	Err  error
+}
+
+func (e *RefError) Error() string {
+	return fmt.Sprintf("%s: can't resolve %s: %v", e.File, e.Ref, e.Err)
+}
+
+func (e *RefError) Unwrap() error {
+	return e.Err
+}
+
+// Bundle resolves the references of the document in fileName to other files
+// and returns it as a single YAML document.  Definitions, parameters,
+// responses and the other components of the other files are copied into the
+// document, renamed if their name is taken, and referenced locally.  What
+// other references point to, such as whole files, is copied in their place,
+// which fails with a RefError when they lead back to themselves.  Paths are
+// relative to the file the reference is in, and references to URLs are left
+// alone.
+func Bundle(fileName string, load Loader) ([]byte, error) {
+	b := &bundler{main: fileName, load: load, docs: make(map[string]*yaml.Node), hoisted: make(map[string]string)}
+	root, err := b.doc(fileName)
+	if err != nil {
+		return nil, err
+	}
+	b.root = clone(root)
+	b.version = 3
+	if child(b.root, "swagger") != nil {
+		b.version = 2
+	}
+	if err = b.resolveRefs(b.root, fileName); err != nil {
+		return nil, err
+	}
+	plain(b.root)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(b.root); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// References returns the files the document in fileName refers to, relative
+// to the root of the repository and in document order.
+func References(fileName string, data []byte) ([]string, error) {
+	root, errs := Parse(data)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	var files []string
+	seen := make(map[string]bool)
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		if n.Kind == yaml.AliasNode {
+			return
+		}
+		if ref := child(n, "$ref"); n.Kind == yaml.MappingNode && ref != nil && ref.Kind == yaml.ScalarNode {
+			if file, _, err := splitRef(fileName, ref.Value); err == nil && len(file) > 0 && !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+			return
+		}
+		for _, c := range n.Content {
+			walk(c)
+		}
+	}
+	walk(root)
+	return files, nil
+}
+
+type bundler struct {
+	main    string
+	load    Loader
+	docs    map[string]*yaml.Node
+	root    *yaml.Node
+	version int
+	// hoisted maps the components copied into the document, as file#pointer,
+	// to their local references.
+	hoisted map[string]string
+	// inlining lists the references being copied in, to catch cycles.
+	inlining []string
+}
+
+// splitRef splits a reference found in the file from into the file it points
+// to, relative to the root of the repository, and a JSON pointer.  The file
+// is empty for local references and URLs.
+func splitRef(from, ref string) (string, string, error) {
+	file, pointer := ref, ""
+	if i := strings.Index(ref, "#"); i >= 0 {
+		file, pointer = ref[:i], ref[i+1:]
+	}
+	if len(file) == 0 || strings.Contains(file, "://") {
+		return "", pointer, nil
+	}
+	if strings.HasPrefix(file, "/") {
+		file = path.Clean(file[1:])
+	} else {
+		file = path.Join(path.Dir(from), file)
+	}
+	if file == ".." || strings.HasPrefix(file, "../") {
+		return "", "", errors.New("the file is outside the repository")
+	}
+	return file, pointer, nil
+}
+
+func (b *bundler) doc(fileName string) (*yaml.Node, error) {
+	if root, ok := b.docs[fileName]; ok {
+		return root, nil
+	}
+	data, err := b.load(fileName)
+	if err != nil {
+		return nil, err
+	}
+	root, errs := Parse(data)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s: %v", fileName, errs[0])
+	}
+	b.docs[fileName] = root
+	return root, nil
+}
+
+// clone returns a deep copy of n, with aliases replaced by what they stand
+// for.
+func clone(n *yaml.Node) *yaml.Node {
+	n = resolve(n)
+	c := *n
+	c.Content = make([]*yaml.Node, len(n.Content))
+	for i, elem := range n.Content {
+		c.Content[i] = clone(elem)
+	}
+	return &c
+}
+
+// unescape decodes a JSON pointer token.
+func unescape(token string) string {
+	return strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+}
+
+// component returns the kind of component, as named in OpenAPI 3, and the
+// name of the one pointer points to, or empty strings.
+func component(pointer string) (string, string) {
+	tokens := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	switch {
+	case len(tokens) == 2 && tokens[0] == "definitions":
+		return "schemas", unescape(tokens[1])
+	case len(tokens) == 2 && (tokens[0] == "parameters" || tokens[0] == "responses"):
+		return tokens[0], unescape(tokens[1])
+	case len(tokens) == 3 && tokens[0] == "components":
+		return tokens[1], unescape(tokens[2])
+	}
+	return "", ""
+}
+
+// section returns the mapping components of kind are kept in, creating it if
+// needed, along with the pointer to it.  It returns nil for kinds the
+// document can't hold.
+func (b *bundler) section(kind string) (*yaml.Node, string) {
+	parent, pointer := b.root, "#/"
+	if b.version == 2 {
+		switch kind {
+		case "schemas":
+			kind = "definitions"
+		case "parameters", "responses":
+		default:
+			return nil, ""
+		}
+	} else {
+		components := child(b.root, "components")
+		if components == nil {
+			components = newMapping()
+			set(b.root, "components", components)
+		}
+		parent, pointer = components, "#/components/"
+	}
+	section := child(parent, kind)
+	if section == nil {
+		section = newMapping()
+		set(parent, kind, section)
+	}
+	return section, pointer + kind + "/"
+}
+
+// resolveRefs resolves the references in n, found in fileName, and in
+// everything under it.
+func (b *bundler) resolveRefs(n *yaml.Node, fileName string) error {
+	if n.Kind == yaml.AliasNode {
+		return nil
+	}
+	ref := child(n, "$ref")
+	if n.Kind != yaml.MappingNode || ref == nil || ref.Kind != yaml.ScalarNode {
+		for _, c := range n.Content {
+			if err := b.resolveRefs(c, fileName); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	target, pointer, err := splitRef(fileName, ref.Value)
+	if err != nil {
+		return &RefError{fileName, ref.Value, err}
+	}
+	if len(target) == 0 {
+		if fileName == b.main || strings.Contains(ref.Value, "://") {
+			return nil
+		}
+		target = fileName
+	}
+	if target == b.main {
+		if len(pointer) == 0 {
+			return &RefError{fileName, ref.Value, errors.New("the document refers to itself")}
+		}
+		*n = *refMapping("#" + pointer)
+		return nil
+	}
+
+	if kind, name := component(pointer); len(kind) > 0 {
+		if section, sectionPointer := b.section(kind); section != nil {
+			local, err := b.hoist(fileName, ref.Value, target, pointer, section, sectionPointer, name)
+			if err != nil {
+				return err
+			}
+			*n = *refMapping(local)
+			return nil
+		}
+	}
+
+	key := target + "#" + pointer
+	for i, inlining := range b.inlining {
+		if inlining == key {
+			cycle := append(append([]string(nil), b.inlining[i:]...), key)
+			return &RefError{fileName, ref.Value, fmt.Errorf("circular reference %s", strings.Join(cycle, " -> "))}
+		}
+	}
+	value, err := b.lookup(fileName, ref.Value, target, pointer)
+	if err != nil {
+		return err
+	}
+	b.inlining = append(b.inlining, key)
+	err = b.resolveRefs(value, target)
+	b.inlining = b.inlining[:len(b.inlining)-1]
+	if err != nil {
+		return err
+	}
+	*n = *value
+	return nil
+}
+
+// lookup returns a copy of what pointer points to in target.
+func (b *bundler) lookup(fileName, ref, target, pointer string) (*yaml.Node, error) {
+	doc, err := b.doc(target)
+	if err != nil {
+		return nil, &RefError{fileName, ref, err}
+	}
+	value := doc
+	if len(pointer) > 0 {
+		if value = LookupRef(doc, "#"+pointer); value == nil {
+			return nil, &RefError{fileName, ref, fmt.Errorf("%s has nothing at %s", target, pointer)}
+		}
+	}
+	return clone(value), nil
+}
+
+// hoist copies the component pointer points to in target into section, and
+// returns the local reference to it.  ref is the reference to it found in
+// fileName.
+func (b *bundler) hoist(fileName, ref, target, pointer string, section *yaml.Node, sectionPointer, name string) (string, error) {
+	key := target + "#" + pointer
+	if local, ok := b.hoisted[key]; ok {
+		return local, nil
+	}
+	value, err := b.lookup(fileName, ref, target, pointer)
+	if err != nil {
+		return "", err
+	}
+	unique := name
+	for i := 2; child(section, unique) != nil; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	local := sectionPointer + strings.Replace(strings.Replace(unique, "~", "~0", -1), "/", "~1", -1)
+	b.hoisted[key] = local
+	set(section, unique, value)
+	return local, b.resolveRefs(value, target)
+}
+
+func refMapping(ref string) *yaml.Node {
+	n := newMapping()
+	set(n, "$ref", str(ref))
+	return n
+}
@@ -0,0 +1,170 @@
+package openapi
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var bundleFiles = map[string]string{
+	"main.yaml": `swagger: "2.0"
+info:
+  title: Pets
+  version: "1.0"
+paths:
+  /pets:
+    $ref: paths/pets.yaml
+  /owners:
+    get:
+      responses:
+        200:
+          description: The owners.
+          schema:
+            $ref: '#/definitions/Owner'
+        default:
+          description: An error.
+          schema:
+            $ref: common/definitions.yaml#/definitions/Error
+definitions:
+  Owner:
+    type: object
+  Error:
+    type: string
+`,
+	"paths/pets.yaml": `get:
+  responses:
+    200:
+      description: The pets.
+      schema:
+        type: array
+        items:
+          $ref: ../common/definitions.yaml#/definitions/Pet
+`,
+	"common/definitions.yaml": `definitions:
+  Pet:
+    type: object
+    properties:
+      owner:
+        $ref: ../main.yaml#/definitions/Owner
+      parent:
+        $ref: '#/definitions/Pet'
+      tags:
+        type: array
+        items:
+          $ref: tag.yaml
+  Error:
+    type: object
+    properties:
+      message:
+        type: string
+`,
+	"common/tag.yaml": `type: string
+`,
+	"cycle.yaml": `swagger: "2.0"
+info:
+  title: Cycle
+  version: "1.0"
+paths:
+  /nodes:
+    $ref: node.yaml
+`,
+	"node.yaml": `get:
+  responses:
+    200:
+      $ref: response.yaml
+`,
+	"response.yaml": `$ref: node.yaml#/get/responses/200
+`,
+	"missing.yaml": `swagger: "2.0"
+paths:
+  /pets:
+    $ref: nope.yaml
+`,
+	"outside.yaml": `swagger: "2.0"
+paths:
+  /pets:
+    $ref: ../pets.yaml
+`,
+}
+
+func loadBundleFile(fileName string) ([]byte, error) {
+	content, ok := bundleFiles[fileName]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(content), nil
+}
+
+func TestBundle(t *testing.T) {
+	data, err := Bundle("main.yaml", loadBundleFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := Validate(data); len(errs) > 0 {
+		t.Fatalf("Bundle isn't valid: %v\n%s", errs, data)
+	}
+	if strings.Contains(string(data), ".yaml") {
+		t.Errorf("Bundle still refers to other files:\n%s", data)
+	}
+	root, _ := Parse(data)
+	get := func(ref string) interface{} {
+		var v interface{}
+		if n := LookupRef(root, ref); n != nil {
+			n.Decode(&v)
+		}
+		return v
+	}
+
+	checks := []struct {
+		ref  string
+		want interface{}
+	}{
+		{"#/paths/~1pets/get/responses/200/schema/items/$ref", "#/definitions/Pet"},
+		{"#/paths/~1owners/get/responses/default/schema/$ref", "#/definitions/Error2"},
+		{"#/definitions/Error/type", "string"},
+		{"#/definitions/Error2/type", "object"},
+		{"#/definitions/Pet/properties/owner/$ref", "#/definitions/Owner"},
+		{"#/definitions/Pet/properties/parent/$ref", "#/definitions/Pet"},
+		{"#/definitions/Pet/properties/tags/items", map[string]interface{}{"type": "string"}},
+	}
+	for _, check := range checks {
+		if got := get(check.ref); !reflect.DeepEqual(got, check.want) {
+			t.Errorf("%s = %#v, want %#v", check.ref, got, check.want)
+		}
+	}
+}
+
+func TestBundleErrors(t *testing.T) {
+	tests := []struct {
+		fileName, message string
+	}{
+		{"cycle.yaml", "circular reference response.yaml# -> node.yaml#/get/responses/200 -> response.yaml#"},
+		{"missing.yaml", "missing.yaml: can't resolve nope.yaml"},
+		{"outside.yaml", "outside the repository"},
+	}
+	for _, test := range tests {
+		_, err := Bundle(test.fileName, loadBundleFile)
+		var refErr *RefError
+		if !errors.As(err, &refErr) || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Bundle(%s): %v", test.fileName, err)
+		}
+	}
+	if _, err := Bundle("missing.yaml", loadBundleFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("A missing file isn't reported as such: %v", err)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	for fileName, want := range map[string][]string{
+		"main.yaml":               {"paths/pets.yaml", "common/definitions.yaml"},
+		"paths/pets.yaml":         {"common/definitions.yaml"},
+		"common/definitions.yaml": {"main.yaml", "common/tag.yaml"},
+		"common/tag.yaml":         nil,
+	} {
+		if files, err := References(fileName, []byte(bundleFiles[fileName])); err != nil || !reflect.DeepEqual(files, want) {
+			t.Errorf("References(%s) = %q, %v", fileName, files, err)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package openapi
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of breaking change reported by Compare.
+const (
+	PathRemoved          = "path-removed"
+	OperationRemoved     = "operation-removed"
+	ParameterRequired    = "parameter-required"
+	TypeChanged          = "type-changed"
+	TypeNarrowed         = "type-narrowed"
+	ResponseRemoved      = "response-removed"
+	ResponseFieldRemoved = "response-field-removed"
+)
+
+// A Change is a difference between two revisions of a document that can
+// break existing clients.
+type Change struct {
+	File    string `json:"file,omitempty"`
+	Kind    string `json:"kind"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s", c.Path, c.Message)
+}
+
+// Compare reports the changes from oldData to newData that break clients of
+// the old document: removed paths and operations, parameters that became
+// required, types that were changed or narrowed and response fields that
+// were removed.  Both documents must parse; they needn't be valid.
+func Compare(oldData, newData []byte) ([]Change, error) {
+	oldRoot, errs := Parse(oldData)
+	if errs != nil {
+		return nil, errs[0]
+	}
+	newRoot, errs := Parse(newData)
+	if errs != nil {
+		return nil, errs[0]
+	}
+
+	c := &comparer{oldRoot: oldRoot, newRoot: newRoot, seen: make(map[[2]*yaml.Node]bool)}
+	c.paths(child(oldRoot, "paths"), child(newRoot, "paths"))
+	return c.changes, nil
+}
+
+type comparer struct {
+	oldRoot, newRoot *yaml.Node
+	changes          []Change
+	seen             map[[2]*yaml.Node]bool
+}
+
+func (c *comparer) report(kind string, path []string, format string, args ...interface{}) {
+	c.changes = append(c.changes, Change{Kind: kind, Path: strings.Join(path, "."), Message: fmt.Sprintf(format, args...)})
+}
+
+// LookupRef returns the node a local reference such as #/definitions/Pet
+// points to in root, or nil for references to other documents.
+func LookupRef(root *yaml.Node, ref string) *yaml.Node {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	n := root
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		n = resolve(n)
+		if n != nil && n.Kind == yaml.SequenceNode {
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(n.Content) {
+				return nil
+			}
+			n = n.Content[i]
+			continue
+		}
+		if n = child(n, token); n == nil {
+			return nil
+		}
+	}
+	return resolve(n)
+}
+
+// deref follows local $refs, giving up on cycles and external references.
+func deref(root, n *yaml.Node) *yaml.Node {
+	for i := 0; n != nil && i < 32; i++ {
+		ref := child(n, "$ref")
+		if ref == nil {
+			return n
+		}
+		n = LookupRef(root, ref.Value)
+	}
+	return nil
+}
+
+// normalizePath replaces the variable names in a path template, so that
+// /pets/{id} and /pets/{petId} are treated as the same path.
+func normalizePath(path string) string {
+	return pathTemplate.ReplaceAllString(path, "{}")
+}
+
+func (c *comparer) paths(oldPaths, newPaths *yaml.Node) {
+	byTemplate := make(map[string]*yaml.Node)
+	Walk(newPaths, func(key, item *yaml.Node) {
+		byTemplate[normalizePath(key.Value)] = item
+	})
+
+	Walk(oldPaths, func(key, oldItem *yaml.Node) {
+		if strings.HasPrefix(key.Value, "x-") {
+			return
+		}
+		path := []string{"paths", key.Value}
+		newItem := byTemplate[normalizePath(key.Value)]
+		if newItem == nil {
+			c.report(PathRemoved, path, "path %s was removed", key.Value)
+			return
+		}
+		oldItem, newItem = deref(c.oldRoot, oldItem), deref(c.newRoot, newItem)
+		Walk(oldItem, func(method, oldOp *yaml.Node) {
+			if !contains(methods, method.Value) {
+				return
+			}
+			opPath := extend(path, method.Value)
+			newOp := child(newItem, method.Value)
+			if newOp == nil {
+				c.report(OperationRemoved, opPath, "operation %s %s was removed", strings.ToUpper(method.Value), key.Value)
+				return
+			}
+			c.operation(oldItem, oldOp, newItem, newOp, opPath)
+		})
+	})
+}
+
+// parameters returns the parameters that apply to an operation keyed by
+// location and name.  Operation parameters override path ones, and Swagger
+// 2.0 body parameters are keyed by location alone since their name doesn't
+// matter to clients.
+func parameters(root, item, op *yaml.Node) (map[string]*yaml.Node, []string) {
+	params := make(map[string]*yaml.Node)
+	var keys []string
+	for _, list := range []*yaml.Node{child(item, "parameters"), child(op, "parameters")} {
+		if list == nil || list.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, param := range list.Content {
+			param = deref(root, resolve(param))
+			in, name := child(param, "in"), child(param, "name")
+			if in == nil || name == nil {
+				continue
+			}
+			key := in.Value + ":" + name.Value
+			if in.Value == "body" {
+				key = "body"
+			}
+			if params[key] == nil {
+				keys = append(keys, key)
+			}
+			params[key] = param
+		}
+	}
+	return params, keys
+}
+
+func isTrue(n *yaml.Node) bool {
+	return n != nil && n.Value == "true"
+}
+
+func (c *comparer) operation(oldItem, oldOp, newItem, newOp *yaml.Node, path []string) {
+	oldParams, oldKeys := parameters(c.oldRoot, oldItem, oldOp)
+	newParams, newKeys := parameters(c.newRoot, newItem, newOp)
+
+	for _, key := range newKeys {
+		newParam := newParams[key]
+		paramPath := extend(path, "parameters", key)
+		oldParam := oldParams[key]
+		switch {
+		case oldParam == nil && isTrue(child(newParam, "required")):
+			c.report(ParameterRequired, paramPath, "new required parameter %s", key)
+		case oldParam != nil && !isTrue(child(oldParam, "required")) && isTrue(child(newParam, "required")):
+			c.report(ParameterRequired, paramPath, "parameter %s is now required", key)
+		}
+	}
+	for _, key := range oldKeys {
+		oldParam, newParam := oldParams[key], newParams[key]
+		if newParam == nil {
+			continue
+		}
+		paramPath := extend(path, "parameters", key)
+		if oldSchema, newSchema := child(oldParam, "schema"), child(newParam, "schema"); oldSchema != nil && newSchema != nil {
+			c.schema(oldSchema, newSchema, extend(paramPath, "schema"), true)
+		} else {
+			// Swagger 2.0 describes non-body parameters inline.
+			c.schema(oldParam, newParam, paramPath, true)
+		}
+	}
+
+	oldBody, newBody := deref(c.oldRoot, child(oldOp, "requestBody")), deref(c.newRoot, child(newOp, "requestBody"))
+	bodyPath := extend(path, "requestBody")
+	if isTrue(child(newBody, "required")) && !isTrue(child(oldBody, "required")) {
+		c.report(ParameterRequired, bodyPath, "request body is now required")
+	}
+	c.content(child(oldBody, "content"), child(newBody, "content"), extend(bodyPath, "content"), true)
+
+	c.responses(child(oldOp, "responses"), child(newOp, "responses"), extend(path, "responses"))
+}
+
+func (c *comparer) content(oldContent, newContent *yaml.Node, path []string, request bool) {
+	Walk(oldContent, func(mediaType, oldMedia *yaml.Node) {
+		newMedia := child(newContent, mediaType.Value)
+		if newMedia == nil {
+			return
+		}
+		oldSchema, newSchema := child(oldMedia, "schema"), child(newMedia, "schema")
+		if oldSchema != nil && newSchema != nil {
+			c.schema(oldSchema, newSchema, extend(path, mediaType.Value, "schema"), request)
+		}
+	})
+}
+
+func (c *comparer) responses(oldResponses, newResponses *yaml.Node, path []string) {
+	Walk(oldResponses, func(code, oldResponse *yaml.Node) {
+		responsePath := extend(path, code.Value)
+		newResponse := child(newResponses, code.Value)
+		if newResponse == nil {
+			if strings.HasPrefix(code.Value, "2") {
+				c.report(ResponseRemoved, responsePath, "response %s was removed", code.Value)
+			}
+			return
+		}
+		oldResponse, newResponse = deref(c.oldRoot, oldResponse), deref(c.newRoot, newResponse)
+		if oldSchema, newSchema := child(oldResponse, "schema"), child(newResponse, "schema"); oldSchema != nil && newSchema != nil {
+			c.schema(oldSchema, newSchema, extend(responsePath, "schema"), false)
+		}
+		c.content(child(oldResponse, "content"), child(newResponse, "content"), extend(responsePath, "content"), false)
+	})
+}
+
+func schemaType(n *yaml.Node) string {
+	t := child(n, "type")
+	if t == nil {
+		return ""
+	}
+	if t.Kind == yaml.SequenceNode {
+		var types []string
+		for _, elem := range t.Content {
+			types = append(types, elem.Value)
+		}
+		sort.Strings(types)
+		return strings.Join(types, ",")
+	}
+	return t.Value
+}
+
+func number(n *yaml.Node) (float64, bool) {
+	if n == nil {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(n.Value, 64)
+	return f, err == nil
+}
+
+// schema compares two schemas.  Request schemas describe what clients send,
+// so any narrowing breaks them; response schemas describe what clients
+// receive, so removed fields and changed types do.
+func (c *comparer) schema(oldSchema, newSchema *yaml.Node, path []string, request bool) {
+	oldSchema, newSchema = deref(c.oldRoot, oldSchema), deref(c.newRoot, newSchema)
+	if oldSchema == nil || newSchema == nil || c.seen[[2]*yaml.Node{oldSchema, newSchema}] {
+		return
+	}
+	c.seen[[2]*yaml.Node{oldSchema, newSchema}] = true
+	defer delete(c.seen, [2]*yaml.Node{oldSchema, newSchema})
+
+	oldType, newType := schemaType(oldSchema), schemaType(newSchema)
+	if oldType != newType && len(oldType) > 0 {
+		switch {
+		case request && oldType == "number" && newType == "integer":
+			c.report(TypeNarrowed, path, "type narrowed from number to integer")
+		case !request && oldType == "integer" && newType == "number":
+			c.report(TypeChanged, path, "type widened from integer to number")
+		case request && len(newType) == 0:
+			// Without a type the new schema accepts anything the old one did.
+		case !request && oldType == "number" && newType == "integer":
+			// Integers are still numbers to the client.
+		case len(newType) == 0:
+			c.report(TypeChanged, path, "type %s was removed", oldType)
+		default:
+			c.report(TypeChanged, path, "type changed from %s to %s", oldType, newType)
+		}
+		return
+	}
+
+	if request {
+		c.constraints(oldSchema, newSchema, path)
+
+		oldRequired := make(map[string]bool)
+		if required := child(oldSchema, "required"); required != nil && required.Kind == yaml.SequenceNode {
+			for _, name := range required.Content {
+				oldRequired[name.Value] = true
+			}
+		}
+		if required := child(newSchema, "required"); required != nil && required.Kind == yaml.SequenceNode {
+			for _, name := range required.Content {
+				if !oldRequired[name.Value] {
+					c.report(ParameterRequired, extend(path, "properties", name.Value), "property %s is now required", name.Value)
+				}
+			}
+		}
+	}
+
+	newProperties := child(newSchema, "properties")
+	Walk(child(oldSchema, "properties"), func(name, oldProperty *yaml.Node) {
+		propertyPath := extend(path, "properties", name.Value)
+		newProperty := child(newProperties, name.Value)
+		if newProperty == nil {
+			if !request && child(newSchema, "additionalProperties") == nil {
+				c.report(ResponseFieldRemoved, propertyPath, "response field %s was removed", name.Value)
+			}
+			return
+		}
+		c.schema(oldProperty, newProperty, propertyPath, request)
+	})
+
+	if oldItems, newItems := child(oldSchema, "items"), child(newSchema, "items"); oldItems != nil && newItems != nil {
+		c.schema(oldItems, newItems, extend(path, "items"), request)
+	}
+}
+
+// constraints reports the validation keywords of a request schema that
+// reject values the old schema accepted.
+func (c *comparer) constraints(oldSchema, newSchema *yaml.Node, path []string) {
+	for _, keyword := range []string{"maximum", "maxLength", "maxItems", "minimum", "minLength", "minItems"} {
+		newLimit, hasNew := number(child(newSchema, keyword))
+		if !hasNew {
+			continue
+		}
+		oldLimit, hasOld := number(child(oldSchema, keyword))
+		upper := strings.HasPrefix(keyword, "max")
+		if !hasOld || upper && newLimit < oldLimit || !upper && newLimit > oldLimit {
+			c.report(TypeNarrowed, extend(path, keyword), "%s narrowed to %s", keyword, child(newSchema, keyword).Value)
+		}
+	}
+
+	for _, keyword := range []string{"format", "pattern"} {
+		oldValue, newValue := child(oldSchema, keyword), child(newSchema, keyword)
+		if newValue != nil && (oldValue == nil || oldValue.Value != newValue.Value) {
+			c.report(TypeNarrowed, extend(path, keyword), "%s changed to %s", keyword, newValue.Value)
+		}
+	}
+
+	newEnum := child(newSchema, "enum")
+	if newEnum == nil || newEnum.Kind != yaml.SequenceNode {
+		return
+	}
+	oldEnum := child(oldSchema, "enum")
+	if oldEnum == nil {
+		c.report(TypeNarrowed, extend(path, "enum"), "values restricted to an enum")
+		return
+	}
+	allowed := make(map[string]bool)
+	for _, value := range newEnum.Content {
+		allowed[value.Value] = true
+	}
+	var removed []string
+	for _, value := range oldEnum.Content {
+		if !allowed[value.Value] {
+			removed = append(removed, value.Value)
+		}
+	}
+	if len(removed) > 0 {
+		c.report(TypeNarrowed, extend(path, "enum"), "enum values removed: %s", strings.Join(removed, ", "))
+	}
+}
@@ -0,0 +1,110 @@
+package openapi
+
+import (
+	"strings"
+	"testing"
+)
+
+const accounts = `swagger: "2.0"
+info:
+  title: Accounts
+  version: "1.0"
+paths:
+  /accounts:
+    get:
+      parameters:
+        - name: limit
+          in: query
+          type: number
+          maximum: 100
+      responses:
+        200:
+          description: Accounts.
+          schema:
+            type: array
+            items:
+              $ref: '#/definitions/Account'
+    post:
+      parameters:
+        - name: account
+          in: body
+          schema:
+            $ref: '#/definitions/Account'
+      responses:
+        201:
+          description: Created.
+  /accounts/{id}:
+    delete:
+      responses:
+        204:
+          description: Deleted.
+definitions:
+  Account:
+    type: object
+    required: [id]
+    properties:
+      id:
+        type: string
+      status:
+        type: string
+        enum: [open, closed]
+      balance:
+        type: number
+`
+
+func TestCompareUnchanged(t *testing.T) {
+	changes, err := Compare([]byte(accounts), []byte(accounts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) > 0 {
+		t.Errorf("Unexpected changes %+v", changes)
+	}
+}
+
+func TestCompareBreaking(t *testing.T) {
+	tests := []struct {
+		old, new string
+		kind     string
+		path     string
+	}{
+		{"  /accounts/{id}:\n    delete:", "  /accounts/{id}:\n    x-delete:", OperationRemoved, "paths./accounts/{id}.delete"},
+		{"  /accounts/{id}:", "  /accounts/{id}/x:", PathRemoved, "paths./accounts/{id}"},
+		{"          type: number\n          maximum: 100", "          type: number\n          maximum: 100\n          required: true", ParameterRequired, "paths./accounts.get.parameters.query:limit"},
+		{"          type: number\n          maximum: 100", "          type: integer\n          maximum: 100", TypeNarrowed, "paths./accounts.get.parameters.query:limit"},
+		{"maximum: 100", "maximum: 50", TypeNarrowed, "paths./accounts.get.parameters.query:limit.maximum"},
+		{"enum: [open, closed]", "enum: [open]", TypeNarrowed, "paths./accounts.post.parameters.body.schema.properties.status.enum"},
+		{"required: [id]", "required: [id, status]", ParameterRequired, "paths./accounts.post.parameters.body.schema.properties.status"},
+		{"      balance:\n        type: number\n", "", ResponseFieldRemoved, "paths./accounts.get.responses.200.schema.items.properties.balance"},
+		{"      balance:\n        type: number\n", "      balance:\n        type: string\n", TypeChanged, "paths./accounts.get.responses.200.schema.items.properties.balance"},
+		{"        201:", "        202:", ResponseRemoved, "paths./accounts.post.responses.201"},
+	}
+
+	for _, test := range tests {
+		changed := strings.Replace(accounts, test.old, test.new, 1)
+		changes, err := Compare([]byte(accounts), []byte(changed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, change := range changes {
+			found = found || change.Kind == test.kind && change.Path == test.path
+		}
+		if !found {
+			t.Errorf("Expected %s at %s, got %+v", test.kind, test.path, changes)
+		}
+	}
+}
+
+func TestCompareCompatible(t *testing.T) {
+	compatible := strings.Replace(accounts, "maximum: 100", "maximum: 200", 1)
+	compatible = strings.Replace(compatible, "enum: [open, closed]", "enum: [open, closed, frozen]", 1)
+	compatible = strings.Replace(compatible, "  /accounts/{id}:", "  /accounts/{accountId}:", 1)
+	changes, err := Compare([]byte(accounts), []byte(compatible))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) > 0 {
+		t.Errorf("Unexpected changes %+v", changes)
+	}
+}
@@ -0,0 +1,610 @@
+package openapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"strings"
+)
+
+// ConvertToOpenAPI3 converts a Swagger 2.0 document to OpenAPI 3.0.3 and
+// returns it as YAML.  Body and form parameters become request bodies,
+// schemas of parameters, responses and headers move under schema and
+// content, definitions and the other shared objects move under components
+// and references to them are rewritten.  OpenAPI 3 documents are returned
+// unchanged.
+func ConvertToOpenAPI3(data []byte) ([]byte, error) {
+	root, errs := Parse(data)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	if child(root, "openapi") != nil {
+		return data, nil
+	}
+	swagger := child(root, "swagger")
+	if swagger == nil {
+		return nil, errors.New("not a Swagger or OpenAPI document")
+	}
+	if swagger.Value != "2.0" {
+		return nil, fmt.Errorf("can't convert Swagger %s", swagger.Value)
+	}
+
+	c := &converter{
+		consumes: stringList(child(root, "consumes"), "application/json"),
+		produces: stringList(child(root, "produces"), "application/json"),
+		bodies:   make(map[string]bool),
+	}
+	doc := c.document(root)
+	plain(doc)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type converter struct {
+	consumes, produces []string
+	// bodies holds the names of the shared parameters that are body or form
+	// parameters, which become request bodies.
+	bodies map[string]bool
+}
+
+// schemaKeys are the fields of a Swagger 2.0 parameter or header that
+// describe its value, which moves under schema in OpenAPI 3.
+var schemaKeys = []string{
+	"type", "format", "items", "default", "maximum", "exclusiveMaximum", "minimum", "exclusiveMinimum",
+	"maxLength", "minLength", "pattern", "maxItems", "minItems", "uniqueItems", "enum", "multipleOf",
+}
+
+func str(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+func boolean(value bool) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: fmt.Sprint(value)}
+}
+
+func newMapping() *yaml.Node {
+	return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+}
+
+// set sets key to value in mapping n, replacing an existing value.
+func set(n *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			n.Content[i+1] = value
+			return
+		}
+	}
+	n.Content = append(n.Content, str(key), value)
+}
+
+// stringList returns the strings in sequence n, or defaults when there are
+// none.
+func stringList(n *yaml.Node, defaults ...string) []string {
+	if n == nil || n.Kind != yaml.SequenceNode || len(n.Content) == 0 {
+		return defaults
+	}
+	list := make([]string, 0, len(n.Content))
+	for _, elem := range n.Content {
+		list = append(list, resolve(elem).Value)
+	}
+	return list
+}
+
+// paramIn returns where a parameter goes, or "" for references.
+func paramIn(param *yaml.Node) string {
+	if in := child(param, "in"); in != nil {
+		return in.Value
+	}
+	return ""
+}
+
+// plain drops the flow and quoting styles of n and everything under it, so
+// that documents written in JSON come out as block-style YAML.
+func plain(n *yaml.Node) {
+	if n.Style&(yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+		n.Style = 0
+	}
+	for _, c := range n.Content {
+		plain(c)
+	}
+}
+
+func (c *converter) document(root *yaml.Node) *yaml.Node {
+	// Shared body and form parameters have to be known before any reference
+	// to them is converted.
+	Walk(child(root, "parameters"), func(name, param *yaml.Node) {
+		if in := paramIn(param); in == "body" || in == "formData" {
+			c.bodies[name.Value] = true
+		}
+	})
+
+	doc := newMapping()
+	components := newMapping()
+	serversDone := false
+	Walk(root, func(key, value *yaml.Node) {
+		switch key.Value {
+		case "swagger":
+			set(doc, "openapi", str("3.0.3"))
+		case "host", "basePath", "schemes":
+			if !serversDone {
+				set(doc, "servers", servers(root))
+				serversDone = true
+			}
+		case "consumes", "produces":
+		case "paths":
+			paths := newMapping()
+			Walk(value, func(path, item *yaml.Node) {
+				set(paths, path.Value, c.pathItem(item))
+			})
+			set(doc, "paths", paths)
+		case "definitions":
+			schemas := newMapping()
+			Walk(value, func(name, schema *yaml.Node) {
+				set(schemas, name.Value, c.schema(schema))
+			})
+			set(components, "schemas", schemas)
+		case "parameters":
+			params, bodies := newMapping(), newMapping()
+			Walk(value, func(name, param *yaml.Node) {
+				switch paramIn(param) {
+				case "body":
+					set(bodies, name.Value, c.requestBody(param, c.consumes))
+				case "formData":
+					set(bodies, name.Value, c.formBody([]*yaml.Node{param}, c.consumes))
+				default:
+					set(params, name.Value, c.parameter(param))
+				}
+			})
+			if len(params.Content) > 0 {
+				set(components, "parameters", params)
+			}
+			if len(bodies.Content) > 0 {
+				set(components, "requestBodies", bodies)
+			}
+		case "responses":
+			responses := newMapping()
+			Walk(value, func(name, response *yaml.Node) {
+				set(responses, name.Value, c.response(response, c.produces))
+			})
+			set(components, "responses", responses)
+		case "securityDefinitions":
+			schemes := newMapping()
+			Walk(value, func(name, scheme *yaml.Node) {
+				set(schemes, name.Value, securityScheme(scheme))
+			})
+			set(components, "securitySchemes", schemes)
+		default:
+			set(doc, key.Value, value)
+		}
+	})
+	if len(components.Content) > 0 {
+		set(doc, "components", components)
+	}
+	return doc
+}
+
+// servers turns host, basePath and schemes into a server for each scheme.
+func servers(root *yaml.Node) *yaml.Node {
+	host, base := "", ""
+	if n := child(root, "host"); n != nil {
+		host = "//" + n.Value
+	}
+	if n := child(root, "basePath"); n != nil {
+		base = n.Value
+	}
+	list := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, scheme := range stringList(child(root, "schemes"), "") {
+		url := host + base
+		if len(scheme) > 0 && len(host) > 0 {
+			url = scheme + ":" + url
+		}
+		if len(url) == 0 {
+			url = "/"
+		}
+		server := newMapping()
+		set(server, "url", str(url))
+		list.Content = append(list.Content, server)
+	}
+	return list
+}
+
+// ref rewrites a reference to a Swagger 2.0 definition, parameter or
+// response to the component it became.
+func (c *converter) ref(ref string) string {
+	switch {
+	case strings.HasPrefix(ref, "#/definitions/"):
+		return "#/components/schemas/" + strings.TrimPrefix(ref, "#/definitions/")
+	case strings.HasPrefix(ref, "#/parameters/"):
+		name := strings.TrimPrefix(ref, "#/parameters/")
+		if c.bodies[name] {
+			return "#/components/requestBodies/" + name
+		}
+		return "#/components/parameters/" + name
+	case strings.HasPrefix(ref, "#/responses/"):
+		return "#/components/responses/" + strings.TrimPrefix(ref, "#/responses/")
+	}
+	return ref
+}
+
+// refNode returns a mapping holding just the rewritten reference of n, or
+// nil when n isn't a reference.
+func (c *converter) refNode(n *yaml.Node) *yaml.Node {
+	ref := child(n, "$ref")
+	if ref == nil {
+		return nil
+	}
+	out := newMapping()
+	set(out, "$ref", str(c.ref(ref.Value)))
+	return out
+}
+
+// schema converts a schema: references, file types, x-nullable and string
+// discriminators, in n and every schema under it.
+func (c *converter) schema(n *yaml.Node) *yaml.Node {
+	n = resolve(n)
+	if n == nil || n.Kind != yaml.MappingNode {
+		return n
+	}
+	if ref := c.refNode(n); ref != nil {
+		return ref
+	}
+	out := newMapping()
+	Walk(n, func(key, value *yaml.Node) {
+		switch key.Value {
+		case "type":
+			if value.Value == "file" {
+				set(out, "type", str("string"))
+				set(out, "format", str("binary"))
+				return
+			}
+			set(out, "type", value)
+		case "format":
+			if child(n, "type") == nil || child(n, "type").Value != "file" {
+				set(out, "format", value)
+			}
+		case "x-nullable":
+			set(out, "nullable", value)
+		case "discriminator":
+			if value.Kind == yaml.ScalarNode {
+				discriminator := newMapping()
+				set(discriminator, "propertyName", value)
+				set(out, "discriminator", discriminator)
+			} else {
+				set(out, "discriminator", value)
+			}
+		case "collectionFormat":
+		case "items", "additionalProperties", "not":
+			set(out, key.Value, c.schema(value))
+		case "allOf", "anyOf", "oneOf":
+			list := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			for _, elem := range value.Content {
+				list.Content = append(list.Content, c.schema(elem))
+			}
+			set(out, key.Value, list)
+		case "properties":
+			properties := newMapping()
+			Walk(value, func(name, property *yaml.Node) {
+				set(properties, name.Value, c.schema(property))
+			})
+			set(out, "properties", properties)
+		default:
+			set(out, key.Value, value)
+		}
+	})
+	return out
+}
+
+// valueSchema moves the fields of a parameter or header that describe its
+// value into a schema.
+func (c *converter) valueSchema(n *yaml.Node) *yaml.Node {
+	schema := newMapping()
+	Walk(n, func(key, value *yaml.Node) {
+		if contains(schemaKeys, key.Value) {
+			set(schema, key.Value, value)
+		}
+	})
+	return c.schema(schema)
+}
+
+// parameter converts a parameter other than a body or form parameter.
+func (c *converter) parameter(n *yaml.Node) *yaml.Node {
+	if ref := c.refNode(n); ref != nil {
+		return ref
+	}
+	out := newMapping()
+	Walk(n, func(key, value *yaml.Node) {
+		switch {
+		case key.Value == "collectionFormat":
+			switch value.Value {
+			case "ssv":
+				set(out, "style", str("spaceDelimited"))
+			case "pipes":
+				set(out, "style", str("pipeDelimited"))
+			case "multi":
+				set(out, "style", str("form"))
+				set(out, "explode", boolean(true))
+			case "csv":
+				if in := child(n, "in"); in != nil && in.Value == "query" {
+					set(out, "style", str("form"))
+					set(out, "explode", boolean(false))
+				}
+			}
+		case !contains(schemaKeys, key.Value):
+			set(out, key.Value, value)
+		}
+	})
+	set(out, "schema", c.valueSchema(n))
+	return out
+}
+
+// requestBody converts a body parameter.
+func (c *converter) requestBody(n *yaml.Node, consumes []string) *yaml.Node {
+	body := newMapping()
+	if description := child(n, "description"); description != nil {
+		set(body, "description", description)
+	}
+	content := newMapping()
+	for _, mediaType := range consumes {
+		media := newMapping()
+		set(media, "schema", c.schema(child(n, "schema")))
+		set(content, mediaType, media)
+	}
+	set(body, "content", content)
+	if required := child(n, "required"); required != nil {
+		set(body, "required", required)
+	}
+	return body
+}
+
+// formBody converts form parameters into a request body whose schema has a
+// property for each of them.
+func (c *converter) formBody(params []*yaml.Node, consumes []string) *yaml.Node {
+	properties := newMapping()
+	required := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	multipart := false
+	for _, param := range params {
+		name := child(param, "name").Value
+		property := c.valueSchema(param)
+		if description := child(param, "description"); description != nil {
+			set(property, "description", description)
+		}
+		set(properties, name, property)
+		if isTrue(child(param, "required")) {
+			required.Content = append(required.Content, str(name))
+		}
+		if t := child(param, "type"); t != nil && t.Value == "file" {
+			multipart = true
+		}
+	}
+	schema := newMapping()
+	set(schema, "type", str("object"))
+	set(schema, "properties", properties)
+	if len(required.Content) > 0 {
+		set(schema, "required", required)
+	}
+
+	var mediaTypes []string
+	for _, mediaType := range consumes {
+		if mediaType == "multipart/form-data" || mediaType == "application/x-www-form-urlencoded" {
+			mediaTypes = append(mediaTypes, mediaType)
+		}
+	}
+	if len(mediaTypes) == 0 {
+		mediaTypes = []string{"application/x-www-form-urlencoded"}
+		if multipart {
+			mediaTypes = []string{"multipart/form-data"}
+		}
+	}
+	content := newMapping()
+	for _, mediaType := range mediaTypes {
+		media := newMapping()
+		set(media, "schema", schema)
+		set(content, mediaType, media)
+	}
+	body := newMapping()
+	set(body, "content", content)
+	return body
+}
+
+// pathItem converts a path item.  Body and form parameters shared by its
+// operations are moved into the request body of each of them.
+func (c *converter) pathItem(n *yaml.Node) *yaml.Node {
+	params, bodyParams := c.splitParameters(child(n, "parameters"))
+	out := newMapping()
+	Walk(n, func(key, value *yaml.Node) {
+		switch {
+		case key.Value == "parameters":
+			if len(params.Content) > 0 {
+				set(out, "parameters", params)
+			}
+		case key.Value == "$ref":
+			set(out, "$ref", value)
+		case contains(methods, key.Value):
+			set(out, key.Value, c.operation(value, bodyParams))
+		default:
+			set(out, key.Value, value)
+		}
+	})
+	return out
+}
+
+// splitParameters converts a list of parameters, setting the body and form
+// parameters apart.
+func (c *converter) splitParameters(n *yaml.Node) (*yaml.Node, []*yaml.Node) {
+	params := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	var bodyParams []*yaml.Node
+	if n == nil {
+		return params, nil
+	}
+	for _, param := range n.Content {
+		param = resolve(param)
+		ref, in := child(param, "$ref"), paramIn(param)
+		switch {
+		case ref != nil && c.bodies[strings.TrimPrefix(ref.Value, "#/parameters/")]:
+			bodyParams = append(bodyParams, param)
+		case in == "body" || in == "formData":
+			bodyParams = append(bodyParams, param)
+		default:
+			params.Content = append(params.Content, c.parameter(param))
+		}
+	}
+	return params, bodyParams
+}
+
+func (c *converter) operation(n *yaml.Node, inherited []*yaml.Node) *yaml.Node {
+	consumes := stringList(child(n, "consumes"), c.consumes...)
+	produces := stringList(child(n, "produces"), c.produces...)
+	params, bodyParams := c.splitParameters(child(n, "parameters"))
+	bodyParams = append(append([]*yaml.Node(nil), inherited...), bodyParams...)
+
+	var body *yaml.Node
+	var formParams []*yaml.Node
+	for _, param := range bodyParams {
+		switch {
+		case child(param, "$ref") != nil:
+			body = c.refNode(param)
+		case paramIn(param) == "body":
+			body = c.requestBody(param, consumes)
+		default:
+			formParams = append(formParams, param)
+		}
+	}
+	if len(formParams) > 0 {
+		body = c.formBody(formParams, consumes)
+	}
+
+	out := newMapping()
+	bodyDone := false
+	addBody := func() {
+		if body != nil && !bodyDone {
+			set(out, "requestBody", body)
+		}
+		bodyDone = true
+	}
+	Walk(n, func(key, value *yaml.Node) {
+		switch key.Value {
+		case "consumes", "produces", "schemes":
+		case "parameters":
+			if len(params.Content) > 0 {
+				set(out, "parameters", params)
+			}
+			addBody()
+		case "responses":
+			addBody()
+			responses := newMapping()
+			Walk(value, func(code, response *yaml.Node) {
+				set(responses, code.Value, c.response(response, produces))
+			})
+			set(out, "responses", responses)
+		default:
+			set(out, key.Value, value)
+		}
+	})
+	addBody()
+	return out
+}
+
+func (c *converter) response(n *yaml.Node, produces []string) *yaml.Node {
+	if ref := c.refNode(n); ref != nil {
+		return ref
+	}
+	schema, examples := child(n, "schema"), child(n, "examples")
+	content := newMapping()
+	if schema != nil {
+		for _, mediaType := range produces {
+			media := newMapping()
+			set(media, "schema", c.schema(schema))
+			set(content, mediaType, media)
+		}
+	}
+	Walk(examples, func(mediaType, example *yaml.Node) {
+		media := child(content, mediaType.Value)
+		if media == nil {
+			media = newMapping()
+			set(content, mediaType.Value, media)
+		}
+		set(media, "example", example)
+	})
+
+	out := newMapping()
+	Walk(n, func(key, value *yaml.Node) {
+		switch key.Value {
+		case "schema", "examples":
+		case "headers":
+			headers := newMapping()
+			Walk(value, func(name, header *yaml.Node) {
+				h := newMapping()
+				Walk(header, func(key, value *yaml.Node) {
+					if !contains(schemaKeys, key.Value) && key.Value != "collectionFormat" {
+						set(h, key.Value, value)
+					}
+				})
+				set(h, "schema", c.valueSchema(header))
+				set(headers, name.Value, h)
+			})
+			set(out, "headers", headers)
+		default:
+			set(out, key.Value, value)
+		}
+	})
+	if len(content.Content) > 0 {
+		set(out, "content", content)
+	}
+	return out
+}
+
+// oauthFlows maps the Swagger 2.0 OAuth2 flows to their OpenAPI 3 names.
+var oauthFlows = map[string]string{
+	"implicit":    "implicit",
+	"password":    "password",
+	"application": "clientCredentials",
+	"accessCode":  "authorizationCode",
+}
+
+func securityScheme(n *yaml.Node) *yaml.Node {
+	t := child(n, "type")
+	if t == nil {
+		return n
+	}
+	out := newMapping()
+	switch t.Value {
+	case "basic":
+		set(out, "type", str("http"))
+		set(out, "scheme", str("basic"))
+		if description := child(n, "description"); description != nil {
+			set(out, "description", description)
+		}
+	case "oauth2":
+		set(out, "type", str("oauth2"))
+		if description := child(n, "description"); description != nil {
+			set(out, "description", description)
+		}
+		flow := newMapping()
+		for _, key := range []string{"authorizationUrl", "tokenUrl", "scopes"} {
+			if value := child(n, key); value != nil {
+				set(flow, key, value)
+			}
+		}
+		if child(flow, "scopes") == nil {
+			set(flow, "scopes", newMapping())
+		}
+		flows := newMapping()
+		if name := child(n, "flow"); name != nil && len(oauthFlows[name.Value]) > 0 {
+			set(flows, oauthFlows[name.Value], flow)
+		}
+		set(out, "flows", flows)
+	default:
+		return n
+	}
+	return out
+}
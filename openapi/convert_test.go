@@ -0,0 +1,146 @@
+package openapi
+
+import (
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"testing"
+)
+
+const petstoreForms = `{
+  "swagger": "2.0",
+  "info": {"title": "Petstore", "version": "1.0"},
+  "host": "pets.example.com",
+  "basePath": "/v1",
+  "schemes": ["https"],
+  "produces": ["application/json"],
+  "securityDefinitions": {
+    "basic": {"type": "basic"},
+    "oauth": {"type": "oauth2", "flow": "accessCode", "authorizationUrl": "https://example.com/auth", "tokenUrl": "https://example.com/token", "scopes": {"read": "Read pets"}}
+  },
+  "parameters": {
+    "Limit": {"name": "limit", "in": "query", "type": "integer", "maximum": 100},
+    "NewPet": {"name": "pet", "in": "body", "required": true, "schema": {"$ref": "#/definitions/Pet"}}
+  },
+  "paths": {
+    "/pets": {
+      "get": {
+        "parameters": [
+          {"$ref": "#/parameters/Limit"},
+          {"name": "tags", "in": "query", "type": "array", "items": {"type": "string"}, "collectionFormat": "csv"}
+        ],
+        "responses": {
+          "200": {
+            "description": "The pets.",
+            "schema": {"type": "array", "items": {"$ref": "#/definitions/Pet"}},
+            "headers": {"X-Total": {"type": "integer", "description": "How many there are."}},
+            "examples": {"application/json": [{"id": 1}]}
+          }
+        }
+      },
+      "post": {
+        "consumes": ["application/json", "application/xml"],
+        "parameters": [{"$ref": "#/parameters/NewPet"}],
+        "responses": {"201": {"$ref": "#/responses/Created"}}
+      }
+    },
+    "/pets/{petId}/photo": {
+      "parameters": [{"name": "petId", "in": "path", "required": true, "type": "string"}],
+      "put": {
+        "consumes": ["multipart/form-data"],
+        "parameters": [
+          {"name": "photo", "in": "formData", "type": "file", "required": true},
+          {"name": "caption", "in": "formData", "type": "string"}
+        ],
+        "responses": {"204": {"description": "Uploaded."}}
+      }
+    }
+  },
+  "responses": {"Created": {"description": "Created.", "schema": {"$ref": "#/definitions/Pet"}}},
+  "definitions": {
+    "Pet": {
+      "type": "object",
+      "discriminator": "kind",
+      "properties": {
+        "id": {"type": "integer"},
+        "kind": {"type": "string"},
+        "owner": {"$ref": "#/definitions/Owner"}
+      }
+    },
+    "Owner": {"type": "object", "x-nullable": true}
+  }
+}`
+
+func TestConvertToOpenAPI3(t *testing.T) {
+	data, err := ConvertToOpenAPI3([]byte(petstoreForms))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := Validate(data); len(errs) > 0 {
+		t.Fatalf("Converted document isn't valid: %v\n%s", errs, data)
+	}
+	root, _ := Parse(data)
+	get := func(ref string) interface{} {
+		var v interface{}
+		if n := LookupRef(root, ref); n != nil {
+			n.Decode(&v)
+		}
+		return v
+	}
+
+	checks := []struct {
+		ref  string
+		want interface{}
+	}{
+		{"#/openapi", "3.0.3"},
+		{"#/servers/0/url", "https://pets.example.com/v1"},
+		{"#/components/securitySchemes/basic", map[string]interface{}{"type": "http", "scheme": "basic"}},
+		{"#/components/securitySchemes/oauth/flows/authorizationCode/tokenUrl", "https://example.com/token"},
+		{"#/components/parameters/Limit/schema", map[string]interface{}{"type": "integer", "maximum": 100}},
+		{"#/components/requestBodies/NewPet/content/application~1json/schema/$ref", "#/components/schemas/Pet"},
+		{"#/paths/~1pets/get/parameters/0/$ref", "#/components/parameters/Limit"},
+		{"#/paths/~1pets/get/parameters/1/style", "form"},
+		{"#/paths/~1pets/get/parameters/1/explode", false},
+		{"#/paths/~1pets/get/parameters/1/schema/items/type", "string"},
+		{"#/paths/~1pets/get/responses/200/content/application~1json/schema/items/$ref", "#/components/schemas/Pet"},
+		{"#/paths/~1pets/get/responses/200/content/application~1json/example/0/id", 1},
+		{"#/paths/~1pets/get/responses/200/headers/X-Total/schema/type", "integer"},
+		{"#/paths/~1pets/post/requestBody/$ref", "#/components/requestBodies/NewPet"},
+		{"#/paths/~1pets/post/responses/201/$ref", "#/components/responses/Created"},
+		{"#/paths/~1pets~1{petId}~1photo/parameters/0/schema/type", "string"},
+		{"#/paths/~1pets~1{petId}~1photo/put/requestBody/content/multipart~1form-data/schema/properties/photo/format", "binary"},
+		{"#/paths/~1pets~1{petId}~1photo/put/requestBody/content/multipart~1form-data/schema/required", []interface{}{"photo"}},
+		{"#/components/schemas/Pet/discriminator/propertyName", "kind"},
+		{"#/components/schemas/Pet/properties/owner/$ref", "#/components/schemas/Owner"},
+		{"#/components/schemas/Owner/nullable", true},
+	}
+	for _, check := range checks {
+		if got := get(check.ref); !reflect.DeepEqual(got, check.want) {
+			t.Errorf("%s = %#v, want %#v", check.ref, got, check.want)
+		}
+	}
+	for _, gone := range []string{"#/swagger", "#/definitions", "#/host", "#/produces", "#/paths/~1pets/post/consumes"} {
+		if LookupRef(root, gone) != nil {
+			t.Errorf("%s is still there", gone)
+		}
+	}
+
+	// The document comes out as block-style YAML even though it came in as
+	// JSON.
+	var doc yaml.Node
+	yaml.Unmarshal(data, &doc)
+	if doc.Content[0].Style&yaml.FlowStyle != 0 {
+		t.Errorf("Converted to flow style:\n%s", data)
+	}
+}
+
+func TestConvertOpenAPI3(t *testing.T) {
+	if data, err := ConvertToOpenAPI3([]byte(petstore3)); err != nil || string(data) != petstore3 {
+		t.Errorf("An OpenAPI 3 document was changed: %v", err)
+	}
+	if _, err := ConvertToOpenAPI3([]byte("title: Pets")); err == nil {
+		t.Error("Converted something that isn't a spec")
+	}
+	if _, err := ConvertToOpenAPI3([]byte("swagger: [")); err == nil {
+		t.Error("Converted invalid YAML")
+	}
+}
@@ -0,0 +1,129 @@
+package openapi
+
+import (
+	"errors"
+	"gopkg.in/yaml.v3"
+	"strings"
+)
+
+// An Element is something a document defines, such as a path or a schema,
+// along with the line it's defined on.
+type Element struct {
+	Name string `json:"name"`
+	Line int    `json:"line"`
+}
+
+// An Operation is a method on a path, named as in "get /pets/{petId}".
+type Operation struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	OperationId string `json:"operationId,omitempty"`
+	Line        int    `json:"line"`
+}
+
+// An Outline lists what a document defines, for searching and indexing.
+type Outline struct {
+	Paths       []Element   `json:"paths"`
+	Operations  []Operation `json:"operations"`
+	Definitions []Element   `json:"definitions"`
+}
+
+// Summarize returns the outline of a Swagger 2.0 or OpenAPI 3 document.
+// Definitions are the entries of definitions in Swagger 2.0 and of
+// components.schemas in OpenAPI 3.
+func Summarize(data []byte) (*Outline, error) {
+	root, errs := Parse(data)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	if child(root, "swagger") == nil && child(root, "openapi") == nil {
+		return nil, errors.New("not a Swagger or OpenAPI document")
+	}
+
+	outline := &Outline{}
+	Walk(child(root, "paths"), func(key, item *yaml.Node) {
+		outline.Paths = append(outline.Paths, Element{key.Value, key.Line})
+		Walk(item, func(method, op *yaml.Node) {
+			if !contains(methods, method.Value) {
+				return
+			}
+			operation := Operation{Method: method.Value, Path: key.Value, Line: method.Line}
+			if id := child(op, "operationId"); id != nil {
+				operation.OperationId = id.Value
+			}
+			outline.Operations = append(outline.Operations, operation)
+		})
+	})
+
+	definitions := child(root, "definitions")
+	if definitions == nil {
+		definitions = child(child(root, "components"), "schemas")
+	}
+	Walk(definitions, func(key, _ *yaml.Node) {
+		outline.Definitions = append(outline.Definitions, Element{key.Value, key.Line})
+	})
+	return outline, nil
+}
+
+// SamePath tells whether two path templates name the same path, whatever
+// their parameters are called, so that /accounts/{id} matches
+// /accounts/{accountId}.
+func SamePath(a, b string) bool {
+	return normalizePath(strings.TrimRight(a, "/")) == normalizePath(strings.TrimRight(b, "/"))
+}
+
+// A Section is the range of lines a part of a document takes up, named by its
+// path such as paths./pets/{petId}.get or definitions.Pet.
+type Section struct {
+	Path      string `json:"path"`
+	StartLine int    `json:"startLine"`
+	EndLine   int    `json:"endLine"`
+}
+
+// Sections returns the top level entries of a document, its paths and their
+// operations and every named definition, parameter, response and component.
+// A section ends where the next entry at the same level starts, so it also
+// takes in comments and blank lines that follow it.
+func Sections(data []byte) ([]Section, error) {
+	root, errs := Parse(data)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	lines := strings.Count(string(data), "\n")
+	if !strings.HasSuffix(string(data), "\n") {
+		lines++
+	}
+
+	var sections []Section
+	var add func(n *yaml.Node, path []string, end int, deeper func([]string) bool)
+	add = func(n *yaml.Node, path []string, end int, deeper func([]string) bool) {
+		n = resolve(n)
+		if n == nil || n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			sectionEnd := end
+			if i+2 < len(n.Content) {
+				sectionEnd = n.Content[i+2].Line - 1
+			}
+			sectionPath := extend(path, key.Value)
+			sections = append(sections, Section{strings.Join(sectionPath, "."), key.Line, sectionEnd})
+			if deeper(sectionPath) {
+				add(n.Content[i+1], sectionPath, sectionEnd, deeper)
+			}
+		}
+	}
+	add(root, nil, lines, func(path []string) bool {
+		switch path[0] {
+		case "paths":
+			return len(path) < 3
+		case "definitions", "parameters", "responses", "securityDefinitions":
+			return len(path) < 2
+		case "components":
+			return len(path) < 3
+		}
+		return false
+	})
+	return sections, nil
+}
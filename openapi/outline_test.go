@@ -0,0 +1,62 @@
+package openapi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSummarize(t *testing.T) {
+	outline, err := Summarize([]byte(petstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Outline{
+		Paths:       []Element{{"/pets/{petId}", 7}},
+		Operations:  []Operation{{Method: "get", Path: "/pets/{petId}", OperationId: "getPet", Line: 8}},
+		Definitions: []Element{{"Pet", 21}},
+	}
+	if !reflect.DeepEqual(outline, want) {
+		t.Errorf("Got %+v", outline)
+	}
+
+	outline, err = Summarize([]byte(petstore3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outline.Operations) != 1 || outline.Operations[0].Method != "post" || outline.Definitions[0].Name != "Pet" {
+		t.Errorf("Got %+v", outline)
+	}
+
+	if _, err = Summarize([]byte("name: not a spec\n")); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+func TestSamePath(t *testing.T) {
+	if !SamePath("/accounts/{id}", "/accounts/{accountId}/") {
+		t.Error("Templates with different parameter names differ")
+	}
+	if SamePath("/accounts/{id}", "/accounts/{id}/owners") {
+		t.Error("Different paths are the same")
+	}
+}
+
+func TestSections(t *testing.T) {
+	sections, err := Sections([]byte(petstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Section{
+		{"swagger", 1, 1},
+		{"info", 2, 4},
+		{"basePath", 5, 5},
+		{"paths", 6, 19},
+		{"paths./pets/{petId}", 7, 19},
+		{"paths./pets/{petId}.get", 8, 19},
+		{"definitions", 20, 30},
+		{"definitions.Pet", 21, 30},
+	}
+	if !reflect.DeepEqual(sections, want) {
+		t.Errorf("Got %+v", sections)
+	}
+}
@@ -0,0 +1,656 @@
+// Package openapi checks and transforms Swagger 2.0 and OpenAPI 3 documents
+// written in either YAML or JSON.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A ValidationError describes one problem found in a document.  Line and
+// Column are 1-based and zero when the problem can't be pinned to a position.
+type ValidationError struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	if len(e.Path) == 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Path, e.Message)
+}
+
+var (
+	yamlErrorLine  = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+	openapi3       = regexp.MustCompile(`^3\.\d+\.\d+$`)
+	responseCode   = regexp.MustCompile(`^[1-5](\d\d|XX)$`)
+	pathTemplate   = regexp.MustCompile(`\{([^}]+)\}`)
+	swagger2Params = []string{"query", "header", "path", "formData", "body"}
+	openapi3Params = []string{"query", "header", "path", "cookie"}
+	schemaTypes    = []string{"string", "number", "integer", "boolean", "array", "object", "null"}
+	schemes        = []string{"http", "https", "ws", "wss"}
+	methods        = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+)
+
+// Parse reads a YAML or JSON document, keeping the position of every node.
+func Parse(data []byte) (*yaml.Node, []ValidationError) {
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			if syntaxErr, ok := err.(*json.SyntaxError); ok {
+				line, column := position(data, syntaxErr.Offset)
+				return nil, []ValidationError{{Line: line, Column: column, Message: syntaxErr.Error()}}
+			}
+			return nil, []ValidationError{{Message: err.Error()}}
+		}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			return nil, []ValidationError{{Line: line, Message: m[2]}}
+		}
+		return nil, []ValidationError{{Message: err.Error()}}
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil, []ValidationError{{Line: 1, Message: "document is empty"}}
+	}
+	return doc.Content[0], nil
+}
+
+// position converts a byte offset into a line and column.
+func position(data []byte, offset int64) (int, int) {
+	line, column := 1, 1
+	for i := int64(0); i < offset && i < int64(len(data)); i++ {
+		if data[i] == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return line, column
+}
+
+// Validate checks that data parses as YAML or JSON and follows the structure
+// required by the Swagger 2.0 or OpenAPI 3 specification, whichever the
+// document declares.  It returns every problem it finds, in document order.
+func Validate(data []byte) []ValidationError {
+	root, errs := Parse(data)
+	if errs != nil {
+		return errs
+	}
+	v := &validator{operationIds: make(map[string]bool)}
+	v.document(root)
+	return v.errs
+}
+
+// ValidateFile checks a file of a collection of specs.  Documents are
+// validated as Validate does; other files, such as the fragments documents
+// refer to with $ref, only have to parse as a mapping.  A file counts as a
+// document when it declares swagger or openapi, or has the info or paths a
+// document has, so that a misspelt version doesn't pass for a fragment.
+func ValidateFile(data []byte) []ValidationError {
+	root, errs := Parse(data)
+	if errs != nil {
+		return errs
+	}
+	for _, key := range []string{"swagger", "openapi", "info", "paths"} {
+		if child(root, key) != nil {
+			return Validate(data)
+		}
+	}
+	v := &validator{}
+	v.mapping(root, nil)
+	return v.errs
+}
+
+type validator struct {
+	version      int
+	errs         []ValidationError
+	operationIds map[string]bool
+}
+
+func (v *validator) errorf(n *yaml.Node, path []string, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{
+		Line:    n.Line,
+		Column:  n.Column,
+		Path:    strings.Join(path, "."),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// resolve follows YAML aliases to the node they stand for.
+func resolve(n *yaml.Node) *yaml.Node {
+	for n != nil && n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	return n
+}
+
+// child returns the value of key in mapping n, or nil.
+func child(n *yaml.Node, key string) *yaml.Node {
+	n = resolve(n)
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return resolve(n.Content[i+1])
+		}
+	}
+	return nil
+}
+
+// Walk calls fn for each key and value of mapping n, in document order.
+func Walk(n *yaml.Node, fn func(key, value *yaml.Node)) {
+	n = resolve(n)
+	if n == nil || n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		fn(n.Content[i], resolve(n.Content[i+1]))
+	}
+}
+
+func extend(path []string, elems ...string) []string {
+	return append(append([]string(nil), path...), elems...)
+}
+
+func contains(list []string, s string) bool {
+	for _, elem := range list {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *validator) mapping(n *yaml.Node, path []string) bool {
+	if n.Kind != yaml.MappingNode {
+		v.errorf(n, path, "must be an object")
+		return false
+	}
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := n.Content[i]
+		if seen[key.Value] {
+			v.errorf(key, path, "duplicate key %q", key.Value)
+		}
+		seen[key.Value] = true
+	}
+	return true
+}
+
+func (v *validator) sequence(n *yaml.Node, path []string) bool {
+	if n.Kind != yaml.SequenceNode {
+		v.errorf(n, path, "must be an array")
+		return false
+	}
+	return true
+}
+
+func (v *validator) str(n *yaml.Node, path []string) bool {
+	if n.Kind != yaml.ScalarNode || n.Tag != "!!str" {
+		v.errorf(n, path, "must be a string")
+		return false
+	}
+	return true
+}
+
+func (v *validator) boolean(n *yaml.Node, path []string) bool {
+	if n.Kind != yaml.ScalarNode || n.Tag != "!!bool" {
+		v.errorf(n, path, "must be a boolean")
+		return false
+	}
+	return true
+}
+
+// required reports the keys missing from mapping n.
+func (v *validator) required(n *yaml.Node, path []string, keys ...string) {
+	for _, key := range keys {
+		if child(n, key) == nil {
+			v.errorf(n, path, "missing required field %q", key)
+		}
+	}
+}
+
+func (v *validator) document(root *yaml.Node) {
+	if !v.mapping(root, nil) {
+		return
+	}
+
+	swagger, oas := child(root, "swagger"), child(root, "openapi")
+	switch {
+	case swagger != nil:
+		if swagger.Value != "2.0" {
+			v.errorf(swagger, []string{"swagger"}, "unsupported version %q, must be \"2.0\"", swagger.Value)
+			return
+		}
+		v.version = 2
+	case oas != nil:
+		if !openapi3.MatchString(oas.Value) {
+			v.errorf(oas, []string{"openapi"}, "unsupported version %q, must be 3.x.y", oas.Value)
+			return
+		}
+		v.version = 3
+	default:
+		v.errorf(root, nil, "missing required field \"swagger\" or \"openapi\"")
+		return
+	}
+
+	v.required(root, nil, "info")
+	if v.version == 2 || child(root, "components") == nil && child(root, "webhooks") == nil {
+		v.required(root, nil, "paths")
+	}
+
+	Walk(root, func(key, value *yaml.Node) {
+		path := []string{key.Value}
+		switch key.Value {
+		case "info":
+			v.info(value, path)
+		case "paths":
+			v.paths(value, path)
+		case "host":
+			if v.str(value, path) && strings.Contains(value.Value, "://") {
+				v.errorf(value, path, "must not include a scheme")
+			}
+		case "basePath":
+			if v.str(value, path) && !strings.HasPrefix(value.Value, "/") {
+				v.errorf(value, path, "must start with /")
+			}
+		case "schemes":
+			if v.sequence(value, path) {
+				for i, scheme := range value.Content {
+					if !contains(schemes, scheme.Value) {
+						v.errorf(scheme, extend(path, strconv.Itoa(i)), "unknown scheme %q", scheme.Value)
+					}
+				}
+			}
+		case "servers":
+			v.servers(value, path)
+		case "definitions":
+			v.schemaMap(value, path)
+		case "parameters":
+			if v.mapping(value, path) {
+				Walk(value, func(name, param *yaml.Node) {
+					v.parameter(param, extend(path, name.Value))
+				})
+			}
+		case "responses":
+			if v.mapping(value, path) {
+				Walk(value, func(name, response *yaml.Node) {
+					v.response(response, extend(path, name.Value))
+				})
+			}
+		case "components":
+			v.components(value, path)
+		case "tags", "security":
+			v.sequence(value, path)
+		}
+	})
+}
+
+func (v *validator) info(n *yaml.Node, path []string) {
+	if !v.mapping(n, path) {
+		return
+	}
+	v.required(n, path, "title", "version")
+	if title := child(n, "title"); title != nil {
+		v.str(title, extend(path, "title"))
+	}
+	if version := child(n, "version"); version != nil && version.Kind != yaml.ScalarNode {
+		v.errorf(version, extend(path, "version"), "must be a string")
+	}
+}
+
+func (v *validator) servers(n *yaml.Node, path []string) {
+	if !v.sequence(n, path) {
+		return
+	}
+	for i, server := range n.Content {
+		serverPath := extend(path, strconv.Itoa(i))
+		if v.mapping(server, serverPath) {
+			v.required(server, serverPath, "url")
+		}
+	}
+}
+
+func (v *validator) paths(n *yaml.Node, path []string) {
+	if !v.mapping(n, path) {
+		return
+	}
+	Walk(n, func(key, item *yaml.Node) {
+		if strings.HasPrefix(key.Value, "x-") {
+			return
+		}
+		if !strings.HasPrefix(key.Value, "/") {
+			v.errorf(key, path, "path %q must start with /", key.Value)
+			return
+		}
+		v.pathItem(key.Value, item, extend(path, key.Value))
+	})
+}
+
+func (v *validator) pathItem(template string, n *yaml.Node, path []string) {
+	if !v.mapping(n, path) {
+		return
+	}
+
+	// Every template variable needs a path parameter, declared either for
+	// the whole path or for each operation.  Parameters pulled in with $ref
+	// can't be checked without resolving them, so they satisfy any variable.
+	declared, refs := v.pathParameters(child(n, "parameters"))
+
+	Walk(n, func(key, value *yaml.Node) {
+		itemPath := extend(path, key.Value)
+		switch {
+		case contains(methods, key.Value):
+			if key.Value == "trace" && v.version == 2 {
+				v.errorf(key, path, "unknown field %q", key.Value)
+				return
+			}
+			v.operation(value, itemPath)
+			opDeclared, opRefs := v.pathParameters(child(value, "parameters"))
+			if refs || opRefs {
+				return
+			}
+			for _, m := range pathTemplate.FindAllStringSubmatch(template, -1) {
+				if !declared[m[1]] && !opDeclared[m[1]] {
+					v.errorf(key, itemPath, "path parameter %q is not declared", m[1])
+				}
+			}
+		case key.Value == "parameters":
+			if v.sequence(value, itemPath) {
+				for i, param := range value.Content {
+					v.parameter(resolve(param), extend(itemPath, strconv.Itoa(i)))
+				}
+			}
+		case key.Value == "$ref":
+			v.str(value, itemPath)
+		case key.Value == "summary" || key.Value == "description" || key.Value == "servers":
+			if v.version == 2 {
+				v.errorf(key, path, "unknown field %q", key.Value)
+			}
+		case strings.HasPrefix(key.Value, "x-"):
+		default:
+			v.errorf(key, path, "unknown field %q", key.Value)
+		}
+	})
+}
+
+// pathParameters returns the names of the path parameters in a parameter
+// list and whether it contains any $ref.
+func (v *validator) pathParameters(params *yaml.Node) (map[string]bool, bool) {
+	names := make(map[string]bool)
+	refs := false
+	if params == nil || params.Kind != yaml.SequenceNode {
+		return names, refs
+	}
+	for _, param := range params.Content {
+		param = resolve(param)
+		if child(param, "$ref") != nil {
+			refs = true
+		}
+		if in := child(param, "in"); in != nil && in.Value == "path" {
+			if name := child(param, "name"); name != nil {
+				names[name.Value] = true
+			}
+		}
+	}
+	return names, refs
+}
+
+func (v *validator) operation(n *yaml.Node, path []string) {
+	if !v.mapping(n, path) {
+		return
+	}
+	v.required(n, path, "responses")
+
+	Walk(n, func(key, value *yaml.Node) {
+		opPath := extend(path, key.Value)
+		switch key.Value {
+		case "operationId":
+			if v.str(value, opPath) {
+				if v.operationIds[value.Value] {
+					v.errorf(value, opPath, "duplicate operationId %q", value.Value)
+				}
+				v.operationIds[value.Value] = true
+			}
+		case "parameters":
+			if v.sequence(value, opPath) {
+				for i, param := range value.Content {
+					v.parameter(resolve(param), extend(opPath, strconv.Itoa(i)))
+				}
+			}
+		case "requestBody":
+			if v.version == 2 {
+				v.errorf(key, path, "unknown field %q", key.Value)
+			} else if v.mapping(value, opPath) && child(value, "$ref") == nil {
+				v.required(value, opPath, "content")
+				v.content(child(value, "content"), extend(opPath, "content"))
+			}
+		case "responses":
+			v.responses(value, opPath)
+		case "deprecated":
+			v.boolean(value, opPath)
+		case "tags", "consumes", "produces", "schemes", "security":
+			v.sequence(value, opPath)
+		}
+	})
+}
+
+func (v *validator) parameter(n *yaml.Node, path []string) {
+	if !v.mapping(n, path) {
+		return
+	}
+	if ref := child(n, "$ref"); ref != nil {
+		v.str(ref, extend(path, "$ref"))
+		return
+	}
+	v.required(n, path, "name", "in")
+
+	in := child(n, "in")
+	if in == nil {
+		return
+	}
+	allowed := openapi3Params
+	if v.version == 2 {
+		allowed = swagger2Params
+	}
+	if !contains(allowed, in.Value) {
+		v.errorf(in, extend(path, "in"), "must be one of %s", strings.Join(allowed, ", "))
+		return
+	}
+
+	required := child(n, "required")
+	if required != nil {
+		v.boolean(required, extend(path, "required"))
+	}
+	if in.Value == "path" && (required == nil || required.Value != "true") {
+		v.errorf(n, path, "path parameters must have required: true")
+	}
+
+	switch {
+	case v.version == 2 && in.Value == "body":
+		v.required(n, path, "schema")
+	case v.version == 2:
+		v.required(n, path, "type")
+		if t := child(n, "type"); t != nil {
+			if t.Value != "file" && (!contains(schemaTypes, t.Value) || t.Value == "object" || t.Value == "null") {
+				v.errorf(t, extend(path, "type"), "invalid parameter type %q", t.Value)
+			}
+			if t.Value == "array" {
+				v.required(n, path, "items")
+			}
+		}
+	case child(n, "schema") == nil && child(n, "content") == nil:
+		v.errorf(n, path, "missing required field \"schema\" or \"content\"")
+	}
+	if schema := child(n, "schema"); schema != nil {
+		v.schema(schema, extend(path, "schema"))
+	}
+	if content := child(n, "content"); content != nil {
+		v.content(content, extend(path, "content"))
+	}
+}
+
+func (v *validator) responses(n *yaml.Node, path []string) {
+	if !v.mapping(n, path) {
+		return
+	}
+	if len(n.Content) == 0 {
+		v.errorf(n, path, "must define at least one response")
+	}
+	Walk(n, func(key, response *yaml.Node) {
+		if key.Value != "default" && !responseCode.MatchString(key.Value) && !strings.HasPrefix(key.Value, "x-") {
+			v.errorf(key, path, "invalid response code %q", key.Value)
+			return
+		}
+		v.response(response, extend(path, key.Value))
+	})
+}
+
+func (v *validator) response(n *yaml.Node, path []string) {
+	if !v.mapping(n, path) {
+		return
+	}
+	if ref := child(n, "$ref"); ref != nil {
+		v.str(ref, extend(path, "$ref"))
+		return
+	}
+	v.required(n, path, "description")
+	if schema := child(n, "schema"); schema != nil {
+		if v.version == 3 {
+			v.errorf(schema, path, "unknown field \"schema\", use content")
+		} else {
+			v.schema(schema, extend(path, "schema"))
+		}
+	}
+	if content := child(n, "content"); content != nil {
+		v.content(content, extend(path, "content"))
+	}
+}
+
+// content checks an OpenAPI 3 map of media types.
+func (v *validator) content(n *yaml.Node, path []string) {
+	if n == nil || !v.mapping(n, path) {
+		return
+	}
+	Walk(n, func(mediaType, value *yaml.Node) {
+		mediaPath := extend(path, mediaType.Value)
+		if v.mapping(value, mediaPath) {
+			if schema := child(value, "schema"); schema != nil {
+				v.schema(schema, extend(mediaPath, "schema"))
+			}
+		}
+	})
+}
+
+func (v *validator) components(n *yaml.Node, path []string) {
+	if !v.mapping(n, path) {
+		return
+	}
+	Walk(n, func(key, value *yaml.Node) {
+		componentPath := extend(path, key.Value)
+		switch key.Value {
+		case "schemas":
+			v.schemaMap(value, componentPath)
+		case "parameters":
+			if v.mapping(value, componentPath) {
+				Walk(value, func(name, param *yaml.Node) {
+					v.parameter(param, extend(componentPath, name.Value))
+				})
+			}
+		case "responses":
+			if v.mapping(value, componentPath) {
+				Walk(value, func(name, response *yaml.Node) {
+					v.response(response, extend(componentPath, name.Value))
+				})
+			}
+		default:
+			v.mapping(value, componentPath)
+		}
+	})
+}
+
+func (v *validator) schemaMap(n *yaml.Node, path []string) {
+	if !v.mapping(n, path) {
+		return
+	}
+	Walk(n, func(name, schema *yaml.Node) {
+		v.schema(schema, extend(path, name.Value))
+	})
+}
+
+func (v *validator) schema(n *yaml.Node, path []string) {
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!bool" && v.version == 3 {
+		return
+	}
+	if !v.mapping(n, path) {
+		return
+	}
+	if ref := child(n, "$ref"); ref != nil {
+		v.str(ref, extend(path, "$ref"))
+		return
+	}
+
+	if t := child(n, "type"); t != nil {
+		typePath := extend(path, "type")
+		types := []*yaml.Node{t}
+		if t.Kind == yaml.SequenceNode {
+			types = t.Content
+		}
+		for _, elem := range types {
+			if !contains(schemaTypes, elem.Value) {
+				v.errorf(elem, typePath, "invalid type %q", elem.Value)
+			} else if elem.Value == "array" && child(n, "items") == nil {
+				v.errorf(n, path, "missing required field \"items\" for an array")
+			}
+		}
+	}
+
+	Walk(n, func(key, value *yaml.Node) {
+		keyPath := extend(path, key.Value)
+		switch key.Value {
+		case "properties", "patternProperties":
+			v.schemaMap(value, keyPath)
+		case "items", "not", "additionalItems":
+			if value.Kind == yaml.SequenceNode {
+				for i, item := range value.Content {
+					v.schema(resolve(item), extend(keyPath, strconv.Itoa(i)))
+				}
+			} else {
+				v.schema(value, keyPath)
+			}
+		case "additionalProperties":
+			if value.Kind != yaml.ScalarNode || value.Tag != "!!bool" {
+				v.schema(value, keyPath)
+			}
+		case "allOf", "anyOf", "oneOf":
+			if v.sequence(value, keyPath) {
+				for i, item := range value.Content {
+					v.schema(resolve(item), extend(keyPath, strconv.Itoa(i)))
+				}
+			}
+		case "required":
+			if value.Kind == yaml.SequenceNode {
+				for i, name := range value.Content {
+					v.str(name, extend(keyPath, strconv.Itoa(i)))
+				}
+			} else {
+				v.errorf(value, keyPath, "must be an array of property names")
+			}
+		case "enum":
+			v.sequence(value, keyPath)
+		}
+	})
+}
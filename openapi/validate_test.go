@@ -0,0 +1,126 @@
+package openapi
+
+import (
+	"strings"
+	"testing"
+)
+
+const petstore = `swagger: "2.0"
+info:
+  title: Petstore
+  version: "1.0"
+basePath: /v1
+paths:
+  /pets/{petId}:
+    get:
+      operationId: getPet
+      parameters:
+        - name: petId
+          in: path
+          required: true
+          type: string
+      responses:
+        200:
+          description: A pet.
+          schema:
+            $ref: '#/definitions/Pet'
+definitions:
+  Pet:
+    type: object
+    required: [id]
+    properties:
+      id:
+        type: integer
+      tags:
+        type: array
+        items:
+          type: string
+`
+
+const petstore3 = `{
+	"openapi": "3.0.1",
+	"info": {"title": "Petstore", "version": "1.0"},
+	"paths": {
+		"/pets": {
+			"post": {
+				"requestBody": {
+					"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pet"}}}
+				},
+				"responses": {"201": {"description": "Created."}}
+			}
+		}
+	},
+	"components": {"schemas": {"Pet": {"type": "object"}}}
+}`
+
+func TestValidateValid(t *testing.T) {
+	for _, doc := range []string{petstore, petstore3} {
+		if errs := Validate([]byte(doc)); len(errs) > 0 {
+			t.Errorf("Unexpected errors %+v", errs)
+		}
+	}
+}
+
+func TestValidateInvalid(t *testing.T) {
+	tests := []struct {
+		doc     string
+		line    int
+		message string
+	}{
+		{"swagger: \"2.0\"\ninfo: [\n", 2, "did not find expected node content"},
+		{"{\n  \"openapi\": \"3.0.0\",\n  \"info\": }", 3, "invalid character"},
+		{"info:\n  title: x\n  version: \"1\"\npaths: {}\n", 1, `missing required field "swagger" or "openapi"`},
+		{"swagger: \"1.2\"\n", 1, "unsupported version"},
+		{strings.Replace(petstore, "  title: Petstore\n", "", 1), 3, `missing required field "title"`},
+		{strings.Replace(petstore, "          required: true\n", "", 1), 11, "required: true"},
+		{strings.Replace(petstore, "in: path", "in: cookie", 1), 12, "must be one of"},
+		{strings.Replace(petstore, "{petId}", "{id}", 1), 8, `path parameter "id" is not declared`},
+		{strings.Replace(petstore, "        200:", "        600:", 1), 16, "invalid response code"},
+		{strings.Replace(petstore, "          description: A pet.\n", "", 1), 17, `missing required field "description"`},
+		{strings.Replace(petstore, "type: integer", "type: int", 1), 26, `invalid type "int"`},
+		{strings.Replace(petstore, "        items:\n          type: string\n", "", 1), 28, `"items"`},
+		{strings.Replace(petstore, "/pets/{petId}", "pets/{petId}", 1), 7, "must start with /"},
+	}
+
+	for _, test := range tests {
+		errs := Validate([]byte(test.doc))
+		if len(errs) == 0 {
+			t.Errorf("Expected %q, got no errors", test.message)
+			continue
+		}
+		if errs[0].Line != test.line || !strings.Contains(errs[0].Message, test.message) {
+			t.Errorf("Expected %q on line %d, got %+v", test.message, test.line, errs)
+		}
+	}
+}
+
+func TestValidateFile(t *testing.T) {
+	for _, doc := range []string{petstore, "type: object\nproperties:\n  owner:\n    $ref: owner.yaml\n", `{"type": "string"}`} {
+		if errs := ValidateFile([]byte(doc)); len(errs) > 0 {
+			t.Errorf("Unexpected errors %+v", errs)
+		}
+	}
+
+	tests := []struct {
+		doc     string
+		line    int
+		message string
+	}{
+		{`{"type": }`, 1, "invalid character"},
+		{"- a\n- b\n", 1, "must be an object"},
+		{"type: object\ntype: string\n", 2, "duplicate key"},
+		{strings.Replace(petstore, "type: integer", "type: int", 1), 26, `invalid type "int"`},
+		{strings.Replace(petstore, "swagger:", "swager:", 1), 1, `missing required field "swagger" or "openapi"`},
+		{"paths: {}\n", 1, `missing required field "swagger" or "openapi"`},
+	}
+	for _, test := range tests {
+		errs := ValidateFile([]byte(test.doc))
+		if len(errs) == 0 {
+			t.Errorf("Expected %q, got no errors", test.message)
+			continue
+		}
+		if errs[0].Line != test.line || !strings.Contains(errs[0].Message, test.message) {
+			t.Errorf("Expected %q on line %d, got %+v", test.message, test.line, errs)
+		}
+	}
+}